@@ -9,14 +9,27 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-func StartAgents(agents []types.Agent) {
-	log.Info().Msg("Starting agents")
+// TickListener is anything besides the agents that runs on the shared tick, such as the broker.
+type TickListener interface {
+	SetTickChannel(tick <-chan time.Time)
+}
 
-	// Create a centralized aligned ticker (wall-clock aligned)
-	var period time.Duration = 10 * time.Minute
+// TickPeriod is the time between ticks: 10 minutes, 20 seconds in dev mode.
+// Ticks fall on multiples of it.
+func TickPeriod() time.Duration {
 	if utils.DevMode {
-		period = 20 * time.Second
+		return 20 * time.Second
 	}
+	return 10 * time.Minute
+}
+
+// StartAgents starts every agent on the shared tick, aligned to the clock. The
+// listeners receive the same ticks; they must be given before they start.
+func StartAgents(agents []types.Agent, clock types.Clock, listeners ...TickListener) {
+	log.Info().Msg("Starting agents")
+
+	// Create a centralized aligned ticker (clock aligned)
+	period := TickPeriod()
 
 	// Create per-agent tick channels and set them on agents
 	tickChans := make([]chan time.Time, 0, len(agents))
@@ -25,20 +38,22 @@ func StartAgents(agents []types.Agent) {
 		tickChans = append(tickChans, ch)
 		a.SetTickChannel(ch)
 	}
+	for _, l := range listeners {
+		ch := make(chan time.Time, 1)
+		tickChans = append(tickChans, ch)
+		l.SetTickChannel(ch)
+	}
 
 	// Broadcast aligned ticks to all agents
 	go func() {
 		for {
-			now := time.Now()
+			now := clock.Now()
 			next := now.Truncate(period).Add(period)
-			sleep := time.Until(next)
-			if sleep > 0 {
-				timer := time.NewTimer(sleep)
-				<-timer.C
-				timer.Stop()
+			if sleep := next.Sub(now); sleep > 0 {
+				<-clock.After(sleep)
 			}
 
-			t := time.Now()
+			t := clock.Now()
 			for _, ch := range tickChans {
 				select {
 				case ch <- t:
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+)
+
+// Cooldown rule names reported in CooldownError.Rule.
+const (
+	RuleSameSymbol   = "same_symbol"
+	RuleSameSide     = "same_side"
+	RuleOppositeSide = "opposite_side"
+)
+
+// CooldownRules configure how soon an agent may trade a symbol again. Zero
+// durations disable a rule.
+type CooldownRules struct {
+	SameSymbol   time.Duration // no trade of either side within this long of the last one
+	SameSide     time.Duration // no repeat BUY (or SELL) within this long of the last one
+	OppositeSide time.Duration // no BUY within this long of a SELL, or SELL of a BUY (wash trades)
+}
+
+// DefaultCooldownRules blocks opposite-side trades in a symbol for 30 minutes.
+func DefaultCooldownRules() CooldownRules {
+	return CooldownRules{OppositeSide: 30 * time.Minute}
+}
+
+// CooldownError explains why a trade was skipped.
+type CooldownError struct {
+	Symbol  string
+	Action  string
+	Rule    string
+	Last    time.Time // when the trade that started the cooldown was made
+	Until   time.Time // when the symbol may be traded again, zero while Pending
+	Pending bool      // the trade that started the cooldown has not filled yet
+}
+
+func (e *CooldownError) Error() string {
+	if e.Pending {
+		return fmt.Sprintf("%s %s skipped by the %s cooldown: the order submitted at %s is still open",
+			e.Action, e.Symbol, e.Rule, e.Last.Format(time.Kitchen))
+	}
+	return fmt.Sprintf("%s %s skipped by the %s cooldown: last traded at %s, allowed again at %s",
+		e.Action, e.Symbol, e.Rule, e.Last.Format(time.Kitchen), e.Until.Format(time.Kitchen))
+}
+
+// Cooldowns tracks the last BUY and SELL fill per symbol for one agent, and
+// the trades submitted but not filled yet, and checks new trades against its
+// rules. An open trade holds its cooldowns until it fills or fails. Any agent
+// type can own one.
+type Cooldowns struct {
+	mu      sync.Mutex
+	rules   CooldownRules
+	last    map[string]map[string]time.Time // symbol -> action -> last fill time
+	pending map[string]openTrade            // trade id -> submitted trade without a fill yet
+}
+
+// openTrade is a submitted trade holding its cooldowns until it fills.
+type openTrade struct {
+	symbol, action string
+	submitted      time.Time
+}
+
+// NewCooldowns creates an empty cooldown tracker with the given rules.
+func NewCooldowns(rules CooldownRules) *Cooldowns {
+	return &Cooldowns{rules: rules, last: make(map[string]map[string]time.Time), pending: make(map[string]openTrade)}
+}
+
+// SetRules replaces the cooldown rules; recorded trades are kept.
+func (c *Cooldowns) SetRules(rules CooldownRules) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rules = rules
+}
+
+// Check returns a *CooldownError if the trade falls inside a cooldown, or nil.
+// Liquidations are never held back.
+func (c *Cooldowns) Check(trade *types.Trade, now time.Time) error {
+	if trade.Priority == types.PriorityEmergency {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	opposite := "SELL"
+	if trade.Action == "SELL" {
+		opposite = "BUY"
+	}
+	last := c.last[trade.Symbol]
+
+	checks := []struct {
+		rule   string
+		window time.Duration
+		sides  []string
+	}{
+		{RuleOppositeSide, c.rules.OppositeSide, []string{opposite}},
+		{RuleSameSide, c.rules.SameSide, []string{trade.Action}},
+		{RuleSameSymbol, c.rules.SameSymbol, []string{trade.Action, opposite}},
+	}
+	for _, check := range checks {
+		if check.window <= 0 {
+			continue
+		}
+		for _, open := range c.pending {
+			if open.symbol == trade.Symbol && slices.Contains(check.sides, open.action) {
+				return &CooldownError{Symbol: trade.Symbol, Action: trade.Action, Rule: check.rule, Last: open.submitted, Pending: true}
+			}
+		}
+
+		var at time.Time
+		for _, side := range check.sides {
+			at = latest(at, last[side])
+		}
+		if at.IsZero() {
+			continue
+		}
+		if until := at.Add(check.window); now.Before(until) {
+			return &CooldownError{Symbol: trade.Symbol, Action: trade.Action, Rule: check.rule, Last: at, Until: until}
+		}
+	}
+	return nil
+}
+
+// Submit holds the cooldowns of a trade sent to the broker at the given time
+// until it is recorded as filled or cleared.
+func (c *Cooldowns) Submit(trade *types.Trade, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pending[trade.ID] = openTrade{symbol: trade.Symbol, action: trade.Action, submitted: at}
+}
+
+// Clear releases a submitted trade that failed or ended without a fill.
+func (c *Cooldowns) Clear(trade *types.Trade) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, trade.ID)
+}
+
+// Record starts the cooldowns for a trade filled at the given time, in place
+// of the ones it held while open.
+func (c *Cooldowns) Record(trade *types.Trade, at time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.pending, trade.ID)
+
+	sides, ok := c.last[trade.Symbol]
+	if !ok {
+		sides = make(map[string]time.Time)
+		c.last[trade.Symbol] = sides
+	}
+	sides[trade.Action] = at
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
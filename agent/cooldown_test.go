@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+)
+
+func TestCooldownsOppositeSide(t *testing.T) {
+	c := NewCooldowns(DefaultCooldownRules())
+	start := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	c.Record(&types.Trade{Symbol: "AAPL", Action: "BUY"}, start)
+
+	// another buy and a sell of a different symbol are fine
+	if err := c.Check(&types.Trade{Symbol: "AAPL", Action: "BUY"}, start.Add(time.Minute)); err != nil {
+		t.Errorf("same side Check() = %v, want nil", err)
+	}
+	if err := c.Check(&types.Trade{Symbol: "MSFT", Action: "SELL"}, start.Add(time.Minute)); err != nil {
+		t.Errorf("other symbol Check() = %v, want nil", err)
+	}
+
+	err := c.Check(&types.Trade{Symbol: "AAPL", Action: "SELL"}, start.Add(10*time.Minute))
+	var cooldownErr *CooldownError
+	if !errors.As(err, &cooldownErr) {
+		t.Fatalf("opposite side Check() = %v, want *CooldownError", err)
+	}
+	if cooldownErr.Rule != RuleOppositeSide || !cooldownErr.Until.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("got rule %s until %s, want %s until %s", cooldownErr.Rule, cooldownErr.Until, RuleOppositeSide, start.Add(30*time.Minute))
+	}
+
+	if err := c.Check(&types.Trade{Symbol: "AAPL", Action: "SELL"}, start.Add(30*time.Minute)); err != nil {
+		t.Errorf("Check() after the window = %v, want nil", err)
+	}
+
+	// liquidations go through regardless
+	liquidation := &types.Trade{Symbol: "AAPL", Action: "SELL", Priority: types.PriorityEmergency}
+	if err := c.Check(liquidation, start.Add(time.Minute)); err != nil {
+		t.Errorf("liquidation Check() = %v, want nil", err)
+	}
+}
+
+func TestCooldownsSameSymbolAndSide(t *testing.T) {
+	c := NewCooldowns(CooldownRules{SameSymbol: 5 * time.Minute, SameSide: 20 * time.Minute})
+	start := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+
+	c.Record(&types.Trade{Symbol: "AAPL", Action: "BUY"}, start)
+
+	tests := []struct {
+		action string
+		after  time.Duration
+		rule   string
+	}{
+		{"SELL", time.Minute, RuleSameSymbol},
+		{"SELL", 5 * time.Minute, ""},
+		{"BUY", 10 * time.Minute, RuleSameSide},
+		{"BUY", 20 * time.Minute, ""},
+	}
+	for _, tt := range tests {
+		err := c.Check(&types.Trade{Symbol: "AAPL", Action: tt.action}, start.Add(tt.after))
+		var cooldownErr *CooldownError
+		switch {
+		case tt.rule == "" && err != nil:
+			t.Errorf("%s after %s: Check() = %v, want nil", tt.action, tt.after, err)
+		case tt.rule != "" && (!errors.As(err, &cooldownErr) || cooldownErr.Rule != tt.rule):
+			t.Errorf("%s after %s: Check() = %v, want the %s rule", tt.action, tt.after, err, tt.rule)
+		}
+	}
+}
+
+func TestCooldownsHeldWhileOpen(t *testing.T) {
+	c := NewCooldowns(DefaultCooldownRules())
+	start := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	buy := &types.Trade{ID: "b1", Symbol: "AAPL", Action: "BUY"}
+	sell := &types.Trade{Symbol: "AAPL", Action: "SELL"}
+
+	// an open buy blocks the sell however long it rests
+	c.Submit(buy, start)
+	err := c.Check(sell, start.Add(time.Hour))
+	var cooldownErr *CooldownError
+	if !errors.As(err, &cooldownErr) || !cooldownErr.Pending || !cooldownErr.Last.Equal(start) {
+		t.Fatalf("Check() with the buy open = %v, want a pending cooldown from %s", err, start)
+	}
+
+	// once it fills the window runs from the fill
+	filled := start.Add(2 * time.Hour)
+	c.Record(buy, filled)
+	if err := c.Check(sell, filled.Add(10*time.Minute)); !errors.As(err, &cooldownErr) || cooldownErr.Pending || !cooldownErr.Until.Equal(filled.Add(30*time.Minute)) {
+		t.Errorf("Check() after the fill = %v, want the cooldown until %s", err, filled.Add(30*time.Minute))
+	}
+
+	// a buy that never fills starts nothing
+	other := &types.Trade{ID: "b2", Symbol: "MSFT", Action: "BUY"}
+	c.Submit(other, start)
+	c.Clear(other)
+	if err := c.Check(&types.Trade{Symbol: "MSFT", Action: "SELL"}, start); err != nil {
+		t.Errorf("Check() after the failed buy = %v, want nil", err)
+	}
+}
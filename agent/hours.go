@@ -0,0 +1,47 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+)
+
+// TradingHours is when an agent trades. The zero value trades through the
+// whole regular session and not at all outside it.
+type TradingHours struct {
+	// ExtendedHours also trades in the pre-market and after-hours sessions. Those
+	// orders are sent as extended hours orders, which must be day limit orders,
+	// so an agent placing market orders only gets rejections there.
+	ExtendedHours bool
+
+	// SkipBeforeClose stops trading this long before the regular close, e.g. to
+	// stay out of the closing auction.
+	SkipBeforeClose time.Duration
+}
+
+// Allows reports whether the agent may trade now, and why not when it may not.
+func (h TradingHours) Allows(clock types.Clock) (bool, string) {
+	switch clock.Phase() {
+	case types.PhaseRegular:
+		if h.SkipBeforeClose > 0 && clock.NextClose().Sub(clock.Now()) <= h.SkipBeforeClose {
+			return false, "too close to the close"
+		}
+		return true, ""
+	case types.PhasePreMarket, types.PhaseAfterHours:
+		if h.ExtendedHours {
+			return true, ""
+		}
+		return false, "extended hours"
+	default:
+		return false, "market closed"
+	}
+}
+
+// Extended reports whether trades placed now must be extended hours orders.
+func (h TradingHours) Extended(clock types.Clock) bool {
+	if !h.ExtendedHours {
+		return false
+	}
+	phase := clock.Phase()
+	return phase == types.PhasePreMarket || phase == types.PhaseAfterHours
+}
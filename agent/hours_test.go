@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/utils"
+)
+
+func TestTradingHoursAllows(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2025, 1, 3, hour, minute, 0, 0, utils.MarketLocation)
+	}
+	tests := []struct {
+		name     string
+		hours    TradingHours
+		now      time.Time
+		allowed  bool
+		extended bool
+	}{
+		{"regular session", TradingHours{}, at(10, 0), true, false},
+		{"pre-market", TradingHours{}, at(8, 0), false, false},
+		{"pre-market opted in", TradingHours{ExtendedHours: true}, at(8, 0), true, true},
+		{"after hours opted in", TradingHours{ExtendedHours: true}, at(18, 0), true, true},
+		{"overnight opted in", TradingHours{ExtendedHours: true}, at(22, 0), false, false},
+		{"last minutes skipped", TradingHours{SkipBeforeClose: 15 * time.Minute}, at(15, 50), false, false},
+		{"before the skipped minutes", TradingHours{SkipBeforeClose: 15 * time.Minute}, at(15, 40), true, false},
+	}
+	for _, tt := range tests {
+		clock := services.NewFakeClock(tt.now)
+		if ok, reason := tt.hours.Allows(clock); ok != tt.allowed {
+			t.Errorf("%s: Allows() = %v (%s), want %v", tt.name, ok, reason, tt.allowed)
+		}
+		if got := tt.hours.Extended(clock); got != tt.extended {
+			t.Errorf("%s: Extended() = %v, want %v", tt.name, got, tt.extended)
+		}
+	}
+}
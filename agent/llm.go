@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
@@ -11,283 +13,233 @@ import (
 	"github.com/dickeyy/cis-320/types"
 	"github.com/dickeyy/cis-320/utils"
 	"github.com/rs/zerolog/log"
-	"github.com/shopspring/decimal"
 )
 
-// LLMStrategist is an agent that makes buy/sell/hold decisions based on a LLM.
-type LLMStrategist struct {
-	Name    string
-	Symbols []string
-	// Embed AgentState to manage common agent properties
-	types.AgentState
-	broker       types.Broker
-	AlpacaClient *alpaca.Client
-	tick         <-chan time.Time
-	LastError    error
+// LLMStrategy makes buy/sell/hold decisions based on a LLM.
+type LLMStrategy struct {
+	Provider    services.LLMProvider // services.AI when nil
+	Model       string               // services.DefaultAIModel when empty
+	Temperature float32              // the provider's default when zero
+	MaxTokens   int                  // of each answer, unlimited when zero
+	// Tools lets the model call the services.AITools for market data and its
+	// positions before deciding, for up to MaxToolSteps turns within ToolTimeout
+	// (the services defaults when zero)
+	Tools        bool
+	MaxToolSteps int
+	ToolTimeout  time.Duration
+	responses    []string // the model's last responses, up to the utils.MaxPreviousResponses sent back with every prompt
 }
 
-func NewLLMAgent(name string) *LLMStrategist {
-	alpacaClient, account, holdings, err := services.InitializeAlpaca(os.Getenv("ALPACA_KEY_LLM"), os.Getenv("ALPACA_SECRET_LLM"))
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing Alpaca")
+// newLLMStrategy accepts "provider" (openrouter, openai or mock), "base_url" and
+// "api_key_env" (the env var holding the key) for an openai provider, "model",
+// "temperature", "max_tokens", "tools" (true by default), "max_tool_steps" and
+// "tool_timeout" parameters.
+func newLLMStrategy(params Params) (Strategy, error) {
+	if err := params.Only("provider", "base_url", "api_key_env", "model", "temperature", "max_tokens", "tools", "max_tool_steps", "tool_timeout"); err != nil {
+		return nil, err
 	}
-
-	return &LLMStrategist{
-		Name:    name,
-		Symbols: utils.Symbols,
-		AgentState: types.AgentState{
-			Account:  *account,
-			Holdings: holdings,
-		},
-		AlpacaClient: alpacaClient,
+	var config services.ProviderConfig
+	var err error
+	if config.Type, err = params.String("provider", services.ProviderOpenRouter); err != nil {
+		return nil, err
 	}
-}
-
-// SetBroker sets the broker for the LLM agent
-func (a *LLMStrategist) SetBroker(broker types.Broker) {
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
-	a.broker = broker
-}
-
-// SetTickChannel sets the shared tick channel for the LLM agent
-func (a *LLMStrategist) SetTickChannel(tick <-chan time.Time) {
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
-	a.tick = tick
-}
-
-// Run starts the LLM agent's primary trading loop
-func (a *LLMStrategist) Run(ctx context.Context) error {
-	var tickC <-chan time.Time
-	if a.tick != nil {
-		tickC = a.tick
-	} else {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		tickC = ticker.C
+	if config.BaseURL, err = params.String("base_url", ""); err != nil {
+		return nil, err
 	}
-
-	for {
-		select {
-		case <-tickC:
-			// make sure the market is open
-			if !utils.IsTradingHours() {
-				log.Debug().Str("agent", a.Name).Msg("Not trading hours, skipping tick")
-				continue
-			}
-
-			// get a trade decision
-			log.Info().Str("agent", a.Name).Msg("Making a decision")
-			a.updateAgentState()
-			trade := a.makeDecision(ctx)
-
-			// process trade
-			if trade != nil {
-				// submit the trade to the broker with a completion callback
-				a.broker.SubmitTrade(ctx, trade, a.onComplete, a.AlpacaClient)
-
-				log.Info().Str("agent", a.Name).Str("action", trade.Action).Str("order_id", trade.ID).Msg("Submitted order to broker")
-			} else {
-				log.Info().Str("agent", a.Name).Msg("No trade made")
-				holdTrade := types.Trade{
-					ID:        utils.GenerateOrderID(),
-					AlpacaID:  "",
-					Symbol:    "",
-					Amount:    nil,
-					Quantity:  nil,
-					Action:    "HOLD",
-					Timestamp: time.Now(),
-					AgentName: a.Name,
-				}
-				a.onComplete(nil, &holdTrade, nil)
-			}
-		case <-ctx.Done():
-			log.Info().Str("agent", a.Name).Msg("Shutting down LLM Agent")
-			return nil
-		}
-
+	keyEnv, err := params.String("api_key_env", "")
+	if err != nil {
+		return nil, err
 	}
-}
-
-func (a *LLMStrategist) Stop(ctx context.Context) error {
-	return nil
-}
-
-// GetName returns the name of the RNG Strategist.
-func (a *LLMStrategist) GetName() string {
-	return a.Name
-}
-
-// GetHoldings returns the agent current holdings
-func (a *LLMStrategist) GetHoldings(ctx context.Context) ([]alpaca.Position, error) {
-	return a.AgentState.Holdings, nil
-}
-
-// GetBuyingPower returns the agent current buying power
-func (a *LLMStrategist) GetBuyingPower(ctx context.Context) (decimal.Decimal, error) {
-	return a.AgentState.Account.BuyingPower, nil
-}
-
-// makeDecision handles the agent's core algorithm
-func (a *LLMStrategist) makeDecision(ctx context.Context) *types.Trade {
-	// snapshot state under lock
-	a.AgentState.Mu.Lock()
-	account := a.AgentState.Account
-	holdings := make([]alpaca.Position, len(a.AgentState.Holdings))
-	copy(holdings, a.AgentState.Holdings)
-	lastError := a.LastError
-	a.AgentState.Mu.Unlock()
-
-	// create temp state for AI call
-	tempState := &types.AgentState{
-		Account:  account,
-		Holdings: holdings,
+	if keyEnv != "" {
+		config.APIKey = os.Getenv(keyEnv)
 	}
-
-	// get a trade decision from the ai
-	tradeDecision, err := services.GetAITradeDecision(ctx, tempState, lastError)
+	provider, err := services.NewLLMProvider(config)
 	if err != nil {
-		log.Error().Err(err).Str("agent", a.Name).Msg("Error getting AI trade decision")
-		return nil
+		return nil, err
 	}
 
-	// validate the trade
-	err = a.validateTradeDecision(tradeDecision)
-	if err != nil && tradeDecision.Action != "NONE" {
-		log.Error().Err(err).Str("agent", a.Name).Msg("Error validating trade decision")
-		return nil
+	model, err := params.String("model", services.DefaultAIModel)
+	if err != nil {
+		return nil, err
 	}
-
-	tradeID := utils.GenerateOrderID()
-	err = services.SaveAIReasoning(a.Name, tradeDecision.Reasoning, tradeID, ctx)
+	temperature, err := params.Float("temperature", 0)
 	if err != nil {
-		log.Error().Err(err).Str("agent", a.Name).Msg("Error saving AI reasoning")
-		return nil
+		return nil, err
 	}
-
-	switch tradeDecision.Action {
-	case "BUY":
-		return &types.Trade{
-			ID:        tradeID,
-			AlpacaID:  "",
-			Symbol:    tradeDecision.Symbol,
-			Amount:    tradeDecision.Amount,
-			Action:    tradeDecision.Action,
-			Timestamp: time.Now(),
-			AgentName: a.Name,
-		}
-	case "SELL":
-		return &types.Trade{
-			ID:        tradeID,
-			AlpacaID:  "",
-			Symbol:    tradeDecision.Symbol,
-			Quantity:  tradeDecision.Quantity,
-			Action:    tradeDecision.Action,
-			Timestamp: time.Now(),
-			AgentName: a.Name,
-		}
-	default:
-		return nil
+	if temperature < 0 || temperature > 2 {
+		return nil, fmt.Errorf("temperature must be between 0 and 2, got %v", temperature)
 	}
-}
-
-func (a *LLMStrategist) onComplete(trade *types.Trade, processed *types.Trade, err error) {
+	maxTokens, err := params.Uint64("max_tokens", 0)
 	if err != nil {
-		// Save the error for future decision making
-		a.AgentState.Mu.Lock()
-		a.LastError = err
-		a.AgentState.Mu.Unlock()
-
-		if trade != nil {
-			log.Error().Err(err).Str("agent", a.Name).Str("order_id", trade.ID).Msg("Trade failed or was rejected")
-		} else {
-			log.Error().Err(err).Str("agent", a.Name).Msg("Trade failed or was rejected")
-		}
-		return
+		return nil, err
 	}
-
-	if processed == nil {
-		if trade != nil {
-			log.Error().Str("agent", a.Name).Str("order_id", trade.ID).Msg("Broker completed with nil trade")
-		} else {
-			log.Error().Str("agent", a.Name).Msg("Broker completed with nil trade")
-		}
-		return
+	tools, err := params.Bool("tools", true)
+	if err != nil {
+		return nil, err
 	}
-
-	// wait for 5 seconds to make sure Alpaca fills the order
-	time.Sleep(5 * time.Second)
-
-	// perform state updates only after broker finished processing
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
-	a.updateAgentState()
-
-	// Clear any previous error since this trade succeeded
-	a.LastError = nil
-
-	err = services.SaveTrade(processed, context.Background())
+	steps, err := params.Uint64("max_tool_steps", services.DefaultAIToolSteps)
 	if err != nil {
-		log.Error().Err(err).Str("agent", a.Name).Str("order_id", processed.ID).Msg("Error saving trade")
+		return nil, err
 	}
+	timeout, err := params.Duration("tool_timeout", services.DefaultAIToolTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &LLMStrategy{
+		Provider:     provider,
+		Model:        model,
+		Temperature:  float32(temperature),
+		MaxTokens:    int(maxTokens),
+		Tools:        tools,
+		MaxToolSteps: int(steps),
+		ToolTimeout:  timeout,
+	}, nil
+}
 
-	log.Info().Str("agent", a.Name).Str("order_id", processed.ID).Msg("State updated and saved for processed trade")
+// NewLLMAgent creates an agent trading with the LLM strategy on the default model, with tools.
+func NewLLMAgent(name string, venue types.ExecutionVenue, clock types.Clock) *StrategyAgent {
+	return NewStrategyAgent(name, &LLMStrategy{Model: services.DefaultAIModel, Tools: true}, venue, clock)
 }
 
-// updateAgentState updates the agent state with the latest account and holdings from Alpaca
-func (a *LLMStrategist) updateAgentState() {
-	// fetch the latest account and holdings from alpaca
-	account, err := services.GetAccount(a.AlpacaClient)
+// Decide asks the model for this tick's actions. Each BUY or SELL becomes a
+// trade and a LIQUIDATE one SELL per holding, in the order the model gave them;
+// NONE actions are dropped. Invalid actions are still returned, so the agent
+// rejects them and the model hears why on the next tick.
+func (s *LLMStrategy) Decide(ctx context.Context, snap Snapshot) ([]Decision, error) {
+	// create temp state for AI call
+	tempState := &types.AgentState{
+		Account:  snap.Account,
+		Holdings: snap.Holdings,
+	}
+
+	request := services.AIRequest{
+		Provider:          s.Provider,
+		Model:             s.Model,
+		Temperature:       s.Temperature,
+		MaxTokens:         s.MaxTokens,
+		AgentState:        tempState,
+		PreviousResponses: s.responses,
+		LastError:         snap.LastError,
+		LastActions:       snap.LastActions,
+		Symbols:           snap.Symbols,
+	}
+	if s.Tools {
+		request.Tools = &services.AITools{
+			Data:     services.Market,
+			Holdings: snap.Holdings,
+			Symbols:  snap.Symbols,
+			MaxSteps: s.MaxToolSteps,
+			Timeout:  s.ToolTimeout,
+		}
+	}
+
+	// get the trade decisions from the ai
+	response, err := services.GetAITradeDecision(ctx, request)
 	if err != nil {
-		log.Error().Err(err).Msg("Error getting account")
+		return nil, fmt.Errorf("getting AI trade decision: %w", err)
+	}
+	s.responses = append(s.responses, response.Content)
+	if over := len(s.responses) - utils.MaxPreviousResponses; over > 0 {
+		s.responses = slices.Delete(s.responses, 0, over)
 	}
-	holdings, err := services.GetHoldings(a.AlpacaClient)
+	tradeDecisions := response.Decisions
+
+	decisionID := utils.GenerateOrderID()
+	err = services.SaveAIReasoning(snap.AgentName, decisionID, response, ctx)
 	if err != nil {
-		log.Error().Err(err).Msg("Error getting holdings")
+		return nil, fmt.Errorf("saving AI reasoning: %w", err)
+	}
+	// the day is the snapshot's, so backtests roll up by simulated day
+	if err := services.RecordAIUsage(snap.AgentName, snap.Now, response.Usage, ctx); err != nil {
+		log.Error().Err(err).Str("agent", snap.AgentName).Msg("Error saving AI usage")
 	}
 
-	// update the agent state
-	a.AgentState.Account = *account
-	a.AgentState.Holdings = holdings
-}
+	var decisions []Decision
+	for i := range tradeDecisions.Actions {
+		d := &tradeDecisions.Actions[i]
+		// the trade ids derive from the decision's id so the reasoning stays linked to them
+		actionID := decisionID
+		if len(tradeDecisions.Actions) > 1 {
+			actionID = fmt.Sprintf("%s-%d", decisionID, i+1)
+		}
+		reason := d.Reasoning
+		if reason == "" {
+			reason = tradeDecisions.Reasoning
+		}
 
-func (a *LLMStrategist) getHolding(symbol string) (alpaca.Position, error) {
-	for _, holding := range a.AgentState.Holdings {
-		if holding.Symbol == symbol {
-			return holding, nil
+		switch d.Action {
+		case "NONE", "HOLD", "":
+			continue
+		case "LIQUIDATE":
+			trades := liquidationTrades(snap.AgentName, actionID, snap.Holdings, snap.Now)
+			if len(trades) == 0 {
+				log.Info().Str("agent", snap.AgentName).Msg("Nothing to liquidate")
+			}
+			for _, trade := range trades {
+				decisions = append(decisions, Decision{Trade: trade, Reason: reason})
+			}
+		default:
+			trade := tradeFromDecision(d)
+			trade.ID = actionID
+			decisions = append(decisions, Decision{Trade: trade, Reason: reason})
 		}
 	}
-	return alpaca.Position{}, fmt.Errorf("holding not found")
+	return decisions, nil
 }
 
-// validateTradeDecision validates the trade decision
-func (a *LLMStrategist) validateTradeDecision(tradeDecision *types.TradeDecision) error {
-	if tradeDecision.Symbol == "" {
-		return fmt.Errorf("symbol is required")
-	}
+// liquidationTrades sells every available share at market, ahead of other
+// agents' queued orders. The trade ids derive from the decision's id so the reasoning
+// stays linked to them.
+func liquidationTrades(agentName, decisionID string, holdings []alpaca.Position, now time.Time) []*types.Trade {
+	trades := make([]*types.Trade, 0, len(holdings))
+	for _, holding := range holdings {
+		if !holding.QtyAvailable.IsPositive() {
+			continue
+		}
+		qty := holding.QtyAvailable
+		trades = append(trades, &types.Trade{
+			ID:        fmt.Sprintf("%s-%d", decisionID, len(trades)+1),
+			Symbol:    holding.Symbol,
+			Quantity:  &qty,
+			Action:    "SELL",
+			Priority:  types.PriorityEmergency,
+			Timestamp: now,
+			AgentName: agentName,
+		})
+	}
+	return trades
+}
 
-	switch tradeDecision.Action {
+// tradeFromDecision maps the model's decision, including its order instructions, onto a trade.
+func tradeFromDecision(d *types.TradeDecision) *types.Trade {
+	trade := &types.Trade{
+		Symbol:       d.Symbol,
+		Action:       d.Action,
+		Price:        d.Price,
+		OrderType:    strings.ToLower(strings.TrimSpace(d.OrderType)),
+		LimitPrice:   d.LimitPrice,
+		StopPrice:    d.StopPrice,
+		TrailPercent: d.TrailPercent,
+		TimeInForce:  strings.ToLower(strings.TrimSpace(d.TimeInForce)),
+		TakeProfit:   d.TakeProfit,
+		StopLoss:     d.StopLoss,
+	}
+
+	switch d.Action {
 	case "BUY":
-		if tradeDecision.Amount == nil || tradeDecision.Amount.IsZero() {
-			return fmt.Errorf("amount is required")
-		}
-		if tradeDecision.Amount.GreaterThan(a.AgentState.Account.BuyingPower) {
-			return fmt.Errorf("amount is greater than buying power")
+		// buys are sized in dollars, unless the model gave an explicit share count
+		trade.Amount = d.Amount
+		if d.Amount == nil {
+			trade.Quantity = d.Quantity
 		}
 	case "SELL":
-		if tradeDecision.Quantity == nil || tradeDecision.Quantity.IsZero() {
-			return fmt.Errorf("quantity is required")
-		}
-		// get the holding for the symbol
-		holding, err := a.getHolding(tradeDecision.Symbol)
-		if err != nil {
-			return fmt.Errorf("holding not found")
-		}
-		if tradeDecision.Quantity.GreaterThan(holding.QtyAvailable) {
-			return fmt.Errorf("quantity is greater than available quantity")
-		}
+		trade.Quantity = d.Quantity
+	}
+
+	// the price the model quotes is its limit when it asks for a limit order without one
+	if (trade.OrderType == types.OrderTypeLimit || trade.OrderType == types.OrderTypeStopLimit) && trade.LimitPrice == nil {
+		trade.LimitPrice = d.Price
 	}
 
-	return nil
+	return trade
 }
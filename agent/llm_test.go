@@ -0,0 +1,68 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+// TestMain reads the prompt templates from the repository root.
+func TestMain(m *testing.M) {
+	utils.PromptsDir = filepath.Join("..", "prompts")
+	os.Exit(m.Run())
+}
+
+func TestLLMStrategyDecideMockProvider(t *testing.T) {
+	mock := services.NewMockProvider(`{"reasoning": "rotate", "actions": [
+		{"action": "SELL", "symbol": "AAPL", "quantity": "2"},
+		{"action": "NONE"},
+		{"action": "BUY", "symbol": "NVDA", "amount": "250", "reasoning": "momentum"}
+	]}`)
+	s := &LLMStrategy{Provider: mock, Model: "mock-model", Temperature: 0.1}
+	qty := decimal.NewFromInt(5)
+	snap := Snapshot{
+		AgentName: "LLM_Test",
+		Now:       time.Date(2025, 1, 2, 15, 0, 0, 0, time.UTC),
+		Holdings:  []alpaca.Position{{Symbol: "AAPL", QtyAvailable: qty}},
+	}
+
+	decisions, err := s.Decide(context.Background(), snap)
+	if err != nil {
+		t.Fatalf("Decide() error = %v", err)
+	}
+	if len(decisions) != 2 {
+		t.Fatalf("Decide() = %d decisions, want the SELL and the BUY", len(decisions))
+	}
+	sell, buy := decisions[0], decisions[1]
+	if sell.Trade.Action != "SELL" || sell.Trade.Symbol != "AAPL" || !sell.Trade.Quantity.Equal(decimal.NewFromInt(2)) || sell.Reason != "rotate" {
+		t.Errorf("first decision = %+v, want SELL 2 AAPL for the overall reasoning", sell)
+	}
+	if buy.Trade.Action != "BUY" || buy.Trade.Symbol != "NVDA" || !buy.Trade.Amount.Equal(decimal.NewFromInt(250)) || buy.Reason != "momentum" {
+		t.Errorf("second decision = %+v, want BUY $250 of NVDA for its own reasoning", buy)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 1 || requests[0].Model != "mock-model" || requests[0].Temperature != 0.1 {
+		t.Errorf("mock requests = %+v, want one to mock-model at 0.1", requests)
+	}
+}
+
+func TestLLMStrategyKeepsLastResponses(t *testing.T) {
+	s := &LLMStrategy{Provider: services.NewMockProvider(), Model: "mock-model"}
+	snap := Snapshot{AgentName: "LLM_Test", Now: time.Date(2025, 1, 2, 15, 0, 0, 0, time.UTC)}
+	for i := 0; i < utils.MaxPreviousResponses+5; i++ {
+		if _, err := s.Decide(context.Background(), snap); err != nil {
+			t.Fatalf("Decide() error = %v", err)
+		}
+	}
+	if len(s.responses) != utils.MaxPreviousResponses {
+		t.Errorf("kept %d responses, want the last %d", len(s.responses), utils.MaxPreviousResponses)
+	}
+}
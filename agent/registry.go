@@ -0,0 +1,163 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Params are an agent's strategy parameters as read from the agents config.
+type Params map[string]any
+
+// String returns the string parameter key, or def when it is not set.
+func (p Params) String(key, def string) (string, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter %s must be a string, got %T", key, v)
+	}
+	return s, nil
+}
+
+// Uint64 returns the non-negative integer parameter key, or def when it is not set.
+func (p Params) Uint64(key string, def uint64) (uint64, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		if n >= 0 {
+			return uint64(n), nil
+		}
+	case int64:
+		if n >= 0 {
+			return uint64(n), nil
+		}
+	case uint64:
+		return n, nil
+	case float64:
+		if n >= 0 && n == math.Trunc(n) && n < math.MaxUint64 {
+			return uint64(n), nil
+		}
+	}
+	return 0, fmt.Errorf("parameter %s must be a non-negative integer, got %v", key, v)
+}
+
+// Float returns the number parameter key, or def when it is not set.
+func (p Params) Float(key string, def float64) (float64, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case uint64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("parameter %s must be a number, got %v", key, v)
+}
+
+// Bool returns the boolean parameter key, or def when it is not set.
+func (p Params) Bool(key string, def bool) (bool, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("parameter %s must be true or false, got %v", key, v)
+	}
+	return b, nil
+}
+
+// Duration returns the duration parameter key, e.g. "90s", or def when it is not set.
+func (p Params) Duration(key string, def time.Duration) (time.Duration, error) {
+	v, ok := p[key]
+	if !ok || v == nil {
+		return def, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return 0, fmt.Errorf("parameter %s must be a duration like 90s, got %v", key, v)
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("parameter %s must be a duration like 90s, got %q", key, s)
+	}
+	return d, nil
+}
+
+// Only returns an error naming the first parameter that is not one of known,
+// so a misspelled parameter is not silently ignored.
+func (p Params) Only(known ...string) error {
+	keys := make([]string, 0, len(p))
+	for key := range p {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !slices.Contains(known, key) {
+			return fmt.Errorf("unknown parameter %s", key)
+		}
+	}
+	return nil
+}
+
+// StrategyFactory creates a strategy from an agent's parameters.
+type StrategyFactory func(params Params) (Strategy, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]StrategyFactory{
+		"rng": newRNGStrategy,
+		"llm": newLLMStrategy,
+	}
+)
+
+// RegisterStrategy makes a strategy type available to the agents config under name.
+func RegisterStrategy(name string, factory StrategyFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(name)] = factory
+}
+
+// NewStrategy creates a strategy of the registered type name.
+func NewStrategy(name string, params Params) (Strategy, error) {
+	registryMu.RLock()
+	factory, ok := registry[strings.ToLower(name)]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown strategy %q, want one of %s", name, strings.Join(Strategies(), ", "))
+	}
+	strategy, err := factory(params)
+	if err != nil {
+		return nil, fmt.Errorf("strategy %s: %w", name, err)
+	}
+	return strategy, nil
+}
+
+// Strategies returns the registered strategy types, sorted.
+func Strategies() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type holdStrategy struct{}
+
+func (holdStrategy) Decide(ctx context.Context, snap Snapshot) ([]Decision, error) { return nil, nil }
+
+func TestNewStrategy(t *testing.T) {
+	llm, err := NewStrategy("LLM", Params{"model": "openai/gpt-4o"})
+	if err != nil {
+		t.Fatalf("NewStrategy(llm) error = %v", err)
+	}
+	if model := llm.(*LLMStrategy).Model; model != "openai/gpt-4o" {
+		t.Errorf("Model = %q, want openai/gpt-4o", model)
+	}
+
+	if _, err := NewStrategy("rng", Params{"modle": "x"}); err == nil {
+		t.Error("NewStrategy(rng) with an unknown parameter succeeded")
+	}
+	if _, err := NewStrategy("llm", Params{"model": 4}); err == nil {
+		t.Error("NewStrategy(llm) with a numeric model succeeded")
+	}
+	if _, err := NewStrategy("hold", nil); err == nil {
+		t.Error("NewStrategy(hold) succeeded before it was registered")
+	}
+
+	RegisterStrategy("hold", func(params Params) (Strategy, error) { return holdStrategy{}, nil })
+	if _, err := NewStrategy("hold", nil); err != nil {
+		t.Errorf("NewStrategy(hold) after registering error = %v", err)
+	}
+}
+
+func TestNewLLMStrategyToolParams(t *testing.T) {
+	s, err := NewStrategy("llm", Params{"tools": false, "max_tool_steps": 3, "tool_timeout": "30s"})
+	if err != nil {
+		t.Fatalf("NewStrategy(llm) error = %v", err)
+	}
+	if llm := s.(*LLMStrategy); llm.Tools || llm.MaxToolSteps != 3 || llm.ToolTimeout != 30*time.Second {
+		t.Errorf("LLMStrategy = %+v, want tools off, 3 steps and 30s", llm)
+	}
+	if _, err := NewStrategy("llm", Params{"tool_timeout": 30}); err == nil {
+		t.Error("NewStrategy(llm) with a numeric tool_timeout succeeded")
+	}
+}
+
+func TestNewLLMStrategyProviderParams(t *testing.T) {
+	t.Setenv("TEST_LOCAL_KEY", "secret")
+	s, err := NewStrategy("llm", Params{
+		"provider":    "openai",
+		"base_url":    "http://localhost:11434/v1",
+		"api_key_env": "TEST_LOCAL_KEY",
+		"model":       "llama3.1:8b",
+		"temperature": 0.3,
+		"max_tokens":  1024,
+	})
+	if err != nil {
+		t.Fatalf("NewStrategy(llm) error = %v", err)
+	}
+	llm := s.(*LLMStrategy)
+	if llm.Provider == nil || llm.Provider.Name() != "openai:http://localhost:11434/v1" {
+		t.Errorf("Provider = %v, want the local endpoint", llm.Provider)
+	}
+	if llm.Model != "llama3.1:8b" || llm.Temperature != 0.3 || llm.MaxTokens != 1024 {
+		t.Errorf("LLMStrategy = %+v, want llama3.1:8b at 0.3 with 1024 tokens", llm)
+	}
+
+	for _, params := range []Params{
+		{"provider": "openai"},
+		{"provider": "claude"},
+		{"temperature": 3},
+		{"max_tokens": -1},
+	} {
+		if _, err := NewStrategy("llm", params); err == nil {
+			t.Errorf("NewStrategy(llm, %v) succeeded", params)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+)
+
+// ReplayRNG makes the recorded RNG decisions again from their seeds and the
+// states they were made on, in order, and checks each comes out the same. A
+// record with Seq 0 starts a new run. It returns how many decisions matched,
+// and an error at the first that did not or when records are missing.
+func ReplayRNG(records []RNGRecord) (int, error) {
+	var (
+		strategy *RNGStrategy
+		symbols  []string
+	)
+	for i, record := range records {
+		if record.Seq == 0 {
+			strategy = NewRNGStrategy(record.Seed)
+			if record.Config != nil {
+				strategy.Config = *record.Config
+			}
+		}
+		switch {
+		case strategy == nil:
+			return i, fmt.Errorf("record %d: decision %d of seed %d without the decisions before it", i, record.Seq, record.Seed)
+		case record.Seed != strategy.Seed || record.Seq != strategy.seq:
+			return i, fmt.Errorf("record %d: got decision %d of seed %d, want decision %d of seed %d", i, record.Seq, record.Seed, strategy.seq, strategy.Seed)
+		}
+		if record.Symbols != nil {
+			symbols = record.Symbols
+		}
+
+		snap := Snapshot{
+			AgentName: record.Agent,
+			Now:       record.Time,
+			Account:   alpaca.Account{Equity: record.Equity, BuyingPower: record.BuyingPower, DaytradingBuyingPower: record.DaytradingBuyingPower},
+			Holdings:  make([]alpaca.Position, 0, len(record.Holdings)),
+			Symbols:   symbols,
+		}
+		for _, h := range record.Holdings {
+			snap.Holdings = append(snap.Holdings, alpaca.Position{Symbol: h.Symbol, QtyAvailable: h.QtyAvailable, CurrentPrice: h.Price})
+		}
+		// the matched agent's stats are taken as recorded
+		var observed TradeStats
+		if record.Observed != nil {
+			observed = *record.Observed
+		}
+		strategy.observe = func(string) TradeStats { return observed }
+
+		var replayed RNGRecord
+		strategy.Recorder = func(r RNGRecord) { replayed = r }
+		if _, err := strategy.Decide(context.Background(), snap); err != nil {
+			return i, fmt.Errorf("record %d: %w", i, err)
+		}
+		if replayed.Action != record.Action || replayed.Symbol != record.Symbol ||
+			!decimalsEqual(replayed.Amount, record.Amount) || !decimalsEqual(replayed.Quantity, record.Quantity) {
+			return i, fmt.Errorf("record %d (seed %d, decision %d): replayed %s, recorded %s", i, record.Seed, record.Seq, describeRNG(replayed), describeRNG(record))
+		}
+	}
+	return len(records), nil
+}
+
+func decimalsEqual(a, b *decimal.Decimal) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func describeRNG(r RNGRecord) string {
+	switch {
+	case r.Amount != nil:
+		return fmt.Sprintf("%s $%s of %s", r.Action, r.Amount, r.Symbol)
+	case r.Quantity != nil:
+		return fmt.Sprintf("%s %s %s", r.Action, r.Quantity, r.Symbol)
+	default:
+		return r.Action
+	}
+}
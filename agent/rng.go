@@ -4,309 +4,263 @@ import (
 	"context"
 	"fmt"
 	"math"
-	"os"
+	"math/rand/v2"
+	"slices"
+	"sync"
 	"time"
 
-	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
 	"github.com/dickeyy/cis-320/services"
 	"github.com/dickeyy/cis-320/types"
-	"github.com/dickeyy/cis-320/utils"
 	"github.com/rs/zerolog/log"
 	"github.com/shopspring/decimal"
 )
 
-// RNGStrategist is an agent that makes random buy/sell/hold decisions.
-type RNGStrategist struct {
-	Name    string
-	Symbols []string
-	// Embed AgentState to manage common agent properties
-	types.AgentState
-	broker       types.Broker
-	AlpacaClient *alpaca.Client
-	tick         <-chan time.Time
+// RNGStrategy makes random buy/sell/hold decisions. Every draw comes from its
+// own PCG generator, so a run is reproduced exactly by its seed and the states
+// it decided on, which are kept in an RNGRecord per decision.
+type RNGStrategy struct {
+	Seed   uint64
+	Config RNGConfig
+	// Recorder receives every decision; nil records nothing
+	Recorder func(record RNGRecord)
+
+	mu          sync.Mutex
+	rng         *rand.Rand
+	seq         int      // decisions made so far
+	buys, sells int      // trades decided so far, for mirroring
+	lastSymbols []string // symbols in the previous record
+	// observe returns the stats of the agent in Config.Match
+	observe func(agentName string) TradeStats
 }
 
-var (
-	lastTradeSymbol = ""
-)
+// RNGRecord is one RNG decision with everything needed to replay it: the seed,
+// the number of decisions made before it and the state it was made on.
+type RNGRecord struct {
+	Agent                 string           `json:"agent"`
+	Seed                  uint64           `json:"seed"`
+	Seq                   int              `json:"seq"`              // 0 for the first decision after the strategy was seeded
+	Config                *RNGConfig       `json:"config,omitempty"` // only on the first decision
+	Time                  time.Time        `json:"time"`
+	Equity                decimal.Decimal  `json:"equity"`
+	BuyingPower           decimal.Decimal  `json:"buying_power"`
+	DaytradingBuyingPower decimal.Decimal  `json:"daytrading_buying_power"`
+	Holdings              []RNGHolding     `json:"holdings"`
+	Symbols               []string         `json:"symbols,omitempty"`  // only when they changed since the previous record
+	Observed              *TradeStats      `json:"observed,omitempty"` // the matched agent's stats, when the config uses them
+	Action                string           `json:"action"`             // BUY, SELL or HOLD
+	Symbol                string           `json:"symbol,omitempty"`
+	Amount                *decimal.Decimal `json:"amount,omitempty"`
+	Quantity              *decimal.Decimal `json:"quantity,omitempty"`
+}
 
-func NewRNGAgent(name string) *RNGStrategist {
-	alpacaClient, account, holdings, err := services.InitializeAlpaca(os.Getenv("ALPACA_KEY_RNG"), os.Getenv("ALPACA_SECRET_RNG"))
-	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing Alpaca")
-	}
+// RNGHolding is the part of a position the RNG strategy decides on.
+type RNGHolding struct {
+	Symbol       string           `json:"symbol"`
+	QtyAvailable decimal.Decimal  `json:"qty_available"`
+	Price        *decimal.Decimal `json:"price,omitempty"`
+}
 
-	return &RNGStrategist{
-		Name:    name,
-		Symbols: utils.Symbols,
-		AgentState: types.AgentState{
-			Account:  *account,
-			Holdings: holdings,
-		},
-		AlpacaClient: alpacaClient,
+// NewRNGStrategy creates an RNG strategy with the default config, drawing
+// from a PCG generator seeded with seed.
+func NewRNGStrategy(seed uint64) *RNGStrategy {
+	return &RNGStrategy{
+		Seed:    seed,
+		Config:  DefaultRNGConfig(),
+		rng:     rand.New(rand.NewPCG(seed, 0)),
+		observe: ObservedStats,
 	}
 }
 
-// SetBroker sets the broker for the RNG Strategist.
-func (a *RNGStrategist) SetBroker(broker types.Broker) {
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
-	a.broker = broker
+// newRNGStrategy accepts a "seed" parameter, random when not set, and the
+// RNGConfig parameters. Decisions are saved to Redis next to the agent's trades.
+func newRNGStrategy(params Params) (Strategy, error) {
+	if err := params.Only(append([]string{"seed"}, rngConfigParams...)...); err != nil {
+		return nil, err
+	}
+	seed, err := params.Uint64("seed", rand.Uint64())
+	if err != nil {
+		return nil, err
+	}
+	config, err := rngConfigFromParams(params)
+	if err != nil {
+		return nil, err
+	}
+	s := NewRNGStrategy(seed)
+	s.Config = config
+	s.Recorder = saveRNGRecord
+	return s, nil
 }
 
-// SetTickChannel sets the shared tick channel for the RNG Strategist.
-func (a *RNGStrategist) SetTickChannel(tick <-chan time.Time) {
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
-	a.tick = tick
+// NewRNGAgent creates an agent trading with the RNG strategy on a random seed.
+func NewRNGAgent(name string, venue types.ExecutionVenue, clock types.Clock) *StrategyAgent {
+	strategy, _ := newRNGStrategy(nil)
+	return NewStrategyAgent(name, strategy, venue, clock)
 }
 
-func (a *RNGStrategist) Run(ctx context.Context) error {
-	// Use shared tick channel if provided, otherwise fall back to internal ticker
-	var tickC <-chan time.Time
-	if a.tick != nil {
-		tickC = a.tick
-	} else {
-		ticker := time.NewTicker(5 * time.Second)
-		defer ticker.Stop()
-		tickC = ticker.C
+func saveRNGRecord(record RNGRecord) {
+	if err := services.SaveRNGDecision(record.Agent, record, context.Background()); err != nil {
+		log.Error().Err(err).Str("agent", record.Agent).Int("seq", record.Seq).Msg("Error saving RNG decision")
 	}
+}
 
-	for {
-		select {
-		case <-tickC:
-			// make sure the market is open (only applies to non-dev mode)
-			if !utils.IsTradingHours() {
-				log.Debug().Str("agent", a.Name).Msg("Not trading hours, skipping tick")
-				continue
-			}
-
-			// get a trade decision
-			log.Info().Str("agent", a.Name).Msg("Making a random decision")
-			a.updateAgentState()
-			trade := a.makeDecision()
-
-			// process trade
-			if trade != nil {
-				// check last trade symbol to avoid wash trading
-				if trade.Symbol == lastTradeSymbol {
-					log.Info().Str("agent", a.Name).Str("symbol", trade.Symbol).Msg("Skipping trade, last trade was the same symbol")
-					continue
-				}
-				lastTradeSymbol = trade.Symbol
-
-				// Submit the trade to the broker with a completion callback
-				a.broker.SubmitTrade(ctx, trade, a.onComplete, a.AlpacaClient)
+// Decide makes the next random decision and records it.
+func (s *RNGStrategy) Decide(ctx context.Context, snap Snapshot) ([]Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-				log.Info().Str("agent", a.Name).Str("action", trade.Action).Str("order_id", trade.ID).Msg("Submitted order to broker")
-			} else {
-				log.Info().Str("agent", a.Name).Msg("No trade made")
-				holdTrade := types.Trade{
-					ID:        utils.GenerateOrderID(),
-					AlpacaID:  "",
-					Symbol:    "",
-					Amount:    nil,
-					Quantity:  nil,
-					Action:    "HOLD",
-					Timestamp: time.Now(),
-					AgentName: a.Name,
-				}
-				a.onComplete(nil, &holdTrade, nil)
-			}
-		case <-ctx.Done():
-			log.Info().Str("agent", a.Name).Msg("Shutting down RNG Agent")
-			return nil
+	if s.seq == 0 {
+		log.Info().Str("agent", snap.AgentName).Uint64("seed", s.Seed).Interface("config", s.Config).Msg("RNG strategy seeded")
+	}
+	record := s.newRecord(snap)
+	var observed TradeStats
+	if s.Config.observes() {
+		observed = s.observe(s.Config.Match)
+		record.Observed = &observed
+	}
+	decisions := s.decide(snap, observed)
+	s.seq++
+
+	record.Action = "HOLD"
+	if len(decisions) > 0 {
+		trade := decisions[0].Trade
+		if trade.Action == "BUY" {
+			s.buys++
+		} else {
+			s.sells++
+		}
+		record.Action, record.Symbol = trade.Action, trade.Symbol
+		if trade.Amount != nil {
+			amount := *trade.Amount
+			record.Amount = &amount
+		}
+		if trade.Quantity != nil {
+			qty := *trade.Quantity
+			record.Quantity = &qty
 		}
 	}
+	if s.Recorder != nil {
+		s.Recorder(record)
+	}
+	return decisions, nil
 }
 
-func (a *RNGStrategist) Stop(ctx context.Context) error {
-	return nil
-}
-
-// GetName returns the name of the RNG Strategist.
-func (a *RNGStrategist) GetName() string {
-	return a.Name
+// newRecord captures the state a decision is made on.
+func (s *RNGStrategy) newRecord(snap Snapshot) RNGRecord {
+	record := RNGRecord{
+		Agent:                 snap.AgentName,
+		Seed:                  s.Seed,
+		Seq:                   s.seq,
+		Time:                  snap.Now,
+		Equity:                snap.Account.Equity,
+		BuyingPower:           snap.Account.BuyingPower,
+		DaytradingBuyingPower: snap.Account.DaytradingBuyingPower,
+		Holdings:              make([]RNGHolding, 0, len(snap.Holdings)),
+	}
+	for _, h := range snap.Holdings {
+		record.Holdings = append(record.Holdings, RNGHolding{Symbol: h.Symbol, QtyAvailable: h.QtyAvailable, Price: h.CurrentPrice})
+	}
+	if s.seq == 0 {
+		config := s.Config
+		record.Config = &config
+	}
+	// the symbol universe rarely changes, so it is only recorded when it does
+	if s.seq == 0 || !slices.Equal(snap.Symbols, s.lastSymbols) {
+		record.Symbols = snap.Symbols
+		s.lastSymbols = snap.Symbols
+	}
+	return record
 }
 
-// GetHoldings returns the agent current holdings
-func (a *RNGStrategist) GetHoldings(ctx context.Context) ([]alpaca.Position, error) {
-	return a.AgentState.Holdings, nil
+// intn returns a random int in [min, max].
+func (s *RNGStrategy) intn(min, max int) int {
+	return s.rng.IntN(max-min+1) + min
 }
 
-// GetBuyingPower returns the agent current buying power
-func (a *RNGStrategist) GetBuyingPower(ctx context.Context) (decimal.Decimal, error) {
-	return a.AgentState.Account.BuyingPower, nil
+// float returns a random float in [min, max).
+func (s *RNGStrategy) float(min, max float64) float64 {
+	return min + s.rng.Float64()*(max-min)
 }
 
-// makeDecision handles the agent's core algorithm
-func (a *RNGStrategist) makeDecision() *types.Trade {
-	// lock the agent state
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
+// decide handles the strategy's core algorithm. observed are the matched
+// agent's stats when the config uses them.
+func (s *RNGStrategy) decide(snap Snapshot, observed TradeStats) []Decision {
+	action, reason := s.drawAction(snap.AgentName, observed)
 
-	// get a number between 1-100
-	r := utils.RNG(1, 100)
-	log.Debug().Str("agent", a.Name).Int("random_value", r).Msg("Random number generated")
-
-	// each option will have a 33% chance
-	// Buy, Sell, or Hold
-	if r <= 33 {
-		// Buy
+	switch action {
+	case "BUY":
+		if len(snap.Symbols) == 0 {
+			log.Debug().Str("agent", snap.AgentName).Msg("No symbols to buy, holding instead")
+			return nil
+		}
 		// choose a random symbol
-		symbol := utils.RandomString(a.Symbols)
-		// based on the agents capital, choose a random value <= current capital
-		buyingPower, _ := a.AgentState.Account.BuyingPower.Float64()
-		dayTradePower, _ := a.AgentState.Account.DaytradingBuyingPower.Float64()
-
-		spend := math.Floor(utils.RandomFloat(1, math.Min(buyingPower, dayTradePower))*100+0.5) / 100
-		// clamp the spend to the current balance
-		spend = math.Min(spend, math.Min(buyingPower, dayTradePower))
-		log.Debug().Str("agent", a.Name).Str("symbol", symbol).Float64("amount", spend).Msg("Buying")
+		symbol := snap.Symbols[s.intn(0, len(snap.Symbols)-1)]
+		// the spend is drawn from the sizing distribution, up to the current capital
+		buyingPower, _ := snap.Account.BuyingPower.Float64()
+		dayTradePower, _ := snap.Account.DaytradingBuyingPower.Float64()
+		spend := s.buyAmount(math.Min(buyingPower, dayTradePower), snap.Account, observed)
+		if spend <= 0 {
+			log.Debug().Str("agent", snap.AgentName).Msg("Nothing to spend, holding instead")
+			return nil
+		}
+		log.Debug().Str("agent", snap.AgentName).Str("symbol", symbol).Float64("amount", spend).Msg("Buying")
 
 		// make the base trade object, this will be updated later with real market data by the broker
 		var tradeAmount = decimal.NewFromFloat(spend)
 		trade := &types.Trade{
-			ID:        utils.GenerateOrderID(),
-			AlpacaID:  "", // to be set by the Alpaca service after placement
-			Symbol:    symbol,
-			Amount:    &tradeAmount,
-			Action:    "BUY",
-			Timestamp: time.Now(),
-			AgentName: a.Name,
+			Symbol: symbol,
+			Amount: &tradeAmount,
+			Action: "BUY",
 		}
-		err := a.validateTrade(trade)
-		if err != nil {
-			log.Error().Err(err).Str("agent", a.Name).Msg("Error validating trade")
-			return nil
-		}
-		return trade
-	} else if r <= 66 {
-		// Sell
+		return []Decision{{Trade: trade, Reason: reason}}
+	case "SELL":
 		// check if we have any holdings to sell
-		if len(a.AgentState.Holdings) == 0 {
-			log.Debug().Str("agent", a.Name).Msg("No holdings available to sell, holding instead")
+		if len(snap.Holdings) == 0 {
+			log.Debug().Str("agent", snap.AgentName).Msg("No holdings available to sell, holding instead")
 			return nil
 		}
 		// choose a random holding
-		holding := utils.RandomItem(a.AgentState.Holdings)
-		// based on the holding's quantity, choose a random value <= quantity
-		qty, _ := holding.QtyAvailable.Float64()
-		sell := math.Floor(utils.RandomFloat(1, qty)*100+0.5) / 100
-		sell = math.Min(sell, qty)
-		log.Debug().Str("agent", a.Name).Str("holding", holding.Symbol).Float64("amount", sell).Msg("Selling")
+		holding := snap.Holdings[s.intn(0, len(snap.Holdings)-1)]
+		// the quantity is drawn from the sizing distribution, up to the holding's quantity
+		sell := s.sellQuantity(holding, snap.Account, observed)
+		if sell <= 0 {
+			log.Debug().Str("agent", snap.AgentName).Str("holding", holding.Symbol).Msg("Nothing to sell, holding instead")
+			return nil
+		}
+		log.Debug().Str("agent", snap.AgentName).Str("holding", holding.Symbol).Float64("amount", sell).Msg("Selling")
 
 		// make the base trade object, this will be updated later with real market data by the broker
 		var tradeQuantity = decimal.NewFromFloat(sell)
 		trade := &types.Trade{
-			ID:        utils.GenerateOrderID(),
-			AlpacaID:  "", // to be set by the Alpaca service after placement
-			Symbol:    holding.Symbol,
-			Quantity:  &tradeQuantity,
-			Action:    "SELL",
-			Timestamp: time.Now(),
-			AgentName: a.Name,
-		}
-		err := a.validateTrade(trade)
-		if err != nil {
-			log.Error().Err(err).Str("agent", a.Name).Msg("Error validating trade")
-			return nil
+			Symbol:   holding.Symbol,
+			Quantity: &tradeQuantity,
+			Action:   "SELL",
 		}
-		return trade
-	} else {
-		// Hold
+		return []Decision{{Trade: trade, Reason: reason}}
+	default:
 		return nil
 	}
 }
 
-func (a *RNGStrategist) onComplete(trade *types.Trade, processed *types.Trade, err error) {
-	if err != nil {
-		if trade != nil {
-			log.Error().Err(err).Str("agent", a.Name).Str("order_id", trade.ID).Msg("Trade failed or was rejected")
-		} else {
-			log.Error().Err(err).Str("agent", a.Name).Msg("Trade failed or was rejected")
-		}
-		return
-	}
-
-	if processed == nil {
-		if trade != nil {
-			log.Error().Str("agent", a.Name).Str("order_id", trade.ID).Msg("Broker completed with nil trade")
-		} else {
-			log.Error().Str("agent", a.Name).Msg("Broker completed with nil trade")
+// drawAction picks BUY, SELL or HOLD by the configured weights, or by the
+// matched agent's frequency when mirroring it.
+func (s *RNGStrategy) drawAction(agentName string, observed TradeStats) (action, reason string) {
+	if s.Config.MirrorFrequency {
+		if action, ok := s.mirroredAction(observed); ok {
+			return action, fmt.Sprintf("mirroring %s's %d buys and %d sells in %d decisions", s.Config.Match, observed.Buys, observed.Sells, observed.Decisions)
 		}
-		return
+		// the weights stand in until the mirrored agent has decided
 	}
 
-	// wait for 5 seconds to make sure Alpaca fills the order
-	time.Sleep(5 * time.Second)
-
-	// perform state updates only after broker finished processing
-	a.AgentState.Mu.Lock()
-	defer a.AgentState.Mu.Unlock()
-	a.updateAgentState()
-
-	err = services.SaveTrade(processed, context.Background())
-	if err != nil {
-		log.Error().Err(err).Str("agent", a.Name).Str("order_id", processed.ID).Msg("Error saving trade")
-	}
-
-	log.Info().Str("agent", a.Name).Str("order_id", processed.ID).Msg("State updated and saved for processed trade")
-}
-
-// updateAgentState updates the agent state with the latest account and holdings from Alpaca
-func (a *RNGStrategist) updateAgentState() {
-	// fetch the latest account and holdings from alpaca
-	account, err := services.GetAccount(a.AlpacaClient)
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting account")
-	}
-	holdings, err := services.GetHoldings(a.AlpacaClient)
-	if err != nil {
-		log.Error().Err(err).Msg("Error getting holdings")
+	c := s.Config
+	r := s.float(0, c.BuyWeight+c.SellWeight+c.HoldWeight)
+	log.Debug().Str("agent", agentName).Float64("random_value", r).Int("seq", s.seq).Msg("Random number generated")
+	reason = fmt.Sprintf("random value %.2f", r)
+	switch {
+	case r < c.BuyWeight:
+		return "BUY", reason
+	case r < c.BuyWeight+c.SellWeight:
+		return "SELL", reason
+	default:
+		return "HOLD", reason
 	}
-
-	// update the agent state
-	a.AgentState.Account = *account
-	a.AgentState.Holdings = holdings
-}
-
-func (a *RNGStrategist) getHolding(symbol string) (alpaca.Position, error) {
-	for _, holding := range a.AgentState.Holdings {
-		if holding.Symbol == symbol {
-			return holding, nil
-		}
-	}
-	return alpaca.Position{}, fmt.Errorf("holding not found")
-}
-
-// validateTradeDecision validates the trade decision
-func (a *RNGStrategist) validateTrade(trade *types.Trade) error {
-	if trade.Symbol == "" {
-		return fmt.Errorf("symbol is required")
-	}
-
-	switch trade.Action {
-	case "BUY":
-		if trade.Amount == nil || trade.Amount.IsZero() {
-			return fmt.Errorf("amount is required")
-		}
-		if trade.Amount.GreaterThan(a.AgentState.Account.BuyingPower) {
-			return fmt.Errorf("amount is greater than buying power")
-		}
-	case "SELL":
-		if trade.Quantity == nil || trade.Quantity.IsZero() {
-			return fmt.Errorf("quantity is required")
-		}
-		// get the holding for the symbol
-		holding, err := a.getHolding(trade.Symbol)
-		if err != nil {
-			return fmt.Errorf("holding not found")
-		}
-		if trade.Quantity.GreaterThan(holding.QtyAvailable) {
-			return fmt.Errorf("quantity is greater than available quantity")
-		}
-	}
-
-	return nil
 }
@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/shopspring/decimal"
+)
+
+// recordRNG makes n decisions with the seed on a fixed state and returns their records.
+func recordRNG(t *testing.T, seed uint64, n int) []RNGRecord {
+	t.Helper()
+	var records []RNGRecord
+	s := NewRNGStrategy(seed)
+	s.Recorder = func(r RNGRecord) { records = append(records, r) }
+
+	snap := Snapshot{
+		AgentName: "RNG_Agent",
+		Now:       time.Date(2025, 1, 3, 15, 0, 0, 0, time.UTC),
+		Account:   alpaca.Account{BuyingPower: decimal.NewFromInt(5000), DaytradingBuyingPower: decimal.NewFromInt(20000)},
+		Holdings:  []alpaca.Position{{Symbol: "AAPL", QtyAvailable: decimal.NewFromInt(10)}, {Symbol: "MSFT", QtyAvailable: decimal.NewFromInt(3)}},
+		Symbols:   []string{"AAPL", "MSFT", "NVDA", "AMZN"},
+	}
+	for i := 0; i < n; i++ {
+		if _, err := s.Decide(context.Background(), snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return records
+}
+
+func TestRNGStrategyIsReproducible(t *testing.T) {
+	a, b := recordRNG(t, 42, 50), recordRNG(t, 42, 50)
+	actions := make(map[string]int)
+	for i := range a {
+		if describeRNG(a[i]) != describeRNG(b[i]) {
+			t.Fatalf("decision %d: %s with one run and %s with the other", i, describeRNG(a[i]), describeRNG(b[i]))
+		}
+		actions[a[i].Action]++
+	}
+	if len(actions) != 3 {
+		t.Errorf("actions = %v, want buys, sells and holds", actions)
+	}
+	if a[0].Symbols == nil || a[1].Symbols != nil {
+		t.Error("symbols should be recorded on the first decision only")
+	}
+}
+
+func TestReplayRNG(t *testing.T) {
+	records := append(recordRNG(t, 7, 20), recordRNG(t, 8, 5)...)
+	if n, err := ReplayRNG(records); err != nil || n != len(records) {
+		t.Fatalf("ReplayRNG() = %d, %v; want %d matched", n, err, len(records))
+	}
+
+	// a decision changed after the fact is caught
+	tampered := append([]RNGRecord(nil), records...)
+	for i := range tampered {
+		if tampered[i].Action == "BUY" {
+			tampered[i].Symbol = "TSLA"
+			if n, err := ReplayRNG(tampered); err == nil || n != i {
+				t.Errorf("ReplayRNG(tampered at %d) = %d, %v; want a mismatch there", i, n, err)
+			}
+			break
+		}
+	}
+
+	// so is a missing decision
+	if _, err := ReplayRNG(append(records[:3:3], records[4:]...)); err == nil || !strings.Contains(err.Error(), "want decision 3") {
+		t.Errorf("ReplayRNG(missing decision 3) error = %v", err)
+	}
+}
+
+func TestRNGConfigFromParams(t *testing.T) {
+	tests := []struct {
+		params  Params
+		wantErr bool
+	}{
+		{nil, false},
+		{Params{"buy_weight": 1, "sell_weight": 1.5, "hold_weight": 7}, false},
+		{Params{"sizing": "fraction", "fraction": 0.02}, false},
+		{Params{"sizing": "match", "match": "LLM_Agent", "mirror_frequency": true}, false},
+		{Params{"buy_weight": -1}, true},
+		{Params{"buy_weight": 0, "sell_weight": 0, "hold_weight": 0}, true},
+		{Params{"sizing": "fraction"}, true},
+		{Params{"sizing": "fraction", "fraction": 1.5}, true},
+		{Params{"sizing": "match"}, true},
+		{Params{"mirror_frequency": true}, true},
+		{Params{"mirror_frequency": "yes", "match": "LLM_Agent"}, true},
+		{Params{"sizing": "normal"}, true},
+	}
+	for _, tt := range tests {
+		if _, err := NewStrategy("rng", tt.params); (err != nil) != tt.wantErr {
+			t.Errorf("NewStrategy(rng, %v) error = %v, wantErr %v", tt.params, err, tt.wantErr)
+		}
+	}
+}
+
+// decideRNG makes n decisions with s on snap and returns their records.
+func decideRNG(t *testing.T, s *RNGStrategy, snap Snapshot, n int) []RNGRecord {
+	t.Helper()
+	var records []RNGRecord
+	s.Recorder = func(r RNGRecord) { records = append(records, r) }
+	for i := 0; i < n; i++ {
+		if _, err := s.Decide(context.Background(), snap); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return records
+}
+
+func rngSnapshot() Snapshot {
+	price := decimal.NewFromInt(50)
+	return Snapshot{
+		AgentName: "RNG_Agent",
+		Now:       time.Date(2025, 1, 3, 15, 0, 0, 0, time.UTC),
+		Account:   alpaca.Account{Equity: decimal.NewFromInt(10000), BuyingPower: decimal.NewFromInt(5000), DaytradingBuyingPower: decimal.NewFromInt(20000)},
+		Holdings:  []alpaca.Position{{Symbol: "AAPL", QtyAvailable: decimal.NewFromInt(100), CurrentPrice: &price}},
+		Symbols:   []string{"AAPL", "MSFT"},
+	}
+}
+
+func TestRNGStrategyWeights(t *testing.T) {
+	s := NewRNGStrategy(1)
+	s.Config.BuyWeight, s.Config.SellWeight, s.Config.HoldWeight = 0, 1, 3
+	actions := make(map[string]int)
+	for _, r := range decideRNG(t, s, rngSnapshot(), 400) {
+		actions[r.Action]++
+	}
+	if actions["BUY"] != 0 {
+		t.Errorf("bought %d times with a zero buy weight", actions["BUY"])
+	}
+	if sells := actions["SELL"]; sells < 60 || sells > 140 {
+		t.Errorf("sold %d times in 400, want about 100", sells)
+	}
+}
+
+func TestRNGStrategySizing(t *testing.T) {
+	observed := TradeStats{Decisions: 4, Buys: 2, Sells: 1, FilledBuys: 2, FilledSells: 1,
+		BuyNotional: decimal.NewFromInt(500), SellNotional: decimal.NewFromInt(300)}
+	tests := []struct {
+		config               RNGConfig
+		minAmount, maxAmount float64 // BUY dollars
+		minQty, maxQty       float64 // SELL shares
+	}{
+		{RNGConfig{Sizing: SizingUniform}, 1, 5000, 1, 100},
+		{RNGConfig{Sizing: SizingLogUniform}, 1, 5000, 1, 100},
+		{RNGConfig{Sizing: SizingFraction, Fraction: 0.01}, 100, 100, 2, 2},
+		{RNGConfig{Sizing: SizingMatch, Match: "LLM_Agent"}, 250, 250, 6, 6},
+	}
+	for _, tt := range tests {
+		tt.config.BuyWeight, tt.config.SellWeight = 1, 1
+		s := NewRNGStrategy(3)
+		s.Config = tt.config
+		s.observe = func(string) TradeStats { return observed }
+		for _, r := range decideRNG(t, s, rngSnapshot(), 100) {
+			switch r.Action {
+			case "BUY":
+				if amount := r.Amount.InexactFloat64(); amount < tt.minAmount || amount > tt.maxAmount {
+					t.Errorf("%s sizing bought $%v, want $%v to $%v", tt.config.Sizing, amount, tt.minAmount, tt.maxAmount)
+				}
+			case "SELL":
+				if qty := r.Quantity.InexactFloat64(); qty < tt.minQty || qty > tt.maxQty {
+					t.Errorf("%s sizing sold %v shares, want %v to %v", tt.config.Sizing, qty, tt.minQty, tt.maxQty)
+				}
+			}
+		}
+	}
+}
+
+func TestRNGStrategyMirrorsFrequency(t *testing.T) {
+	s := NewRNGStrategy(5)
+	s.Config = RNGConfig{Sizing: SizingUniform, Match: "LLM_Agent", MirrorFrequency: true}
+	s.observe = func(string) TradeStats { return TradeStats{Decisions: 10, Buys: 2, Sells: 1} }
+
+	actions := make(map[string]int)
+	records := decideRNG(t, s, rngSnapshot(), 30)
+	for _, r := range records {
+		actions[r.Action]++
+	}
+	if actions["BUY"] != 6 || actions["SELL"] != 3 {
+		t.Errorf("actions = %v, want 6 buys and 3 sells in 30 decisions", actions)
+	}
+
+	// the mirrored stats are recorded, so the run replays
+	if n, err := ReplayRNG(records); err != nil || n != len(records) {
+		t.Errorf("ReplayRNG() = %d, %v; want %d matched", n, err, len(records))
+	}
+}
+
+func TestRNGStrategyBuyAmountUnderOneDollar(t *testing.T) {
+	for _, sizing := range []string{SizingUniform, SizingLogUniform, SizingFraction, SizingMatch} {
+		s, fresh := NewRNGStrategy(5), NewRNGStrategy(5)
+		s.Config.Sizing, s.Config.Fraction = sizing, 0.5
+		account := alpaca.Account{Equity: decimal.NewFromInt(100)}
+		for _, available := range []float64{0, 0.5, 0.99} {
+			if spend := s.buyAmount(available, account, TradeStats{}); spend != 0 {
+				t.Errorf("%s sizing spent $%v of $%v, want nothing", sizing, spend, available)
+			}
+		}
+		// nothing was drawn
+		if got, want := s.float(0, 1), fresh.float(0, 1); got != want {
+			t.Errorf("%s sizing drew from the rng without a dollar available", sizing)
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// StrategyAgent is an agent trading with a Strategy. On every tick in its
+// trading hours it refreshes its account, asks the strategy for decisions,
+// holds back invalid trades and trades inside a cooldown, and submits the rest
+// to the broker; completed trades are saved.
+type StrategyAgent struct {
+	Name    string
+	Symbols []string
+	// Embed AgentState to manage common agent properties
+	types.AgentState
+	Strategy  Strategy
+	broker    types.Broker
+	Venue     types.ExecutionVenue
+	tick      <-chan time.Time
+	clock     types.Clock
+	LastError error
+	// Cooldowns holds back trades too close to the agent's previous ones in a symbol
+	Cooldowns *Cooldowns
+	// Hours is the part of the market session the agent trades in
+	Hours TradingHours
+	// Every is how often the agent decides; zero decides on every tick
+	Every    time.Duration
+	lastStep time.Time
+	// lastActions is what became of the last decision's trades, guarded by AgentState.Mu
+	lastActions []types.ActionResult
+}
+
+// NewStrategyAgent creates an agent running the strategy on the venue's account.
+func NewStrategyAgent(name string, strategy Strategy, venue types.ExecutionVenue, clock types.Clock) *StrategyAgent {
+	account, err := services.GetAccount(venue)
+	if err != nil {
+		log.Fatal().Err(err).Str("agent", name).Msg("Error getting account")
+	}
+	holdings, err := services.GetHoldings(venue)
+	if err != nil {
+		log.Fatal().Err(err).Str("agent", name).Msg("Error getting holdings")
+	}
+
+	return &StrategyAgent{
+		Name:    name,
+		Symbols: utils.Symbols,
+		AgentState: types.AgentState{
+			Account:  *account,
+			Holdings: holdings,
+		},
+		Strategy:  strategy,
+		Venue:     venue,
+		clock:     clock,
+		Cooldowns: NewCooldowns(DefaultCooldownRules()),
+	}
+}
+
+// SetBroker sets the broker the agent submits trades to.
+func (a *StrategyAgent) SetBroker(broker types.Broker) {
+	a.AgentState.Mu.Lock()
+	defer a.AgentState.Mu.Unlock()
+	a.broker = broker
+}
+
+// SetTickChannel sets the shared tick channel.
+func (a *StrategyAgent) SetTickChannel(tick <-chan time.Time) {
+	a.AgentState.Mu.Lock()
+	defer a.AgentState.Mu.Unlock()
+	a.tick = tick
+}
+
+// Run makes a decision on every tick in the agent's trading hours until ctx is done.
+func (a *StrategyAgent) Run(ctx context.Context) error {
+	// Use shared tick channel if provided, otherwise fall back to internal ticker
+	var tickC <-chan time.Time
+	if a.tick != nil {
+		tickC = a.tick
+	} else {
+		ticker := time.NewTicker(5 * time.Second)
+		defer ticker.Stop()
+		tickC = ticker.C
+	}
+
+	for {
+		select {
+		case <-tickC:
+			// make sure the agent trades in this part of the session
+			if ok, reason := a.Hours.Allows(a.clock); !ok {
+				log.Debug().Str("agent", a.Name).Str("phase", a.clock.Phase()).Str("reason", reason).Msg("Not trading hours, skipping tick")
+				continue
+			}
+			// ticks arrive a little late, so the gap is compared to the second
+			now := a.clock.Now()
+			if !a.lastStep.IsZero() && now.Sub(a.lastStep).Round(time.Second) < a.Every {
+				continue
+			}
+			a.lastStep = now
+
+			a.Step(ctx, now)
+		case <-ctx.Done():
+			log.Info().Str("agent", a.Name).Msg("Shutting down agent")
+			return nil
+		}
+	}
+}
+
+// Step makes one decision as of now and submits the resulting trades to the
+// broker. Run calls it on every tick during trading hours; backtests call it directly.
+func (a *StrategyAgent) Step(ctx context.Context, now time.Time) {
+	log.Info().Str("agent", a.Name).Msg("Making a decision")
+
+	a.AgentState.Mu.Lock()
+	a.updateAgentState()
+	snapshot := Snapshot{
+		AgentName: a.Name,
+		Now:       now,
+		Account:   a.AgentState.Account,
+		Holdings:  make([]alpaca.Position, len(a.AgentState.Holdings)),
+		Symbols:   a.Symbols,
+		LastError: a.LastError,
+	}
+	copy(snapshot.Holdings, a.AgentState.Holdings)
+	snapshot.LastActions = append([]types.ActionResult(nil), a.lastActions...)
+	a.AgentState.Mu.Unlock()
+
+	decisions, err := a.Strategy.Decide(ctx, snapshot)
+	if err != nil {
+		log.Error().Err(err).Str("agent", a.Name).Msg("Error making a decision")
+	}
+
+	if len(decisions) == 0 {
+		log.Info().Str("agent", a.Name).Msg("No trade made")
+		holdTrade := types.Trade{
+			ID:        utils.GenerateOrderID(),
+			AlpacaID:  "",
+			Symbol:    "",
+			Amount:    nil,
+			Quantity:  nil,
+			Action:    "HOLD",
+			Timestamp: now,
+			AgentName: a.Name,
+		}
+		a.onComplete(nil, &holdTrade, nil)
+		observeDecision(a.Name, nil)
+		a.setLastActions(nil)
+		return
+	}
+
+	// every trade is checked on its own; the valid ones then go to the broker
+	// together, in the strategy's order
+	var (
+		results []types.ActionResult
+		batch   []int // indexes into results of the trades to submit
+		trades  []*types.Trade
+		reasons []string
+	)
+	for _, d := range decisions {
+		trade := d.Trade
+		if trade == nil {
+			continue
+		}
+		if trade.ID == "" {
+			trade.ID = utils.GenerateOrderID()
+		}
+		if trade.Timestamp.IsZero() {
+			trade.Timestamp = now
+		}
+		trade.AgentName = a.Name
+		trade.ExtendedHours = a.Hours.Extended(a.clock)
+		result := types.ActionResult{Action: trade.Action, Symbol: trade.Symbol, OrderID: trade.ID}
+
+		// reject malformed orders before they reach the broker, and tell the strategy why
+		if err := services.ValidateOrder(trade); err != nil {
+			log.Error().Err(err).Str("agent", a.Name).Str("order_id", trade.ID).Msg("Invalid trade decision")
+			a.setLastError(err)
+			result.Status, result.Error = types.ActionStatusRejected, err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		// hold back trades inside a cooldown to avoid wash trading
+		if err := a.Cooldowns.Check(trade, now); err != nil {
+			log.Info().Err(err).Str("agent", a.Name).Str("symbol", trade.Symbol).Msg("Skipping trade")
+			a.setLastError(err)
+			result.Status, result.Error = types.ActionStatusRejected, err.Error()
+			results = append(results, result)
+			continue
+		}
+		// an open trade holds its cooldowns, so a trade later in the batch or the
+		// next decision cannot take the other side while it waits for a fill
+		a.Cooldowns.Submit(trade, now)
+
+		result.Status = types.ActionStatusSubmitted
+		batch = append(batch, len(results))
+		results = append(results, result)
+		trades = append(trades, trade)
+		reasons = append(reasons, d.Reason)
+	}
+	// set before submitting, a synchronous broker completes trades right away
+	a.setLastActions(results)
+
+	for i, trade := range trades {
+		// submit the trade to the broker with a completion callback
+		a.broker.SubmitTrade(ctx, trade, a.completeAction(trade, results, batch[i]), a.Venue)
+		log.Info().Str("agent", a.Name).Str("action", trade.Action).Str("order_id", trade.ID).Str("reason", reasons[i]).Msg("Submitted order to broker")
+	}
+	observeDecision(a.Name, trades)
+	if len(trades) < len(results) {
+		log.Info().Str("agent", a.Name).Int("submitted", len(trades)).Int("rejected", len(results)-len(trades)).Msg("Part of the decision was rejected")
+	}
+}
+
+func (a *StrategyAgent) Stop(ctx context.Context) error {
+	return nil
+}
+
+// GetName returns the name of the agent.
+func (a *StrategyAgent) GetName() string {
+	return a.Name
+}
+
+// GetHoldings returns the agent current holdings
+func (a *StrategyAgent) GetHoldings(ctx context.Context) ([]alpaca.Position, error) {
+	return a.AgentState.Holdings, nil
+}
+
+// GetBuyingPower returns the agent current buying power
+func (a *StrategyAgent) GetBuyingPower(ctx context.Context) (decimal.Decimal, error) {
+	return a.AgentState.Account.BuyingPower, nil
+}
+
+func (a *StrategyAgent) setLastError(err error) {
+	a.AgentState.Mu.Lock()
+	defer a.AgentState.Mu.Unlock()
+	a.LastError = err
+}
+
+func (a *StrategyAgent) setLastActions(results []types.ActionResult) {
+	a.AgentState.Mu.Lock()
+	defer a.AgentState.Mu.Unlock()
+	a.lastActions = results
+}
+
+// completeAction returns the broker callback for the submitted trade at
+// results[i]: it starts the trade's cooldowns from its fill, or releases them
+// when it traded nothing, records what became of the trade for the strategy,
+// then runs onComplete.
+func (a *StrategyAgent) completeAction(submitted *types.Trade, results []types.ActionResult, i int) func(*types.Trade, *types.Trade, error) {
+	return func(trade *types.Trade, processed *types.Trade, err error) {
+		if err == nil && processed != nil {
+			filledAt := a.clock.Now()
+			if processed.FilledAt != nil {
+				filledAt = *processed.FilledAt
+			}
+			a.Cooldowns.Record(processed, filledAt)
+		} else {
+			a.Cooldowns.Clear(submitted)
+		}
+
+		a.AgentState.Mu.Lock()
+		switch {
+		case err != nil:
+			results[i].Status, results[i].Error = types.ActionStatusFailed, err.Error()
+		case processed != nil && processed.Status != "":
+			results[i].Status = processed.Status
+		}
+		a.AgentState.Mu.Unlock()
+
+		a.onComplete(trade, processed, err)
+	}
+}
+
+func (a *StrategyAgent) onComplete(trade *types.Trade, processed *types.Trade, err error) {
+	if err != nil {
+		kind := services.ClassifyError(err)
+		if kind == services.ErrorRetryable {
+			// the venue was unavailable, the decision itself was not at fault so the strategy is not told about it
+			log.Warn().Err(err).Str("agent", a.Name).Str("kind", string(kind)).Msg("Trade failed after retries, venue unavailable")
+			return
+		}
+
+		// save the error for the strategy's next decision
+		a.setLastError(err)
+
+		if trade != nil {
+			log.Error().Err(err).Str("agent", a.Name).Str("kind", string(kind)).Str("order_id", trade.ID).Msg("Trade failed or was rejected")
+		} else {
+			log.Error().Err(err).Str("agent", a.Name).Str("kind", string(kind)).Msg("Trade failed or was rejected")
+		}
+		return
+	}
+
+	if processed == nil {
+		if trade != nil {
+			log.Error().Str("agent", a.Name).Str("order_id", trade.ID).Msg("Broker completed with nil trade")
+		} else {
+			log.Error().Str("agent", a.Name).Msg("Broker completed with nil trade")
+		}
+		return
+	}
+
+	// the broker only completes once the order is terminal, so the fill is final here
+	if processed.Quantity != nil && processed.Price != nil {
+		observeFill(processed)
+		log.Info().Str("agent", a.Name).Str("order_id", processed.ID).Str("status", processed.Status).Str("filled_qty", processed.Quantity.String()).Str("filled_avg_price", processed.Price.String()).Msg("Trade completed")
+	}
+
+	// perform state updates only after broker finished processing
+	a.AgentState.Mu.Lock()
+	a.updateAgentState()
+	// clear any previous error, the strategy has seen it
+	a.LastError = nil
+	a.AgentState.Mu.Unlock()
+
+	err = services.SaveTrade(processed, context.Background())
+	if err != nil {
+		log.Error().Err(err).Str("agent", a.Name).Str("order_id", processed.ID).Msg("Error saving trade")
+	}
+
+	log.Info().Str("agent", a.Name).Str("order_id", processed.ID).Msg("State updated and saved for processed trade")
+}
+
+// updateAgentState updates the agent state with the latest account and holdings
+// from the venue, keeping the previous ones when the venue cannot be reached.
+// The caller holds AgentState.Mu.
+func (a *StrategyAgent) updateAgentState() {
+	account, err := services.GetAccount(a.Venue)
+	if err != nil {
+		log.Error().Err(err).Str("agent", a.Name).Msg("Error getting account")
+	} else {
+		a.AgentState.Account = *account
+	}
+	holdings, err := services.GetHoldings(a.Venue)
+	if err != nil {
+		log.Error().Err(err).Str("agent", a.Name).Msg("Error getting holdings")
+	} else {
+		a.AgentState.Holdings = holdings
+	}
+}
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/backtest"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/services/alpacatest"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+// scriptedStrategy returns the next scripted trade on every decision and keeps the snapshots it saw.
+type scriptedStrategy struct {
+	script    []*types.Trade
+	snapshots []Snapshot
+}
+
+func (s *scriptedStrategy) Decide(ctx context.Context, snap Snapshot) ([]Decision, error) {
+	s.snapshots = append(s.snapshots, snap)
+	if len(s.script) == 0 {
+		return nil, nil
+	}
+	trade := s.script[0]
+	s.script = s.script[1:]
+	return []Decision{{Trade: trade}}, nil
+}
+
+func TestStrategyAgentStep(t *testing.T) {
+	prices := alpacatest.NewPrices()
+	prices.Set("AAPL", decimal.NewFromInt(100))
+	venue := services.NewSimulatedVenue(decimal.NewFromInt(1000), prices)
+	now := time.Date(2025, 1, 3, 10, 0, 0, 0, utils.MarketLocation)
+	clock := services.NewFakeClock(now)
+
+	qty := decimal.NewFromInt(2)
+	strategy := &scriptedStrategy{script: []*types.Trade{
+		{Symbol: "AAPL", Action: "BUY", Quantity: &qty},
+		{Symbol: "AAPL", Action: "SELL", Quantity: &qty},                                 // inside the opposite side cooldown
+		{Symbol: "AAPL", Action: "BUY", Quantity: &qty, OrderType: types.OrderTypeLimit}, // no limit price
+	}}
+	a := NewStrategyAgent("Scripted", strategy, venue, clock)
+	b := backtest.NewBroker(clock)
+	a.SetBroker(b)
+
+	for i := 0; i < 4; i++ {
+		a.Step(context.Background(), now)
+	}
+
+	trades := b.Trades()
+	if len(trades) != 1 || trades[0].AgentName != "Scripted" || trades[0].ID == "" || !trades[0].Timestamp.Equal(now) {
+		t.Fatalf("trades = %+v, want the first buy with the agent's name, an id and a timestamp", trades)
+	}
+	if len(strategy.snapshots[1].Holdings) != 1 {
+		t.Errorf("second snapshot holdings = %v, want the bought AAPL", strategy.snapshots[1].Holdings)
+	}
+	var cooldown *CooldownError
+	if !errors.As(strategy.snapshots[2].LastError, &cooldown) {
+		t.Errorf("third snapshot LastError = %v, want the cooldown", strategy.snapshots[2].LastError)
+	}
+	if strategy.snapshots[3].LastError == nil || errors.As(strategy.snapshots[3].LastError, &cooldown) {
+		t.Errorf("fourth snapshot LastError = %v, want the missing limit price", strategy.snapshots[3].LastError)
+	}
+}
+
+// batchStrategy returns the whole batch on its first decision and holds afterwards.
+type batchStrategy struct {
+	batch     []*types.Trade
+	snapshots []Snapshot
+}
+
+func (s *batchStrategy) Decide(ctx context.Context, snap Snapshot) ([]Decision, error) {
+	s.snapshots = append(s.snapshots, snap)
+	var decisions []Decision
+	for _, trade := range s.batch {
+		decisions = append(decisions, Decision{Trade: trade})
+	}
+	s.batch = nil
+	return decisions, nil
+}
+
+func TestStrategyAgentStepBatch(t *testing.T) {
+	prices := alpacatest.NewPrices()
+	prices.Set("AAPL", decimal.NewFromInt(100))
+	prices.Set("MSFT", decimal.NewFromInt(50))
+	venue := services.NewSimulatedVenue(decimal.NewFromInt(1000), prices)
+	now := time.Date(2025, 1, 3, 10, 0, 0, 0, utils.MarketLocation)
+	clock := services.NewFakeClock(now)
+
+	qty := decimal.NewFromInt(2)
+	strategy := &batchStrategy{batch: []*types.Trade{
+		{Symbol: "AAPL", Action: "BUY", Quantity: &qty},
+		{Action: "BUY", Quantity: &qty}, // no symbol
+		{Symbol: "MSFT", Action: "BUY", Quantity: &qty},
+	}}
+	a := NewStrategyAgent("Batch", strategy, venue, clock)
+	b := backtest.NewBroker(clock)
+	a.SetBroker(b)
+
+	a.Step(context.Background(), now)
+	a.Step(context.Background(), now)
+
+	trades := b.Trades()
+	if len(trades) != 2 || trades[0].Symbol != "AAPL" || trades[1].Symbol != "MSFT" {
+		t.Fatalf("trades = %+v, want the AAPL and MSFT buys in order", trades)
+	}
+	got := strategy.snapshots[1].LastActions
+	want := []string{types.OrderStatusFilled, types.ActionStatusRejected, types.OrderStatusFilled}
+	if len(got) != len(want) {
+		t.Fatalf("LastActions = %+v, want %d results", got, len(want))
+	}
+	for i, r := range got {
+		if r.Status != want[i] {
+			t.Errorf("LastActions[%d].Status = %q, want %q", i, r.Status, want[i])
+		}
+	}
+	if got[1].Error == "" {
+		t.Error("the rejected action has no error")
+	}
+}
+
+func TestStrategyAgentCooldownStartsOnFill(t *testing.T) {
+	prices := alpacatest.NewPrices()
+	prices.Set("AAPL", decimal.NewFromInt(100))
+	venue := services.NewSimulatedVenue(decimal.NewFromInt(1000), prices)
+	now := time.Date(2025, 1, 3, 10, 0, 0, 0, utils.MarketLocation)
+	clock := services.NewFakeClock(now)
+
+	tooMany, qty := decimal.NewFromInt(50), decimal.NewFromInt(2)
+	strategy := &scriptedStrategy{script: []*types.Trade{
+		{Symbol: "AAPL", Action: "BUY", Quantity: &tooMany}, // refused for buying power
+		{Symbol: "AAPL", Action: "BUY", Quantity: &qty},
+		{Symbol: "AAPL", Action: "SELL", Quantity: &qty}, // inside the filled buy's cooldown
+	}}
+	a := NewStrategyAgent("Cooldown", strategy, venue, clock)
+	b := backtest.NewBroker(clock)
+	a.SetBroker(b)
+
+	sell := &types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: &qty}
+	a.Step(context.Background(), now)
+	if err := a.Cooldowns.Check(sell, now); err != nil {
+		t.Fatalf("Check() after the refused buy = %v, want no cooldown", err)
+	}
+
+	a.Step(context.Background(), now)
+	a.Step(context.Background(), now)
+	trades := b.Trades()
+	if len(trades) != 1 || trades[0].Action != "BUY" || !trades[0].Quantity.Equal(qty) {
+		t.Fatalf("trades = %+v, want only the second buy", trades)
+	}
+	var cooldown *CooldownError
+	if got := a.lastActions; len(got) != 1 || got[0].Status != types.ActionStatusRejected {
+		t.Errorf("last actions = %+v, want the sell rejected", got)
+	} else if !errors.As(a.LastError, &cooldown) {
+		t.Errorf("LastError = %v, want the cooldown", a.LastError)
+	}
+}
+
+func TestStrategyAgentCooldownHeldWhileOpen(t *testing.T) {
+	prices := alpacatest.NewPrices()
+	prices.Set("AAPL", decimal.NewFromInt(100))
+	venue := services.NewSimulatedVenue(decimal.NewFromInt(1000), prices)
+	now := time.Date(2025, 1, 3, 10, 0, 0, 0, utils.MarketLocation)
+	clock := services.NewFakeClock(now)
+	venue.SetClock(clock.Now)
+
+	qty, limit := decimal.NewFromInt(2), decimal.NewFromInt(90)
+	strategy := &scriptedStrategy{script: []*types.Trade{
+		{Symbol: "AAPL", Action: "BUY", Quantity: &qty, OrderType: types.OrderTypeLimit, LimitPrice: &limit}, // rests below the market
+		{Symbol: "AAPL", Action: "SELL", Quantity: &qty},
+	}}
+	a := NewStrategyAgent("Cooldown", strategy, venue, clock)
+	b := backtest.NewBroker(clock)
+	a.SetBroker(b)
+
+	a.Step(context.Background(), now)
+	a.Step(context.Background(), now.Add(time.Hour))
+	var cooldown *CooldownError
+	if !errors.As(a.LastError, &cooldown) || !cooldown.Pending {
+		t.Fatalf("LastError = %v, want the open buy's cooldown", a.LastError)
+	}
+
+	// the buy fills later, and its cooldown runs from the fill
+	filled := now.Add(2 * time.Hour)
+	clock.Set(filled)
+	prices.Set("AAPL", decimal.NewFromInt(85))
+	b.Settle()
+	sell := &types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: &qty}
+	if err := a.Cooldowns.Check(sell, filled.Add(29*time.Minute)); !errors.As(err, &cooldown) || cooldown.Pending {
+		t.Errorf("Check() after the fill = %v, want the filled buy's cooldown", err)
+	}
+	if err := a.Cooldowns.Check(sell, filled.Add(30*time.Minute)); err != nil {
+		t.Errorf("Check() 30 minutes after the fill = %v, want nil", err)
+	}
+}
@@ -0,0 +1,186 @@
+package agent
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+)
+
+// sizing distributions for the RNG strategy
+const (
+	SizingUniform    = "uniform"     // anywhere from $1 (1 share) to everything available
+	SizingLogUniform = "log_uniform" // like uniform, but small trades are as likely per order of magnitude as large ones
+	SizingFraction   = "fraction"    // a fixed fraction of equity
+	SizingMatch      = "match"       // the average filled trade of the matched agent
+)
+
+// RNGConfig is how the RNG strategy draws its actions and sizes. The zero
+// weights are not valid; DefaultRNGConfig is the original 33/33/34 uniform baseline.
+type RNGConfig struct {
+	BuyWeight  float64 `json:"buy_weight"`
+	SellWeight float64 `json:"sell_weight"`
+	HoldWeight float64 `json:"hold_weight"`
+	Sizing     string  `json:"sizing"`
+	Fraction   float64 `json:"fraction,omitempty"` // of equity per trade, for fraction sizing
+	// Match is the agent whose trades are matched by match sizing and mirrored
+	// by MirrorFrequency, e.g. the LLM agent
+	Match string `json:"match,omitempty"`
+	// MirrorFrequency buys and sells as often as Match does, so only the
+	// symbols (and sizes, unless matched) are random; the weights are unused
+	MirrorFrequency bool `json:"mirror_frequency,omitempty"`
+}
+
+// DefaultRNGConfig returns the original baseline: a third each of buy, sell
+// and hold, sized uniformly.
+func DefaultRNGConfig() RNGConfig {
+	return RNGConfig{BuyWeight: 33, SellWeight: 33, HoldWeight: 34, Sizing: SizingUniform}
+}
+
+// rngConfigParams are the RNG parameters read by rngConfigFromParams.
+var rngConfigParams = []string{"buy_weight", "sell_weight", "hold_weight", "sizing", "fraction", "match", "mirror_frequency"}
+
+// rngConfigFromParams reads an RNG config from the agent's parameters, on top of the defaults.
+func rngConfigFromParams(params Params) (RNGConfig, error) {
+	c := DefaultRNGConfig()
+	var err error
+	if c.BuyWeight, err = params.Float("buy_weight", c.BuyWeight); err != nil {
+		return c, err
+	}
+	if c.SellWeight, err = params.Float("sell_weight", c.SellWeight); err != nil {
+		return c, err
+	}
+	if c.HoldWeight, err = params.Float("hold_weight", c.HoldWeight); err != nil {
+		return c, err
+	}
+	if c.Sizing, err = params.String("sizing", c.Sizing); err != nil {
+		return c, err
+	}
+	if c.Fraction, err = params.Float("fraction", c.Fraction); err != nil {
+		return c, err
+	}
+	if c.Match, err = params.String("match", c.Match); err != nil {
+		return c, err
+	}
+	if c.MirrorFrequency, err = params.Bool("mirror_frequency", c.MirrorFrequency); err != nil {
+		return c, err
+	}
+	return c, c.Validate()
+}
+
+// Validate checks the weights, the sizing and that the settings it needs are set.
+func (c RNGConfig) Validate() error {
+	switch {
+	case c.BuyWeight < 0 || c.SellWeight < 0 || c.HoldWeight < 0:
+		return fmt.Errorf("weights must not be negative")
+	case c.BuyWeight+c.SellWeight+c.HoldWeight <= 0 && !c.MirrorFrequency:
+		return fmt.Errorf("at least one weight must be positive")
+	case c.MirrorFrequency && c.Match == "":
+		return fmt.Errorf("mirror_frequency needs the agent to mirror in match")
+	}
+	switch c.Sizing {
+	case SizingUniform, SizingLogUniform:
+	case SizingFraction:
+		if c.Fraction <= 0 || c.Fraction > 1 {
+			return fmt.Errorf("fraction sizing needs a fraction in (0, 1], got %v", c.Fraction)
+		}
+	case SizingMatch:
+		if c.Match == "" {
+			return fmt.Errorf("match sizing needs the agent to match in match")
+		}
+	default:
+		return fmt.Errorf("unknown sizing %q, want %s, %s, %s or %s", c.Sizing, SizingUniform, SizingLogUniform, SizingFraction, SizingMatch)
+	}
+	return nil
+}
+
+// observes reports whether decisions depend on the matched agent's stats.
+func (c RNGConfig) observes() bool {
+	return c.MirrorFrequency || c.Sizing == SizingMatch
+}
+
+// buyAmount draws the dollars to spend, at most available. observed are the
+// matched agent's stats for match sizing. Less than $1 available buys nothing,
+// without drawing.
+func (s *RNGStrategy) buyAmount(available float64, account alpaca.Account, observed TradeStats) float64 {
+	if available < 1 {
+		return 0
+	}
+	var spend float64
+	switch s.Config.Sizing {
+	case SizingLogUniform:
+		spend = s.logUniform(1, available)
+	case SizingFraction:
+		spend = account.Equity.InexactFloat64() * s.Config.Fraction
+	case SizingMatch:
+		if avg, ok := observed.AverageBuy(); ok {
+			spend = avg.InexactFloat64()
+			break
+		}
+		// nothing to match before the first fill
+		spend = s.float(1, available)
+	default:
+		spend = s.float(1, available)
+	}
+	// clamp the spend to the current balance
+	return math.Min(roundCents(spend), available)
+}
+
+// sellQuantity draws the shares of holding to sell, at most the quantity
+// available. Sizes in dollars need the holding's price and fall back to
+// uniform without one.
+func (s *RNGStrategy) sellQuantity(holding alpaca.Position, account alpaca.Account, observed TradeStats) float64 {
+	qty := holding.QtyAvailable.InexactFloat64()
+	var price float64
+	if holding.CurrentPrice != nil {
+		price = holding.CurrentPrice.InexactFloat64()
+	}
+
+	var sell float64
+	switch {
+	case s.Config.Sizing == SizingLogUniform:
+		sell = s.logUniform(1, qty)
+	case s.Config.Sizing == SizingFraction && price > 0:
+		sell = account.Equity.InexactFloat64() * s.Config.Fraction / price
+	case s.Config.Sizing == SizingMatch && price > 0:
+		if avg, ok := observed.AverageSell(); ok {
+			sell = avg.InexactFloat64() / price
+			break
+		}
+		sell = s.float(1, qty)
+	default:
+		sell = s.float(1, qty)
+	}
+	return math.Min(roundCents(sell), qty)
+}
+
+// logUniform returns a random float in [min, max) whose log is uniform, or
+// max when it is not above min.
+func (s *RNGStrategy) logUniform(min, max float64) float64 {
+	if max <= min {
+		return max
+	}
+	return math.Exp(s.float(math.Log(min), math.Log(max)))
+}
+
+func roundCents(f float64) float64 {
+	return math.Floor(f*100+0.5) / 100
+}
+
+// mirroredAction returns the action that keeps this strategy's buys and sells
+// per decision at the observed agent's, or HOLD when it is not behind on either.
+// Before the observed agent has decided, ok is false.
+func (s *RNGStrategy) mirroredAction(observed TradeStats) (action string, ok bool) {
+	if observed.Decisions == 0 {
+		return "", false
+	}
+	decisions := float64(s.seq + 1)
+	switch {
+	case float64(s.buys) < decisions*float64(observed.Buys)/float64(observed.Decisions)-0.5:
+		return "BUY", true
+	case float64(s.sells) < decisions*float64(observed.Sells)/float64(observed.Decisions)-0.5:
+		return "SELL", true
+	default:
+		return "HOLD", true
+	}
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"sync"
+
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+// TradeStats is what an agent has done so far in this process, so one
+// strategy can mirror another agent, e.g. the RNG baseline matching the LLM.
+type TradeStats struct {
+	Decisions    int             `json:"decisions"`     // ticks the agent decided on
+	Buys         int             `json:"buys"`          // BUY trades submitted
+	Sells        int             `json:"sells"`         // SELL trades submitted
+	FilledBuys   int             `json:"filled_buys"`   // BUY trades that filled
+	FilledSells  int             `json:"filled_sells"`  // SELL trades that filled
+	BuyNotional  decimal.Decimal `json:"buy_notional"`  // dollars bought
+	SellNotional decimal.Decimal `json:"sell_notional"` // dollars sold
+}
+
+// TradeRate returns the share of decisions that submitted a trade, at most 1.
+func (s TradeStats) TradeRate() float64 {
+	if s.Decisions == 0 {
+		return 0
+	}
+	return min(1, float64(s.Buys+s.Sells)/float64(s.Decisions))
+}
+
+// AverageBuy returns the average filled BUY in dollars, or false before the first.
+func (s TradeStats) AverageBuy() (decimal.Decimal, bool) {
+	if s.FilledBuys == 0 {
+		return decimal.Zero, false
+	}
+	return s.BuyNotional.Div(decimal.NewFromInt(int64(s.FilledBuys))), true
+}
+
+// AverageSell returns the average filled SELL in dollars, or false before the first.
+func (s TradeStats) AverageSell() (decimal.Decimal, bool) {
+	if s.FilledSells == 0 {
+		return decimal.Zero, false
+	}
+	return s.SellNotional.Div(decimal.NewFromInt(int64(s.FilledSells))), true
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = make(map[string]*TradeStats)
+)
+
+// ObservedStats returns the stats of the named agent, zero if it has not decided yet.
+func ObservedStats(agentName string) TradeStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	if s, ok := stats[agentName]; ok {
+		return *s
+	}
+	return TradeStats{}
+}
+
+func statsFor(agentName string) *TradeStats {
+	s, ok := stats[agentName]
+	if !ok {
+		s = &TradeStats{}
+		stats[agentName] = s
+	}
+	return s
+}
+
+// observeDecision counts a decision and the trades it submitted.
+func observeDecision(agentName string, submitted []*types.Trade) {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := statsFor(agentName)
+	s.Decisions++
+	for _, trade := range submitted {
+		switch trade.Action {
+		case "BUY":
+			s.Buys++
+		case "SELL":
+			s.Sells++
+		}
+	}
+}
+
+// observeFill adds a filled trade's dollar value.
+func observeFill(trade *types.Trade) {
+	if trade.Quantity == nil || trade.Price == nil {
+		return
+	}
+	notional := trade.Quantity.Mul(*trade.Price)
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := statsFor(trade.AgentName)
+	switch trade.Action {
+	case "BUY":
+		s.FilledBuys++
+		s.BuyNotional = s.BuyNotional.Add(notional)
+	case "SELL":
+		s.FilledSells++
+		s.SellNotional = s.SellNotional.Add(notional)
+	}
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+)
+
+// Strategy is a trading approach. It only decides; the StrategyAgent running it
+// takes care of the schedule, the account state, cooldowns, order validation,
+// the broker and saving trades.
+type Strategy interface {
+	// Decide returns the trades to place as of the snapshot. No decisions means hold.
+	Decide(ctx context.Context, snapshot Snapshot) ([]Decision, error)
+}
+
+// Snapshot is the agent's state a strategy decides on. Holdings is a copy the
+// strategy may keep.
+type Snapshot struct {
+	AgentName string
+	Now       time.Time
+	Account   alpaca.Account
+	Holdings  []alpaca.Position
+	Symbols   []string // the symbols the agent may trade
+	LastError error    // why the agent's last trade was skipped or failed, nil after a success
+	// LastActions is what became of each trade of the last decision so far, in order
+	LastActions []types.ActionResult
+}
+
+// Decision is a trade a strategy wants placed. An empty trade id and timestamp
+// are filled in by the agent, and the agent name is always set.
+type Decision struct {
+	Trade  *types.Trade
+	Reason string // why the strategy made the trade, for the logs
+}
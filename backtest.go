@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dickeyy/cis-320/agent"
+	"github.com/dickeyy/cis-320/backtest"
+	"github.com/dickeyy/cis-320/config"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// backtestAgentNames are the names strategy types trade under in a backtest, the
+// same as in live runs; other types trade as e.g. MOMENTUM_Agent.
+var backtestAgentNames = map[string]string{
+	"rng": "RNG_Agent",
+	"llm": "LLM_Agent",
+}
+
+// runBacktest implements the backtest subcommand:
+//
+//	cis-320 backtest --from 2025-01-02 --to 2025-01-31 --agents rng,llm
+func runBacktest(args []string) {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	from := fs.String("from", "", "first trading day to replay, YYYY-MM-DD (required)")
+	to := fs.String("to", "", "last trading day to replay, YYYY-MM-DD (required)")
+	agentTypes := fs.String("agents", "rng", "comma separated strategy types to run, e.g. rng,llm")
+	data := fs.String("data", "data/bars", "CSV file or directory of CSV files with historical bars")
+	out := fs.String("out", "backtests", "directory the results are written to, in a subdirectory per run")
+	cash := fs.Float64("cash", 100000, "starting cash of every agent")
+	period := fs.Duration("period", 10*time.Minute, "time between agent decisions")
+	fs.Parse(args)
+
+	if *from == "" || *to == "" {
+		fs.Usage()
+		os.Exit(2)
+	}
+	fromDay, err := time.ParseInLocation("2006-01-02", *from, utils.MarketLocation)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid --from date")
+	}
+	toDay, err := time.ParseInLocation("2006-01-02", *to, utils.MarketLocation)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid --to date")
+	}
+
+	bars, err := backtest.LoadCSV(*data)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error loading bars")
+	}
+	prices := backtest.NewHistoricalPrices(bars)
+
+	// the agents pick from the symbols there is data for
+	utils.Symbols = prices.Symbols()
+	log.Info().Int("bars", len(bars)).Int("symbols_count", len(utils.Symbols)).Msg("Loaded bars")
+
+	// backtest trades are written to the results, never to the live trade log in Redis
+	var specs []backtest.AgentSpec
+	used := make(map[string]int)
+	for _, typ := range strings.Split(*agentTypes, ",") {
+		typ = strings.ToLower(strings.TrimSpace(typ))
+		strategy, err := agent.NewStrategy(typ, nil)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Invalid agent type")
+		}
+		if typ == "llm" && used[typ] == 0 {
+			services.InitializeAI()
+			initializeAICache()
+			// the agents file, if there is one, only sets model prices here
+			if cfg, found, err := config.Load(agentsFile); err == nil && found {
+				setAIPrices(cfg)
+			}
+		}
+
+		used[typ]++
+		name, ok := backtestAgentNames[typ]
+		if !ok {
+			name = strings.ToUpper(typ) + "_Agent"
+		}
+		if used[typ] > 1 {
+			name = fmt.Sprintf("%s_%d", name, used[typ])
+		}
+		specs = append(specs, func(venue types.ExecutionVenue, clock types.Clock) types.Agent {
+			return agent.NewStrategyAgent(name, strategy, venue, clock)
+		})
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	result, err := backtest.Run(ctx, backtest.Config{
+		From:   fromDay,
+		To:     toDay.AddDate(0, 0, 1),
+		Period: *period,
+		Cash:   decimal.NewFromFloat(*cash),
+	}, bars, specs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Backtest failed")
+	}
+
+	dir := filepath.Join(*out, time.Now().Format("20060102-150405"))
+	if err := backtest.WriteResult(dir, result); err != nil {
+		log.Fatal().Err(err).Msg("Error writing backtest results")
+	}
+	for _, s := range result.Summaries {
+		event := log.Info().Str("agent", s.Agent).Str("start_equity", s.StartEquity.StringFixed(2)).Str("end_equity", s.EndEquity.StringFixed(2)).Str("return", s.Return.String()).Int("trades", s.Trades)
+		if s.LLMUsage != nil {
+			event = event.Str("llm_cost", s.LLMUsage.Cost.StringFixed(4)).Int("llm_calls", s.LLMUsage.Calls)
+			if s.NetReturn != nil {
+				event = event.Str("net_return", s.NetReturn.String())
+			}
+		}
+		event.Msg("Backtest result")
+	}
+	log.Info().Str("dir", dir).Msg("Backtest results written")
+}
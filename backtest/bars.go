@@ -0,0 +1,151 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Bar is one OHLCV bar of a symbol.
+type Bar struct {
+	Symbol string
+	Time   time.Time
+	Open   decimal.Decimal
+	High   decimal.Decimal
+	Low    decimal.Decimal
+	Close  decimal.Decimal
+	Volume int64
+}
+
+// column names accepted in bar CSV headers; the short ones match Alpaca's bar fields
+var barColumns = map[string][]string{
+	"symbol": {"symbol", "s"},
+	"time":   {"timestamp", "time", "t"},
+	"open":   {"open", "o"},
+	"high":   {"high", "h"},
+	"low":    {"low", "l"},
+	"close":  {"close", "c"},
+	"volume": {"volume", "v"},
+}
+
+// LoadCSV loads bars from a CSV file, or from every .csv file in a directory.
+// Files need a header row with timestamp, open, high, low, close and volume
+// columns (or Alpaca's t, o, h, l, c, v). Without a symbol column the file name
+// is the symbol, e.g. AAPL.csv. Timestamps are RFC 3339 or unix seconds. The
+// bars are returned ordered by time, then symbol.
+func LoadCSV(path string) ([]Bar, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.csv")); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no .csv files in %s", path)
+		}
+	}
+
+	var bars []Bar
+	for _, file := range files {
+		fileBars, err := loadCSVFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		bars = append(bars, fileBars...)
+	}
+
+	sort.SliceStable(bars, func(i, j int) bool {
+		if !bars[i].Time.Equal(bars[j].Time) {
+			return bars[i].Time.Before(bars[j].Time)
+		}
+		return bars[i].Symbol < bars[j].Symbol
+	})
+	return bars, nil
+}
+
+func loadCSVFile(file string) ([]Bar, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %w", err)
+	}
+	cols := make(map[string]int)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		for field, aliases := range barColumns {
+			for _, alias := range aliases {
+				if name == alias {
+					cols[field] = i
+				}
+			}
+		}
+	}
+	for _, field := range []string{"time", "open", "high", "low", "close"} {
+		if _, ok := cols[field]; !ok {
+			return nil, fmt.Errorf("missing %s column", field)
+		}
+	}
+	defaultSymbol := strings.ToUpper(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+
+	var bars []Bar
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		bar := Bar{Symbol: defaultSymbol}
+		if i, ok := cols["symbol"]; ok && record[i] != "" {
+			bar.Symbol = strings.ToUpper(record[i])
+		}
+		if bar.Time, err = parseBarTime(record[cols["time"]]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		for field, dst := range map[string]*decimal.Decimal{"open": &bar.Open, "high": &bar.High, "low": &bar.Low, "close": &bar.Close} {
+			if *dst, err = decimal.NewFromString(strings.TrimSpace(record[cols[field]])); err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s: %w", line, field, err)
+			}
+		}
+		if i, ok := cols["volume"]; ok && record[i] != "" {
+			volume, err := strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid volume: %w", line, err)
+			}
+			bar.Volume = int64(volume)
+		}
+		bars = append(bars, bar)
+	}
+	return bars, nil
+}
+
+func parseBarTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q, want RFC 3339 or unix seconds", s)
+}
@@ -0,0 +1,271 @@
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/broker"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// Broker is a synchronous types.Broker for backtests. Trades pass the same risk
+// checks as in live runs and are placed the moment they are submitted; orders
+// that do not complete at once are settled by Settle after each bar instead of
+// being polled in the background, so a run is deterministic.
+type Broker struct {
+	mu     sync.Mutex
+	risk   *broker.RiskEngine
+	clock  types.Clock
+	open   []*openTrade // in placement order
+	trades []types.Trade
+}
+
+// openTrade is a placed trade whose order is still open.
+type openTrade struct {
+	trade      *types.Trade
+	order      *alpaca.Order
+	venue      types.ExecutionVenue
+	onComplete func(*types.Trade, *types.Trade, error)
+}
+
+// NewBroker creates a backtest broker with the default risk rules on the simulated clock.
+func NewBroker(clock types.Clock) *Broker {
+	return &Broker{risk: broker.NewRiskEngine(broker.DefaultRiskConfig()), clock: clock}
+}
+
+// SetRiskConfig replaces the pre-trade risk rules.
+func (b *Broker) SetRiskConfig(config broker.RiskConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.risk = broker.NewRiskEngine(config)
+}
+
+// SubmitTrade checks and places the trade right away. onComplete runs before
+// SubmitTrade returns when the order completes immediately, otherwise from a later Settle.
+func (b *Broker) SubmitTrade(ctx context.Context, trade *types.Trade, onComplete func(*types.Trade, *types.Trade, error), venue types.ExecutionVenue) {
+	complete := func(trade, processed *types.Trade, err error) {
+		if onComplete != nil {
+			onComplete(trade, processed, err)
+		}
+	}
+	if trade.ID == "" {
+		trade.ID = utils.GenerateOrderID()
+	}
+
+	account, err := venue.GetAccount()
+	if err != nil {
+		complete(nil, nil, fmt.Errorf("risk checks unavailable: %w", err))
+		return
+	}
+	positions, err := venue.GetPositions()
+	if err != nil {
+		complete(nil, nil, fmt.Errorf("risk checks unavailable: %w", err))
+		return
+	}
+
+	b.mu.Lock()
+	risk := b.risk
+	b.mu.Unlock()
+
+	now := b.clock.Now()
+	if err := risk.Check(trade, account, positions, now); err != nil {
+		log.Debug().Err(err).Str("order_id", trade.ID).Str("agent", trade.AgentName).Msg("Backtest trade rejected by risk checks")
+		complete(nil, nil, err)
+		return
+	}
+
+	order, err := venue.PlaceOrder(trade)
+	if err != nil {
+		complete(nil, nil, err)
+		return
+	}
+	risk.Record(trade, broker.FindPosition(positions, trade.Symbol), now)
+
+	trade.AlpacaID = order.ID
+	trade.Status = order.Status
+	ot := &openTrade{trade: trade, order: order, venue: venue, onComplete: onComplete}
+	if broker.IsTerminalStatus(order.Status) {
+		b.finish(ot)
+		return
+	}
+
+	b.mu.Lock()
+	b.open = append(b.open, ot)
+	b.mu.Unlock()
+}
+
+// Settle refreshes every open order and completes those that reached a
+// terminal state. The engine calls it after moving to each new bar.
+func (b *Broker) Settle() {
+	b.mu.Lock()
+	open := b.open
+	b.open = nil
+	b.mu.Unlock()
+
+	var still []*openTrade
+	for _, ot := range open {
+		if err := refresh(ot); err != nil {
+			log.Warn().Err(err).Str("order_id", ot.trade.ID).Msg("Error refreshing backtest order")
+			still = append(still, ot)
+			continue
+		}
+
+		if broker.IsTerminalStatus(ot.order.Status) {
+			b.finish(ot)
+		} else {
+			still = append(still, ot)
+		}
+	}
+
+	b.mu.Lock()
+	b.open = append(still, b.open...)
+	b.mu.Unlock()
+}
+
+// refresh reads the open trade's order from its venue.
+func refresh(ot *openTrade) error {
+	order, err := ot.venue.GetOrder(ot.order.ID)
+	if err != nil {
+		return err
+	}
+	// a replaced order lives on as a new order, follow it
+	if order.Status == types.OrderStatusReplaced && order.ReplacedBy != nil {
+		if order, err = ot.venue.GetOrder(*order.ReplacedBy); err != nil {
+			return err
+		}
+		ot.trade.AlpacaID = order.ID
+	}
+	ot.order = order
+	ot.trade.Status = order.Status
+	return nil
+}
+
+// finish applies the fill, records the trade and fires the completion callback.
+func (b *Broker) finish(ot *openTrade) {
+	broker.ApplyFill(ot.trade, ot.order)
+	if ot.order.FilledQty.IsZero() {
+		if ot.onComplete != nil {
+			ot.onComplete(ot.trade, nil, fmt.Errorf("order %s was %s without a fill", ot.trade.ID, ot.order.Status))
+		}
+		return
+	}
+
+	b.mu.Lock()
+	b.trades = append(b.trades, *ot.trade)
+	b.mu.Unlock()
+	if ot.onComplete != nil {
+		ot.onComplete(ot.trade, ot.trade, nil)
+	}
+}
+
+// CancelTrade cancels an open trade's order and completes the trade right away;
+// the other open orders are left for the next Settle. The callback gets an
+// error when the order filled or ended otherwise before it could be canceled.
+func (b *Broker) CancelTrade(ctx context.Context, tradeID string, onComplete func(*types.Trade, error)) {
+	if onComplete == nil {
+		onComplete = func(*types.Trade, error) {}
+	}
+	ot := b.findOpen(tradeID)
+	if ot == nil {
+		onComplete(nil, fmt.Errorf("trade %s has no open order", tradeID))
+		return
+	}
+	if err := ot.venue.CancelOrder(ot.order.ID); err != nil {
+		onComplete(nil, err)
+		return
+	}
+	if err := refresh(ot); err != nil {
+		onComplete(nil, err)
+		return
+	}
+	if !broker.IsTerminalStatus(ot.order.Status) {
+		trade := *ot.trade
+		onComplete(&trade, fmt.Errorf("order %s is still %s after the cancel", tradeID, ot.order.Status))
+		return
+	}
+
+	b.removeOpen(ot)
+	b.finish(ot)
+	trade := *ot.trade
+	if ot.order.Status != types.OrderStatusCanceled {
+		onComplete(&trade, fmt.Errorf("order %s was %s before it could be canceled", tradeID, ot.order.Status))
+		return
+	}
+	onComplete(&trade, nil)
+}
+
+// ReplaceTrade replaces an open trade's order with one carrying the changes.
+func (b *Broker) ReplaceTrade(ctx context.Context, tradeID string, changes types.TradeChanges, onComplete func(*types.Trade, error)) {
+	if onComplete == nil {
+		onComplete = func(*types.Trade, error) {}
+	}
+	ot := b.findOpen(tradeID)
+	if ot == nil {
+		onComplete(nil, fmt.Errorf("trade %s has no open order", tradeID))
+		return
+	}
+	order, err := ot.venue.ReplaceOrder(ot.order.ID, changes)
+	if err != nil {
+		onComplete(nil, err)
+		return
+	}
+
+	b.mu.Lock()
+	ot.order = order
+	ot.trade.AlpacaID = order.ID
+	ot.trade.Status = order.Status
+	trade := *ot.trade
+	b.mu.Unlock()
+	onComplete(&trade, nil)
+}
+
+// ListOpenTrades returns the agent's trades whose orders are still open, or every
+// open trade when agentName is empty.
+func (b *Broker) ListOpenTrades(agentName string) []types.Trade {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var trades []types.Trade
+	for _, ot := range b.open {
+		if agentName == "" || ot.trade.AgentName == agentName {
+			trades = append(trades, *ot.trade)
+		}
+	}
+	return trades
+}
+
+// Trades returns every trade that filled, in completion order.
+func (b *Broker) Trades() []types.Trade {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	trades := make([]types.Trade, len(b.trades))
+	copy(trades, b.trades)
+	return trades
+}
+
+func (b *Broker) findOpen(tradeID string) *openTrade {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ot := range b.open {
+		if ot.trade.ID == tradeID {
+			return ot
+		}
+	}
+	return nil
+}
+
+func (b *Broker) removeOpen(target *openTrade) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, ot := range b.open {
+		if ot == target {
+			b.open = append(b.open[:i], b.open[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package backtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+// stuckCancelVenue accepts cancels without taking the order off the book.
+type stuckCancelVenue struct {
+	*services.SimulatedVenue
+}
+
+func (v stuckCancelVenue) CancelOrder(orderID string) error { return nil }
+
+func TestBrokerCancelTrade(t *testing.T) {
+	prices := services.StaticPrices{"AAPL": decimal.NewFromInt(100)}
+	clock := services.NewFakeClock(time.Date(2025, 1, 3, 15, 0, 0, 0, time.UTC))
+	b := NewBroker(clock)
+
+	limit, qty := decimal.NewFromInt(90), decimal.NewFromInt(2)
+	completed := make(map[string]error)
+	place := func(agent string, venue types.ExecutionVenue) {
+		trade := &types.Trade{ID: agent + "-1", AgentName: agent, Symbol: "AAPL", Action: "BUY", Quantity: &qty, OrderType: types.OrderTypeLimit, LimitPrice: &limit}
+		b.SubmitTrade(context.Background(), trade, func(_, _ *types.Trade, err error) { completed[agent] = err }, venue)
+	}
+	place("A", services.NewSimulatedVenue(decimal.NewFromInt(1000), prices))
+	place("B", services.NewSimulatedVenue(decimal.NewFromInt(1000), prices))
+	place("C", stuckCancelVenue{services.NewSimulatedVenue(decimal.NewFromInt(1000), prices)})
+
+	// the price reaches every limit, but only the canceled order is settled
+	prices["AAPL"] = decimal.NewFromInt(85)
+	b.CancelTrade(context.Background(), "A-1", nil)
+	if _, ok := completed["A"]; !ok {
+		t.Error("canceled trade A was not completed")
+	}
+	if _, ok := completed["B"]; ok || len(b.ListOpenTrades("B")) != 1 {
+		t.Errorf("trade B was settled by another agent's cancel, want it open until Settle")
+	}
+
+	// an order that fills before the cancel takes is reported as filled
+	var cancelErr error
+	b.CancelTrade(context.Background(), "C-1", func(_ *types.Trade, err error) { cancelErr = err })
+	if cancelErr == nil || !strings.Contains(cancelErr.Error(), "filled") {
+		t.Errorf("CancelTrade() of the filled order error = %v, want it filled before it could be canceled", cancelErr)
+	}
+	if err, ok := completed["C"]; !ok || err != nil {
+		t.Errorf("filled trade C completed = %v, %v; want the fill", ok, err)
+	}
+	if trades := b.Trades(); len(trades) != 1 || trades[0].AgentName != "C" {
+		t.Errorf("Trades() = %+v, want only C's fill", trades)
+	}
+
+	// a nil callback is fine, even for a trade that is no longer open
+	b.ReplaceTrade(context.Background(), "A-1", types.TradeChanges{LimitPrice: &limit}, nil)
+}
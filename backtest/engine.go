@@ -0,0 +1,178 @@
+// Package backtest replays historical bars through the live agents. Every agent
+// trades its own simulated account at historical prices on a simulated clock,
+// and a run produces the same trade records as a live run plus an equity curve.
+package backtest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Config is the window and account setup of a backtest.
+type Config struct {
+	From   time.Time       // first bar time replayed
+	To     time.Time       // bars at or after To are not replayed
+	Period time.Duration   // how often agents decide; zero decides on every bar
+	Cash   decimal.Decimal // starting cash of every agent's account
+}
+
+// AgentSpec creates one agent trading on the simulated venue it is given, on
+// the backtest's simulated clock. The agent must implement types.Stepper.
+type AgentSpec func(venue types.ExecutionVenue, clock types.Clock) types.Agent
+
+// EquityPoint is an agent's account value at one point of the run.
+type EquityPoint struct {
+	Time   time.Time       `json:"time"`
+	Agent  string          `json:"agent"`
+	Equity decimal.Decimal `json:"equity"`
+	Cash   decimal.Decimal `json:"cash"`
+}
+
+// Summary is an agent's result over the whole run.
+type Summary struct {
+	Agent       string          `json:"agent"`
+	StartEquity decimal.Decimal `json:"start_equity"`
+	EndEquity   decimal.Decimal `json:"end_equity"`
+	Return      decimal.Decimal `json:"return"` // e.g. 0.05 = 5%
+	Trades      int             `json:"trades"`
+	Decisions   int             `json:"decisions"`
+	// the model calls of an LLM agent, in total and per day, and its return
+	// after their cost
+	LLMUsage  *types.AIUsage           `json:"llm_usage,omitempty"`
+	LLMDaily  map[string]types.AIUsage `json:"llm_daily,omitempty"`
+	NetReturn *decimal.Decimal         `json:"net_return,omitempty"`
+}
+
+// Result is everything a backtest produced.
+type Result struct {
+	From      time.Time     `json:"from"`
+	To        time.Time     `json:"to"`
+	Trades    []types.Trade `json:"trades"`
+	Equity    []EquityPoint `json:"equity"`
+	Summaries []Summary     `json:"summaries"`
+}
+
+// backtestAgent is an agent under test together with its account.
+type backtestAgent struct {
+	agent   types.Agent
+	stepper types.Stepper
+	venue   *services.SimulatedVenue
+	steps   int
+}
+
+// Run replays the bars in [cfg.From, cfg.To) through the agents. At every bar
+// the clock and prices move forward and resting orders are settled; every
+// cfg.Period the agents make a decision, one after the other, and their equity
+// is recorded. For the run, services.Market is the replayed prices, so the
+// LLM's tools see the same bars the orders fill at.
+func Run(ctx context.Context, cfg Config, bars []Bar, specs []AgentSpec) (*Result, error) {
+	prices := NewHistoricalPrices(bars)
+	previous := services.Market
+	services.Market = prices
+	defer func() { services.Market = previous }()
+	times := prices.Times(cfg.From, cfg.To)
+	if len(times) == 0 {
+		return nil, fmt.Errorf("no bars between %s and %s", cfg.From.Format(time.RFC3339), cfg.To.Format(time.RFC3339))
+	}
+
+	// the simulated clock; the market is open whenever there is a bar
+	clock := services.NewFakeClock(times[0])
+	clock.SetOpen(true)
+	prices.Advance(times[0])
+
+	b := NewBroker(clock)
+	agents := make([]*backtestAgent, 0, len(specs))
+	for _, spec := range specs {
+		venue := services.NewSimulatedVenue(cfg.Cash, prices)
+		venue.SetClock(clock.Now)
+		agent := spec(venue, clock)
+		stepper, ok := agent.(types.Stepper)
+		if !ok {
+			return nil, fmt.Errorf("agent %s cannot be stepped and so cannot be backtested", agent.GetName())
+		}
+		agent.SetBroker(b)
+		agents = append(agents, &backtestAgent{agent: agent, stepper: stepper, venue: venue})
+	}
+
+	result := &Result{From: cfg.From, To: cfg.To}
+	record := func(t time.Time) error {
+		for _, ba := range agents {
+			account, err := ba.venue.GetAccount()
+			if err != nil {
+				return fmt.Errorf("valuing %s at %s: %w", ba.agent.GetName(), t.Format(time.RFC3339), err)
+			}
+			result.Equity = append(result.Equity, EquityPoint{Time: t, Agent: ba.agent.GetName(), Equity: account.Equity, Cash: account.Cash})
+		}
+		return nil
+	}
+
+	log.Info().Time("from", times[0]).Time("to", times[len(times)-1]).Int("bars", len(times)).Int("agents", len(agents)).Msg("Starting backtest")
+	var lastStep time.Time
+	for i, t := range times {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		clock.Set(t)
+		prices.Advance(t)
+		b.Settle()
+
+		if i > 0 && t.Sub(lastStep) < cfg.Period {
+			continue
+		}
+		lastStep = t
+		for _, ba := range agents {
+			ba.stepper.Step(ctx, t)
+			ba.steps++
+		}
+		if err := record(t); err != nil {
+			return nil, err
+		}
+	}
+
+	// close the curve on the last bar if the agents did not decide on it
+	end := times[len(times)-1]
+	if !lastStep.Equal(end) {
+		if err := record(end); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Trades = b.Trades()
+	for _, ba := range agents {
+		result.Summaries = append(result.Summaries, summarize(ba, cfg.Cash, result))
+	}
+	return result, nil
+}
+
+// summarize computes an agent's summary from its equity curve and trades.
+func summarize(ba *backtestAgent, cash decimal.Decimal, result *Result) Summary {
+	s := Summary{Agent: ba.agent.GetName(), StartEquity: cash, EndEquity: cash, Decisions: ba.steps}
+	for _, p := range result.Equity {
+		if p.Agent == s.Agent {
+			s.EndEquity = p.Equity
+		}
+	}
+	if s.StartEquity.IsPositive() {
+		s.Return = s.EndEquity.Sub(s.StartEquity).DivRound(s.StartEquity, 6)
+	}
+	for _, trade := range result.Trades {
+		if trade.AgentName == s.Agent {
+			s.Trades++
+		}
+	}
+	if usage := services.AIUsageOf(s.Agent); usage.Total.Calls > 0 {
+		s.LLMUsage, s.LLMDaily = &usage.Total, usage.Daily
+		if s.StartEquity.IsPositive() {
+			net := s.EndEquity.Sub(s.StartEquity).Sub(usage.Total.Cost).DivRound(s.StartEquity, 6)
+			s.NetReturn = &net
+		}
+	}
+	return s
+}
@@ -0,0 +1,234 @@
+package backtest
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/shopspring/decimal"
+)
+
+// scriptedAgent buys AAPL on its first step and places a resting limit sell on its second.
+type scriptedAgent struct {
+	venue     types.ExecutionVenue
+	broker    types.Broker
+	steps     []time.Time
+	completed []*types.Trade
+}
+
+func (a *scriptedAgent) GetName() string                      { return "Scripted" }
+func (a *scriptedAgent) Run(ctx context.Context) error        { return nil }
+func (a *scriptedAgent) Stop(ctx context.Context) error       { return nil }
+func (a *scriptedAgent) SetBroker(broker types.Broker)        { a.broker = broker }
+func (a *scriptedAgent) SetTickChannel(tick <-chan time.Time) {}
+func (a *scriptedAgent) GetHoldings(ctx context.Context) ([]alpaca.Position, error) {
+	return a.venue.GetPositions()
+}
+func (a *scriptedAgent) GetBuyingPower(ctx context.Context) (decimal.Decimal, error) {
+	account, err := a.venue.GetAccount()
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return account.BuyingPower, nil
+}
+
+func (a *scriptedAgent) Step(ctx context.Context, now time.Time) {
+	a.steps = append(a.steps, now)
+	onComplete := func(trade, processed *types.Trade, err error) {
+		if err == nil {
+			a.completed = append(a.completed, processed)
+		}
+	}
+
+	qty := decimal.NewFromInt(5)
+	switch len(a.steps) {
+	case 1:
+		a.broker.SubmitTrade(ctx, &types.Trade{ID: "buy", Symbol: "AAPL", Action: "BUY", Quantity: &qty, AgentName: a.GetName(), Timestamp: now}, onComplete, a.venue)
+	case 2:
+		limit := decimal.NewFromInt(120)
+		a.broker.SubmitTrade(ctx, &types.Trade{ID: "sell", Symbol: "AAPL", Action: "SELL", Quantity: &qty, OrderType: types.OrderTypeLimit, LimitPrice: &limit, TimeInForce: types.TimeInForceGTC, AgentName: a.GetName(), Timestamp: now}, onComplete, a.venue)
+	}
+}
+
+// toolAgent asks a mock model that looks up AAPL's quote with get_quote on every step.
+type toolAgent struct {
+	scriptedAgent
+	results []string // what get_quote returned, per step
+	errors  []error
+}
+
+func (a *toolAgent) Step(ctx context.Context, now time.Time) {
+	a.steps = append(a.steps, now)
+	mock := &services.MockProvider{Respond: func(req openrouter.ChatCompletionRequest) (openrouter.ChatCompletionMessage, error) {
+		if len(req.Messages) == 2 {
+			return openrouter.ChatCompletionMessage{
+				Role: openrouter.ChatMessageRoleAssistant,
+				ToolCalls: []openrouter.ToolCall{{
+					ID:       "quote",
+					Type:     openrouter.ToolTypeFunction,
+					Function: openrouter.FunctionCall{Name: "get_quote", Arguments: `{"symbol": "AAPL"}`},
+				}},
+			}, nil
+		}
+		return openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: `{"reasoning": "hold", "actions": []}`}}, nil
+	}}
+	res, err := services.GetAITradeDecision(ctx, services.AIRequest{
+		Provider:   mock,
+		AgentState: &types.AgentState{},
+		Tools:      &services.AITools{Data: services.Market},
+	})
+	if err != nil {
+		a.errors = append(a.errors, err)
+		return
+	}
+	for _, call := range res.ToolCalls {
+		a.results = append(a.results, call.Result+call.Error)
+	}
+}
+
+func writeBars(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	csv := "timestamp,open,high,low,close,volume\n" +
+		"2025-01-02T14:30:00Z,100,100,100,100,1000\n" +
+		"2025-01-02T14:40:00Z,105,105,105,105,1000\n" +
+		"2025-01-02T14:50:00Z,110,110,110,110,1000\n" +
+		"2025-01-02T15:00:00Z,125,125,125,125,1000\n" +
+		"2025-01-02T15:10:00Z,130,130,130,130,1000\n"
+	if err := os.WriteFile(filepath.Join(dir, "AAPL.csv"), []byte(csv), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadCSV(t *testing.T) {
+	bars, err := LoadCSV(writeBars(t))
+	if err != nil {
+		t.Fatalf("LoadCSV() error = %v", err)
+	}
+	if len(bars) != 5 || bars[0].Symbol != "AAPL" || !bars[4].Close.Equal(decimal.NewFromInt(130)) {
+		t.Fatalf("bars = %+v, want 5 AAPL bars ending at 130", bars)
+	}
+}
+
+func TestRunSettlesOrdersOnLaterBars(t *testing.T) {
+	bars, err := LoadCSV(writeBars(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var scripted *scriptedAgent
+	result, err := Run(context.Background(), Config{
+		From:   time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+		Period: 20 * time.Minute,
+		Cash:   decimal.NewFromInt(1000),
+	}, bars, []AgentSpec{func(venue types.ExecutionVenue, clock types.Clock) types.Agent {
+		scripted = &scriptedAgent{venue: venue}
+		return scripted
+	}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	// decisions every 20 minutes: 14:30, 14:50, 15:10
+	if len(scripted.steps) != 3 {
+		t.Errorf("steps = %v, want 3", scripted.steps)
+	}
+
+	// bought 5 at 100, the limit sell at 120 fills on the 15:00 bar at 125
+	if len(result.Trades) != 2 || len(scripted.completed) != 2 {
+		t.Fatalf("trades = %+v, want the buy and the sell", result.Trades)
+	}
+	sell := result.Trades[1]
+	if sell.ID != "sell" || !sell.Price.Equal(decimal.NewFromInt(125)) || sell.Status != types.OrderStatusFilled {
+		t.Errorf("sell = %s %s at %s, want sell filled at 125", sell.ID, sell.Status, sell.Price)
+	}
+
+	s := result.Summaries[0]
+	if !s.EndEquity.Equal(decimal.NewFromInt(1125)) || !s.Return.Equal(decimal.RequireFromString("0.125")) || s.Trades != 2 {
+		t.Errorf("summary = %+v, want 1125 end equity, 0.125 return, 2 trades", s)
+	}
+
+	dir := t.TempDir()
+	if err := WriteResult(dir, result); err != nil {
+		t.Fatalf("WriteResult() error = %v", err)
+	}
+	for _, name := range []string{"trades.jsonl", "equity.csv", "summary.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s not written: %v", name, err)
+		}
+	}
+}
+
+func TestHistoricalPricesBars(t *testing.T) {
+	start := time.Date(2025, 1, 2, 14, 30, 0, 0, time.UTC)
+	var bars []Bar
+	for i := 0; i < 6; i++ {
+		c := decimal.NewFromInt(int64(100 + i))
+		bars = append(bars, Bar{Symbol: "AAPL", Time: start.Add(time.Duration(i) * 30 * time.Minute), Open: c, High: c, Low: c, Close: c, Volume: 10})
+	}
+	p := NewHistoricalPrices(bars)
+	p.Advance(start.Add(75 * time.Minute))
+
+	// the 15:30 bar is not visible yet, and the half hours are merged into hours
+	got, err := p.Bars("AAPL", time.Hour, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || !got[0].Close.Equal(decimal.NewFromInt(100)) || !got[1].Close.Equal(decimal.NewFromInt(102)) || got[1].Volume != 20 {
+		t.Errorf("Bars() = %+v, want the 14:00 bar and the 15:00 bar of two", got)
+	}
+	if q, err := p.Quote("AAPL"); err != nil || !q.Price.Equal(decimal.NewFromInt(102)) {
+		t.Errorf("Quote() = %+v, %v; want 102", q, err)
+	}
+}
+
+func TestRunToolsSeeCurrentBar(t *testing.T) {
+	previous := utils.PromptsDir
+	utils.PromptsDir = filepath.Join("..", "prompts")
+	t.Cleanup(func() { utils.PromptsDir = previous })
+
+	bars, err := LoadCSV(writeBars(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := &toolAgent{}
+	_, err = Run(context.Background(), Config{
+		From:   time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		To:     time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC),
+		Period: 20 * time.Minute,
+		Cash:   decimal.NewFromInt(1000),
+	}, bars, []AgentSpec{func(venue types.ExecutionVenue, clock types.Clock) types.Agent {
+		agent.venue = venue
+		return agent
+	}})
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if len(agent.errors) > 0 {
+		t.Fatalf("decisions failed: %v", agent.errors)
+	}
+
+	// decisions at 14:30, 14:50 and 15:10 see those bars' closes
+	want := []string{"100", "110", "130"}
+	if len(agent.results) != len(want) {
+		t.Fatalf("get_quote results = %v, want one per step", agent.results)
+	}
+	for i, result := range agent.results {
+		if !strings.Contains(result, `"price":"`+want[i]+`"`) {
+			t.Errorf("step %d: get_quote = %s, want price %s", i+1, result, want[i])
+		}
+	}
+	if services.Market != nil {
+		t.Errorf("services.Market = %v after the run, want it restored", services.Market)
+	}
+}
@@ -0,0 +1,128 @@
+package backtest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dickeyy/cis-320/services"
+	"github.com/shopspring/decimal"
+)
+
+// HistoricalPrices is a PriceSource replaying bars: a symbol's price is the
+// close of its latest bar at or before the current simulated time.
+type HistoricalPrices struct {
+	mu    sync.Mutex
+	bars  map[string][]Bar // per symbol, ordered by time
+	next  map[string]int   // index of each symbol's first bar after now
+	times []time.Time      // distinct bar times, ordered
+	now   time.Time
+}
+
+// NewHistoricalPrices indexes the bars. Advance must be called before any price is available.
+func NewHistoricalPrices(bars []Bar) *HistoricalPrices {
+	p := &HistoricalPrices{bars: make(map[string][]Bar), next: make(map[string]int)}
+
+	seen := make(map[time.Time]bool)
+	for _, bar := range bars {
+		p.bars[bar.Symbol] = append(p.bars[bar.Symbol], bar)
+		if t := bar.Time.UTC(); !seen[t] {
+			seen[t] = true
+			p.times = append(p.times, t)
+		}
+	}
+	for _, symbolBars := range p.bars {
+		sort.SliceStable(symbolBars, func(i, j int) bool { return symbolBars[i].Time.Before(symbolBars[j].Time) })
+	}
+	sort.Slice(p.times, func(i, j int) bool { return p.times[i].Before(p.times[j]) })
+	return p
+}
+
+// Advance moves the simulated time forward to t.
+func (p *HistoricalPrices) Advance(t time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.now = t
+	for symbol, symbolBars := range p.bars {
+		i := p.next[symbol]
+		for i < len(symbolBars) && !symbolBars[i].Time.After(t) {
+			i++
+		}
+		p.next[symbol] = i
+	}
+}
+
+// GetPrice returns the close of the symbol's latest bar at or before the current time.
+func (p *HistoricalPrices) GetPrice(symbol string) (decimal.Decimal, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := p.next[symbol]
+	if i == 0 {
+		return decimal.Zero, fmt.Errorf("no bar for symbol %s at or before %s", symbol, p.now.Format(time.RFC3339))
+	}
+	return p.bars[symbol][i-1].Close, nil
+}
+
+// Quote returns the symbol's current price, so the LLM's tools see the market
+// as of the simulated time and never the bars after it.
+func (p *HistoricalPrices) Quote(symbol string) (services.MarketQuote, error) {
+	price, err := p.GetPrice(symbol)
+	if err != nil {
+		return services.MarketQuote{}, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return services.MarketQuote{Symbol: symbol, Price: price, Time: p.bars[symbol][p.next[symbol]-1].Time}, nil
+}
+
+// Bars returns up to limit of the symbol's bars at or before the current time,
+// merged into bars of timeframe; bars shorter than the data's own are not made up.
+func (p *HistoricalPrices) Bars(symbol string, timeframe time.Duration, limit int) ([]services.MarketBar, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var out []services.MarketBar
+	for _, bar := range p.bars[symbol][:p.next[symbol]] {
+		start := bar.Time.Truncate(timeframe)
+		if n := len(out); n > 0 && out[n-1].Time.Equal(start) {
+			last := &out[n-1]
+			last.High = decimal.Max(last.High, bar.High)
+			last.Low = decimal.Min(last.Low, bar.Low)
+			last.Close = bar.Close
+			last.Volume += bar.Volume
+			continue
+		}
+		out = append(out, services.MarketBar{Time: start, Open: bar.Open, High: bar.High, Low: bar.Low, Close: bar.Close, Volume: bar.Volume})
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no bars for symbol %s at or before %s", symbol, p.now.Format(time.RFC3339))
+	}
+	if len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+	return out, nil
+}
+
+// Times returns the distinct bar times in [from, to).
+func (p *HistoricalPrices) Times(from, to time.Time) []time.Time {
+	var times []time.Time
+	for _, t := range p.times {
+		if !t.Before(from) && t.Before(to) {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// Symbols returns every symbol with bars, in alphabetical order.
+func (p *HistoricalPrices) Symbols() []string {
+	symbols := make([]string, 0, len(p.bars))
+	for symbol := range p.bars {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
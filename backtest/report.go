@@ -0,0 +1,55 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteResult writes the run to dir: trades.jsonl holds one trade record per
+// line in the same JSON as the live trade log, equity.csv the equity curve and
+// summary.json the per-agent summaries.
+func WriteResult(dir string, result *Result) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	trades, err := os.Create(filepath.Join(dir, "trades.jsonl"))
+	if err != nil {
+		return err
+	}
+	defer trades.Close()
+	enc := json.NewEncoder(trades)
+	for _, trade := range result.Trades {
+		if err := enc.Encode(trade); err != nil {
+			return err
+		}
+	}
+
+	equity, err := os.Create(filepath.Join(dir, "equity.csv"))
+	if err != nil {
+		return err
+	}
+	defer equity.Close()
+	w := csv.NewWriter(equity)
+	w.Write([]string{"time", "agent", "equity", "cash"})
+	for _, p := range result.Equity {
+		w.Write([]string{p.Time.Format(time.RFC3339), p.Agent, p.Equity.StringFixed(2), p.Cash.StringFixed(2)})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	summary, err := json.MarshalIndent(map[string]any{
+		"from":   result.From,
+		"to":     result.To,
+		"agents": result.Summaries,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "summary.json"), summary, 0o644)
+}
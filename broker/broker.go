@@ -2,6 +2,8 @@ package broker
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -12,78 +14,245 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// Broker handles the execution of trades submitted by agents.
+// defaultWorkers bounds how many accounts the broker serves concurrently.
+const defaultWorkers = 4
+
+// ErrBrokerStopped is reported to agents whose trades were still queued when the broker shut down.
+var ErrBrokerStopped = errors.New("broker stopped before the trade was processed")
+
+// Broker handles the execution of trades submitted by agents. A bounded pool of
+// workers pulls from the trade queue; the queue guarantees that an account's
+// trades are processed one at a time and in order, so a slow venue call for one
+// agent never blocks the others.
 type Broker struct {
+	// mu guards the broker's configuration and lifecycle (the risk engine, the
+	// worker count, whether the workers are running) and the open trades.
 	mu         sync.Mutex
 	tradeQueue *TradeQueue
+	risk       *RiskEngine
+	workers    int
+	running    bool
+	done       chan struct{}
+	wg         sync.WaitGroup // workers and order trackers
+
+	open  map[string]*openTrade // trade id -> placed trade with an open order
+	stale StaleOrderPolicy
+	tick  <-chan time.Time
+	ticks int
+	clock types.Clock
 }
 
 // NewBroker creates and returns a new Broker.
 func NewBroker() *Broker {
 	return &Broker{
 		tradeQueue: NewTradeQueue(),
+		risk:       NewRiskEngine(DefaultRiskConfig()),
+		workers:    defaultWorkers,
+		done:       make(chan struct{}),
+		open:       make(map[string]*openTrade),
+		stale:      DefaultStaleOrderPolicy(),
+		clock:      services.NewSystemClock(),
 	}
 }
 
+// SetWorkers sets the size of the worker pool. It has no effect once ProcessTrades has been called.
+func (b *Broker) SetWorkers(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n > 0 && !b.running {
+		b.workers = n
+	}
+}
+
+// SetClock sets the clock risk checks are timed by and the market hours the
+// stale order policy follows.
+func (b *Broker) SetClock(clock types.Clock) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.clock = clock
+}
+
+// SetRiskConfig replaces the pre-trade risk rules every order is checked against.
+func (b *Broker) SetRiskConfig(config RiskConfig) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.risk = NewRiskEngine(config)
+}
+
 // workItem is a unit of work for the broker queue containing the trade and an optional completion callback.
 type workItem struct {
 	trade      *types.Trade
 	onComplete func(*types.Trade, *types.Trade, error)
-	client     *alpaca.Client
+	venue      types.ExecutionVenue
+	priority   int       // queue class, see priorityOf
+	enqueued   time.Time // set by the queue, drives priority aging
+}
+
+// priorityOf returns the queue class for a trade: its own Priority, with plain
+// sells promoted to risk-reducing.
+func priorityOf(trade *types.Trade) int {
+	if trade.Priority == types.PriorityNormal && trade.Action == "SELL" {
+		return types.PriorityRiskReducing
+	}
+	return min(max(trade.Priority, types.PriorityNormal), types.PriorityEmergency)
+}
+
+// effectivePriority is the item's class after aging: one class up for every
+// priorityAging it has waited, capped at emergency.
+func (wi *workItem) effectivePriority(now time.Time) int {
+	p := wi.priority
+	if priorityAging > 0 {
+		p += int(now.Sub(wi.enqueued) / priorityAging)
+	}
+	return min(p, types.PriorityEmergency)
+}
+
+// account is the key the queue orders work by; every agent trades its own account.
+func (wi *workItem) account() string {
+	return wi.trade.AgentName
+}
+
+// complete invokes the completion callback, if any.
+func (wi *workItem) complete(trade *types.Trade, processed *types.Trade, err error) {
+	if wi.onComplete != nil {
+		wi.onComplete(trade, processed, err)
+	}
 }
 
 // SubmitTrade adds a trade to the broker's queue for processing.
-func (b *Broker) SubmitTrade(ctx context.Context, trade *types.Trade, onComplete func(*types.Trade, *types.Trade, error), client *alpaca.Client) {
-	b.tradeQueue.Enqueue(&workItem{trade: trade, onComplete: onComplete, client: client})
+func (b *Broker) SubmitTrade(ctx context.Context, trade *types.Trade, onComplete func(*types.Trade, *types.Trade, error), venue types.ExecutionVenue) {
+	wi := &workItem{trade: trade, onComplete: onComplete, venue: venue, priority: priorityOf(trade)}
+	if err := b.tradeQueue.Enqueue(wi); err != nil {
+		log.Warn().Str("order_id", trade.ID).Msg("Broker is stopped, rejecting trade")
+		wi.complete(nil, nil, ErrBrokerStopped)
+	}
+}
+
+// QueueDepth returns the number of trades waiting per account.
+func (b *Broker) QueueDepth() map[string]int {
+	return b.tradeQueue.Depth()
 }
 
-// ProcessTrades starts a goroutine to continuously process trades from the queue.
+// ProcessTrades starts the worker pool. When ctx is cancelled the queue stops
+// accepting trades, in-flight trades finish, and anything still queued is
+// completed with ErrBrokerStopped. Wait blocks until that drain is done.
 func (b *Broker) ProcessTrades(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				log.Info().Msg("Broker shutting down trade processing.")
-				return
-			default:
-				if !b.tradeQueue.IsEmpty() {
-					wi := b.tradeQueue.Dequeue()
-					if wi != nil {
-						trade := wi.trade
-						if trade.ID == "" {
-							trade.ID = utils.GenerateOrderID()
-						}
-						log.Info().Str("order_id", trade.ID).Msg("Broker processing trade")
-
-						processedTrade, err := services.PlaceOrder(trade, wi.client)
-						if err != nil {
-							log.Error().Err(err).Str("order_id", trade.ID).Msg("Error placing order")
-							if wi.onComplete != nil {
-								wi.onComplete(nil, nil, err)
-							}
-						} else {
-							if processedTrade != nil {
-								log.Info().Str("order_id", processedTrade.ID).Str("alpaca_id", processedTrade.AlpacaID).Msg("Order placed successfully")
-							} else {
-								log.Info().Str("order_id", trade.ID).Msg("Order placed successfully")
-							}
-							if wi.onComplete != nil {
-								// prefer returning processed trade if available
-								if processedTrade != nil {
-									wi.onComplete(processedTrade, processedTrade, nil)
-								} else {
-									wi.onComplete(trade, trade, nil)
-								}
-							}
-						}
-					} else {
-						log.Debug().Msg("Trade queue was empty after check, but Dequeue returned nil.")
-					}
-				} else {
-					// small delay to avoid busy-waiting
-					time.Sleep(100 * time.Millisecond)
+	b.mu.Lock()
+	if b.running {
+		b.mu.Unlock()
+		log.Warn().Msg("Broker is already processing trades")
+		return
+	}
+	b.running = true
+	workers := b.workers
+	tick := b.tick
+	b.mu.Unlock()
+
+	if tick != nil {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			b.watchTicks(ctx, tick)
+		}()
+	}
+
+	for i := 0; i < workers; i++ {
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for {
+				wi := b.tradeQueue.Dequeue()
+				if wi == nil {
+					return
 				}
+				b.process(ctx, wi)
+				b.tradeQueue.Done(wi)
 			}
+		}()
+	}
+
+	go func() {
+		<-ctx.Done()
+		log.Info().Msg("Broker shutting down trade processing.")
+		b.tradeQueue.Close()
+		b.wg.Wait()
+
+		drained := b.tradeQueue.Drain()
+		for _, wi := range drained {
+			wi.complete(nil, nil, ErrBrokerStopped)
 		}
+		log.Info().Int("drained", len(drained)).Msg("Broker stopped")
+		close(b.done)
 	}()
 }
+
+// Wait blocks until the broker has shut down and drained its queue.
+func (b *Broker) Wait() {
+	<-b.done
+}
+
+// process places a single work item on its venue and hands the resulting order
+// to the order tracker. The completion callback fires once the order is terminal.
+func (b *Broker) process(ctx context.Context, wi *workItem) {
+	trade := wi.trade
+	if trade.ID == "" {
+		trade.ID = utils.GenerateOrderID()
+	}
+	log.Info().Str("order_id", trade.ID).Msg("Broker processing trade")
+
+	b.mu.Lock()
+	risk := b.risk
+	clock := b.clock
+	b.mu.Unlock()
+
+	// every order passes the risk checks against fresh account state before it reaches the venue
+	account, err := wi.venue.GetAccount()
+	if err != nil {
+		log.Error().Err(err).Str("order_id", trade.ID).Msg("Error getting account for risk checks")
+		wi.complete(nil, nil, &services.OrderError{Kind: services.ClassifyError(err), Attempts: 1, Err: fmt.Errorf("risk checks unavailable: %w", err)})
+		return
+	}
+	positions, err := wi.venue.GetPositions()
+	if err != nil {
+		log.Error().Err(err).Str("order_id", trade.ID).Msg("Error getting positions for risk checks")
+		wi.complete(nil, nil, &services.OrderError{Kind: services.ClassifyError(err), Attempts: 1, Err: fmt.Errorf("risk checks unavailable: %w", err)})
+		return
+	}
+	now := clock.Now()
+	if err := risk.Check(trade, account, positions, now); err != nil {
+		log.Warn().Err(err).Str("order_id", trade.ID).Str("agent", trade.AgentName).Msg("Trade rejected by risk checks")
+		wi.complete(nil, nil, err)
+		return
+	}
+
+	order, err := b.placeOrder(ctx, wi)
+	if err != nil {
+		log.Error().Err(err).Str("order_id", trade.ID).Str("kind", string(services.ClassifyError(err))).Msg("Error placing order")
+		wi.complete(nil, nil, err)
+		return
+	}
+
+	risk.Record(trade, FindPosition(positions, trade.Symbol), now)
+
+	// Persist the Alpaca order id onto the trade for downstream usage
+	trade.AlpacaID = order.ID
+	trade.Status = order.Status
+	log.Info().Str("order_id", trade.ID).Str("alpaca_id", order.ID).Str("status", order.Status).Msg("Order placed successfully")
+	b.addOpen(wi, order)
+
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+		b.trackOrder(ctx, wi, order)
+	}()
+}
+
+// FindPosition returns the position held in symbol, or nil.
+func FindPosition(positions []alpaca.Position, symbol string) *alpaca.Position {
+	for i := range positions {
+		if positions[i].Symbol == symbol {
+			return &positions[i]
+		}
+	}
+	return nil
+}
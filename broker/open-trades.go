@@ -0,0 +1,264 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/rs/zerolog/log"
+)
+
+// StaleOrderPolicy decides when the broker cancels open orders on its own.
+type StaleOrderPolicy struct {
+	MaxAgeTicks   int  // cancel orders still open this many ticks after they were placed; 0 disables
+	CancelAtClose bool // cancel every open order on the first tick the market is closed
+}
+
+// DefaultStaleOrderPolicy cancels orders after six ticks (an hour at the
+// production tick rate) and everything still open at the close.
+func DefaultStaleOrderPolicy() StaleOrderPolicy {
+	return StaleOrderPolicy{MaxAgeTicks: 6, CancelAtClose: true}
+}
+
+// openTrade is a placed trade whose order is not terminal yet.
+type openTrade struct {
+	wi         *workItem
+	orderID    string // the venue order currently backing the trade, follows replacements
+	placedTick int
+	cancels    []*cancelRequest
+	cancelling bool // a cancel was sent to the venue and the order is not terminal yet
+}
+
+// cancelRequest is a pending CancelTrade call, answered once by whichever of
+// the venue call or the order tracker finishes it first.
+type cancelRequest struct {
+	onComplete func(*types.Trade, error)
+	done       bool
+}
+
+// addOpen registers a placed trade so it can be listed, canceled and replaced.
+func (b *Broker) addOpen(wi *workItem, order *alpaca.Order) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open[wi.trade.ID] = &openTrade{wi: wi, orderID: order.ID, placedTick: b.ticks}
+}
+
+// followReplacement points the open trade at the order that replaced its previous one.
+func (b *Broker) followReplacement(trade *types.Trade, order *alpaca.Order) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ot, ok := b.open[trade.ID]; ok {
+		ot.orderID = order.ID
+	}
+	trade.AlpacaID = order.ID
+}
+
+// finishOpen removes the trade once its order is terminal (or no longer tracked)
+// and answers any cancel requests waiting on it.
+func (b *Broker) finishOpen(trade *types.Trade, status string) {
+	b.mu.Lock()
+	ot, ok := b.open[trade.ID]
+	delete(b.open, trade.ID)
+	var pending []*cancelRequest
+	if ok {
+		for _, req := range ot.cancels {
+			if !req.done {
+				req.done = true
+				pending = append(pending, req)
+			}
+		}
+	}
+	snapshot := *trade
+	b.mu.Unlock()
+
+	for _, req := range pending {
+		if status == types.OrderStatusCanceled {
+			req.onComplete(&snapshot, nil)
+		} else {
+			req.onComplete(&snapshot, fmt.Errorf("order %s was %s before it could be canceled", trade.ID, status))
+		}
+	}
+}
+
+// CancelTrade cancels an open trade's order. The callback fires once the order
+// tracker sees the order canceled, or straight away if the venue refuses.
+func (b *Broker) CancelTrade(ctx context.Context, tradeID string, onComplete func(*types.Trade, error)) {
+	if onComplete == nil {
+		onComplete = func(*types.Trade, error) {}
+	}
+
+	b.mu.Lock()
+	ot, ok := b.open[tradeID]
+	if !ok {
+		b.mu.Unlock()
+		onComplete(nil, fmt.Errorf("trade %s has no open order", tradeID))
+		return
+	}
+	req := &cancelRequest{onComplete: onComplete}
+	ot.cancels = append(ot.cancels, req)
+	ot.cancelling = true
+	orderID, venue := ot.orderID, ot.wi.venue
+	b.mu.Unlock()
+
+	go func() {
+		err := venue.CancelOrder(orderID)
+		if err == nil {
+			log.Info().Str("order_id", tradeID).Str("alpaca_id", orderID).Msg("Cancel requested")
+			return
+		}
+
+		log.Error().Err(err).Str("order_id", tradeID).Str("alpaca_id", orderID).Msg("Error canceling order")
+		b.mu.Lock()
+		answered := req.done
+		req.done = true
+		// the order is still live, a later cancel may try again
+		ot.cancelling = false
+		b.mu.Unlock()
+		if !answered {
+			onComplete(nil, &services.OrderError{Kind: services.ClassifyError(err), Attempts: 1, Err: err})
+		}
+	}()
+}
+
+// ReplaceTrade replaces an open trade's order with one carrying the changes. The
+// callback receives the updated trade once the venue accepts the replacement;
+// the order tracker follows the new order from then on.
+func (b *Broker) ReplaceTrade(ctx context.Context, tradeID string, changes types.TradeChanges, onComplete func(*types.Trade, error)) {
+	if onComplete == nil {
+		onComplete = func(*types.Trade, error) {}
+	}
+
+	b.mu.Lock()
+	ot, ok := b.open[tradeID]
+	if !ok {
+		b.mu.Unlock()
+		onComplete(nil, fmt.Errorf("trade %s has no open order", tradeID))
+		return
+	}
+	orderID, venue := ot.orderID, ot.wi.venue
+	b.mu.Unlock()
+
+	go func() {
+		order, err := venue.ReplaceOrder(orderID, changes)
+		if err != nil {
+			log.Error().Err(err).Str("order_id", tradeID).Str("alpaca_id", orderID).Msg("Error replacing order")
+			onComplete(nil, &services.OrderError{Kind: services.ClassifyError(err), Attempts: 1, Err: err})
+			return
+		}
+
+		b.mu.Lock()
+		trade := ot.wi.trade
+		if changes.Quantity != nil {
+			trade.Quantity, trade.Amount = changes.Quantity, nil
+		}
+		if changes.LimitPrice != nil {
+			trade.LimitPrice = changes.LimitPrice
+		}
+		if changes.StopPrice != nil {
+			trade.StopPrice = changes.StopPrice
+		}
+		if changes.TrailPercent != nil {
+			trade.TrailPercent = changes.TrailPercent
+		}
+		if changes.TimeInForce != "" {
+			trade.TimeInForce = changes.TimeInForce
+		}
+		if _, stillOpen := b.open[tradeID]; stillOpen {
+			ot.orderID = order.ID
+		}
+		trade.AlpacaID = order.ID
+		trade.Status = order.Status
+		snapshot := *trade
+		b.mu.Unlock()
+
+		log.Info().Str("order_id", tradeID).Str("alpaca_id", order.ID).Str("replaces", orderID).Msg("Order replaced")
+		onComplete(&snapshot, nil)
+	}()
+}
+
+// ListOpenTrades returns copies of the agent's open trades, or of every open
+// trade when agentName is empty.
+func (b *Broker) ListOpenTrades(agentName string) []types.Trade {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	trades := make([]types.Trade, 0, len(b.open))
+	for _, ot := range b.open {
+		if agentName == "" || ot.wi.trade.AgentName == agentName {
+			trades = append(trades, *ot.wi.trade)
+		}
+	}
+	return trades
+}
+
+// SetStaleOrderPolicy sets when open orders are canceled automatically.
+func (b *Broker) SetStaleOrderPolicy(policy StaleOrderPolicy) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stale = policy
+}
+
+// SetTickChannel provides the shared tick the stale order policy runs on and
+// the rate limit counters are logged on. It has no effect once ProcessTrades
+// has been called.
+func (b *Broker) SetTickChannel(tick <-chan time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running {
+		b.tick = tick
+	}
+}
+
+// watchTicks applies the stale order policy and logs the rate limit counters
+// on every tick until ctx is done.
+func (b *Broker) watchTicks(ctx context.Context, tick <-chan time.Time) {
+	for {
+		select {
+		case <-tick:
+			b.cancelStaleOrders(ctx)
+			services.LogRateLimitStats()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// cancelStaleOrders advances the tick count and cancels every open order the
+// stale order policy says has been open too long. Orders already being
+// canceled are left to finish rather than canceled again on every tick.
+func (b *Broker) cancelStaleOrders(ctx context.Context) {
+	b.mu.Lock()
+	b.ticks++
+	policy := b.stale
+	var stale, fresh []string
+	for id, ot := range b.open {
+		switch {
+		case ot.cancelling:
+		case policy.MaxAgeTicks > 0 && b.ticks-ot.placedTick >= policy.MaxAgeTicks:
+			stale = append(stale, id)
+		default:
+			fresh = append(fresh, id)
+		}
+	}
+	clock := b.clock
+	b.mu.Unlock()
+
+	// only ask for the clock when there is something left to cancel
+	reason := "max age"
+	if policy.CancelAtClose && len(fresh) > 0 && !clock.IsOpen() {
+		reason = "market closed"
+		stale = append(stale, fresh...)
+	}
+
+	for _, id := range stale {
+		log.Info().Str("order_id", id).Str("reason", reason).Msg("Canceling stale order")
+		b.CancelTrade(ctx, id, func(trade *types.Trade, err error) {
+			if err != nil {
+				log.Warn().Err(err).Str("order_id", id).Msg("Stale order was not canceled")
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+// testPrices is a price source the test can move while the tracker polls.
+type testPrices struct {
+	mu     sync.Mutex
+	prices services.StaticPrices
+}
+
+func (p *testPrices) GetPrice(symbol string) (decimal.Decimal, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.prices.GetPrice(symbol)
+}
+
+func (p *testPrices) set(symbol string, price int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prices[symbol] = decimal.NewFromInt(price)
+}
+
+// placeRestingLimit places a limit BUY below the market on a simulated venue and
+// returns the broker, the venue's prices and a channel with the trade's completion error.
+func placeRestingLimit(t *testing.T) (*Broker, *testPrices, chan error) {
+	t.Helper()
+	pollInitialInterval, pollMaxInterval = time.Millisecond, 5*time.Millisecond
+	t.Cleanup(func() { pollInitialInterval, pollMaxInterval = 500*time.Millisecond, 30*time.Second })
+
+	prices := &testPrices{prices: services.StaticPrices{"AAPL": decimal.NewFromInt(200)}}
+	venue := services.NewSimulatedVenue(decimal.NewFromInt(10000), prices)
+	b := NewBroker()
+	done := make(chan error, 1)
+	b.process(context.Background(), &workItem{
+		trade: &types.Trade{
+			ID:         "t1",
+			AgentName:  "rng",
+			Symbol:     "AAPL",
+			Action:     "BUY",
+			Quantity:   decimalPtr("2"),
+			OrderType:  types.OrderTypeLimit,
+			LimitPrice: decimalPtr("150"),
+		},
+		venue:      venue,
+		onComplete: func(_ *types.Trade, _ *types.Trade, err error) { done <- err },
+	})
+
+	if open := b.ListOpenTrades("rng"); len(open) != 1 || open[0].ID != "t1" {
+		t.Fatalf("ListOpenTrades() = %+v, want t1", open)
+	}
+	return b, prices, done
+}
+
+func waitErr(t *testing.T, ch chan error) error {
+	t.Helper()
+	select {
+	case err := <-ch:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("callback never fired")
+		return nil
+	}
+}
+
+func TestCancelTrade(t *testing.T) {
+	b, _, done := placeRestingLimit(t)
+
+	canceled := make(chan error, 1)
+	b.CancelTrade(context.Background(), "t1", func(trade *types.Trade, err error) { canceled <- err })
+	if err := waitErr(t, canceled); err != nil {
+		t.Fatalf("CancelTrade() error = %v", err)
+	}
+	if err := waitErr(t, done); err == nil {
+		t.Error("trade completed without error, want canceled without a fill")
+	}
+	if open := b.ListOpenTrades(""); len(open) != 0 {
+		t.Errorf("ListOpenTrades() = %+v after cancel, want none", open)
+	}
+
+	b.CancelTrade(context.Background(), "t1", func(trade *types.Trade, err error) { canceled <- err })
+	if err := waitErr(t, canceled); err == nil {
+		t.Error("second CancelTrade() succeeded, want no open order")
+	}
+}
+
+func TestReplaceTrade(t *testing.T) {
+	b, prices, done := placeRestingLimit(t)
+
+	replaced := make(chan *types.Trade, 1)
+	b.ReplaceTrade(context.Background(), "t1", types.TradeChanges{LimitPrice: decimalPtr("195")}, func(trade *types.Trade, err error) {
+		if err != nil {
+			t.Errorf("ReplaceTrade() error = %v", err)
+		}
+		replaced <- trade
+	})
+	select {
+	case trade := <-replaced:
+		if trade == nil || !trade.LimitPrice.Equal(decimal.NewFromInt(195)) {
+			t.Fatalf("replaced trade = %+v, want limit 195", trade)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("replace callback never fired")
+	}
+
+	// the tracker follows the replacement and completes when it fills
+	prices.set("AAPL", 194)
+	if err := waitErr(t, done); err != nil {
+		t.Fatalf("trade completed with error = %v, want filled replacement", err)
+	}
+}
+
+func TestCancelStaleOrders(t *testing.T) {
+	b, _, done := placeRestingLimit(t)
+	b.SetStaleOrderPolicy(StaleOrderPolicy{MaxAgeTicks: 2})
+
+	b.cancelStaleOrders(context.Background())
+	if open := b.ListOpenTrades(""); len(open) != 1 {
+		t.Fatalf("order canceled after one tick, want it open until two")
+	}
+	b.cancelStaleOrders(context.Background())
+	if err := waitErr(t, done); err == nil {
+		t.Error("stale trade completed without error, want canceled")
+	}
+
+	b, _, done = placeRestingLimit(t)
+	b.SetStaleOrderPolicy(StaleOrderPolicy{CancelAtClose: true})
+	closed := services.NewFakeClock(time.Now())
+	closed.SetOpen(false)
+	b.SetClock(closed)
+	b.cancelStaleOrders(context.Background())
+	if err := waitErr(t, done); err == nil {
+		t.Error("trade open at the close completed without error, want canceled")
+	}
+}
+
+// stuckCancelVenue accepts cancels without the order ever leaving the book.
+type stuckCancelVenue struct {
+	scriptedVenue
+	cancels chan string
+}
+
+func (v *stuckCancelVenue) CancelOrder(orderID string) error {
+	v.cancels <- orderID
+	return nil
+}
+
+func TestCancelStaleOrdersOnce(t *testing.T) {
+	venue := &stuckCancelVenue{cancels: make(chan string, 4)}
+	b := NewBroker()
+	b.SetStaleOrderPolicy(StaleOrderPolicy{MaxAgeTicks: 1})
+	b.addOpen(&workItem{trade: &types.Trade{ID: "t1", AgentName: "rng"}, venue: venue}, &alpaca.Order{ID: "o1"})
+
+	for i := 0; i < 3; i++ {
+		b.cancelStaleOrders(context.Background())
+	}
+	select {
+	case id := <-venue.cancels:
+		if id != "o1" {
+			t.Errorf("CancelOrder(%s), want o1", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stale order never canceled")
+	}
+	select {
+	case <-venue.cancels:
+		t.Error("stale order canceled again while its cancel was pending")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if n := len(b.open["t1"].cancels); n != 1 {
+		t.Errorf("cancel requests = %d, want 1", n)
+	}
+}
@@ -2,45 +2,171 @@ package broker
 
 import (
 	"container/list"
+	"errors"
 	"sync"
+	"time"
 )
 
-// TradeQueue is a thread-safe queue for trades.
+// ErrQueueClosed is returned when enqueueing onto a closed queue.
+var ErrQueueClosed = errors.New("trade queue is closed")
+
+// priorityAging is how long a work item waits before it is promoted one
+// priority class, so a steady stream of sells can never starve the buys.
+var priorityAging = 30 * time.Second
+
+// TradeQueue is a thread-safe, blocking priority queue for trades. Work items
+// are kept per account and never processed concurrently for one account, while
+// different accounts proceed in parallel on separate workers.
+//
+// Each item has a priority class (emergency liquidation, risk-reducing sells,
+// normal orders). An account's items are handed out in submission order, so a
+// decision's trades run as the agent ordered them. Across accounts the account
+// whose next item has the highest class goes first, and accounts in the same
+// class take turns. Waiting items climb one class every priorityAging.
 type TradeQueue struct {
-	mu    sync.Mutex
-	queue *list.List
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending map[string]*list.List // account -> queued work items, in submission order
+	ready   *list.List            // accounts with queued work and nothing in flight, least recently served first
+	busy    map[string]bool       // accounts with a work item in flight
+	size    int
+	closed  bool
+	now     func() time.Time
 }
 
 // NewTradeQueue creates and returns a new TradeQueue.
 func NewTradeQueue() *TradeQueue {
-	return &TradeQueue{
-		queue: list.New(),
+	q := &TradeQueue{
+		pending: make(map[string]*list.List),
+		ready:   list.New(),
+		busy:    make(map[string]bool),
+		now:     time.Now,
 	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
 }
 
-// Enqueue adds a work item to the back of the queue.
-func (q *TradeQueue) Enqueue(item *workItem) {
+// Enqueue adds a work item to the back of its account's queue and wakes a worker.
+func (q *TradeQueue) Enqueue(item *workItem) error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.queue.PushBack(item)
+	if q.closed {
+		return ErrQueueClosed
+	}
+
+	item.enqueued = q.now()
+	account := item.account()
+	l, ok := q.pending[account]
+	if !ok {
+		l = list.New()
+		q.pending[account] = l
+	}
+	l.PushBack(item)
+	q.size++
+
+	// the account becomes ready with its first item, unless a worker already holds it
+	if l.Len() == 1 && !q.busy[account] {
+		q.ready.PushBack(account)
+	}
+	q.cond.Signal()
+	return nil
 }
 
-// Dequeue removes and returns a work item from the front of the queue.
-// It returns nil if the queue is empty.
+// Dequeue blocks until a work item is available and returns the most urgent
+// one, marking its account busy until Done is called. It returns nil once the
+// queue is closed.
 func (q *TradeQueue) Dequeue() *workItem {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	if q.queue.Len() == 0 {
+	for q.ready.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
 		return nil
 	}
-	e := q.queue.Front()
-	q.queue.Remove(e)
-	return e.Value.(*workItem)
+
+	// the first ready account with the highest class wins, so accounts in the
+	// same class are served round-robin
+	now := q.now()
+	var next *list.Element
+	best := -1
+	for e := q.ready.Front(); e != nil; e = e.Next() {
+		head := q.pending[e.Value.(string)].Front().Value.(*workItem)
+		if p := head.effectivePriority(now); p > best {
+			next, best = e, p
+		}
+	}
+
+	account := q.ready.Remove(next).(string)
+	l := q.pending[account]
+	item := l.Remove(l.Front()).(*workItem)
+	if l.Len() == 0 {
+		delete(q.pending, account)
+	}
+	q.busy[account] = true
+	q.size--
+	return item
 }
 
-// IsEmpty returns true if the queue is empty, false otherwise.
-func (q *TradeQueue) IsEmpty() bool {
+// Done releases the item's account so its next queued item can be handed out.
+func (q *TradeQueue) Done(item *workItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	account := item.account()
+	delete(q.busy, account)
+	if l, ok := q.pending[account]; ok && l.Len() > 0 {
+		q.ready.PushBack(account)
+		q.cond.Signal()
+	}
+}
+
+// Close stops the queue from accepting work and wakes every blocked worker.
+func (q *TradeQueue) Close() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	return q.queue.Len() == 0
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Drain removes and returns every queued work item, in per-account order.
+func (q *TradeQueue) Drain() []*workItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	items := make([]*workItem, 0, q.size)
+	for account, l := range q.pending {
+		for e := l.Front(); e != nil; e = e.Next() {
+			items = append(items, e.Value.(*workItem))
+		}
+		delete(q.pending, account)
+	}
+	q.ready.Init()
+	q.size = 0
+	return items
+}
+
+// Len returns the number of queued work items across all accounts.
+func (q *TradeQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.size
+}
+
+// Depth returns the number of queued work items per account. Items currently
+// being processed are not counted.
+func (q *TradeQueue) Depth() map[string]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depth := make(map[string]int, len(q.pending))
+	for account, l := range q.pending {
+		depth[account] = l.Len()
+	}
+	return depth
+}
+
+// IsEmpty returns true if the queue is empty, false otherwise.
+func (q *TradeQueue) IsEmpty() bool {
+	return q.Len() == 0
 }
@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+)
+
+func newTestItem(agent, id string) *workItem {
+	return &workItem{trade: &types.Trade{ID: id, AgentName: agent}}
+}
+
+func TestTradeQueuePerAccountOrdering(t *testing.T) {
+	q := NewTradeQueue()
+	for _, wi := range []*workItem{
+		newTestItem("A", "a1"),
+		newTestItem("A", "a2"),
+		newTestItem("B", "b1"),
+	} {
+		if err := q.Enqueue(wi); err != nil {
+			t.Fatalf("Enqueue() error = %v", err)
+		}
+	}
+
+	first := q.Dequeue()
+	second := q.Dequeue()
+	if first.trade.ID != "a1" || second.trade.ID != "b1" {
+		t.Fatalf("got %s then %s, want a1 then b1 (A is busy until a1 is done)", first.trade.ID, second.trade.ID)
+	}
+	if depth := q.Depth(); depth["A"] != 1 || len(depth) != 1 {
+		t.Errorf("Depth() = %v, want map[A:1]", depth)
+	}
+
+	// a2 is only handed out once a1 is done
+	got := make(chan *workItem)
+	go func() { got <- q.Dequeue() }()
+	select {
+	case wi := <-got:
+		t.Fatalf("Dequeue() returned %s while A was busy", wi.trade.ID)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.Done(first)
+	select {
+	case wi := <-got:
+		if wi.trade.ID != "a2" {
+			t.Errorf("Dequeue() = %s, want a2", wi.trade.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Dequeue() did not wake up after Done")
+	}
+}
+
+func TestTradeQueueCloseAndDrain(t *testing.T) {
+	q := NewTradeQueue()
+	q.Enqueue(newTestItem("A", "a1"))
+	q.Enqueue(newTestItem("A", "a2"))
+	q.Dequeue()
+
+	q.Close()
+	if wi := q.Dequeue(); wi != nil {
+		t.Errorf("Dequeue() after Close = %s, want nil", wi.trade.ID)
+	}
+	if err := q.Enqueue(newTestItem("A", "a3")); err != ErrQueueClosed {
+		t.Errorf("Enqueue() after Close error = %v, want ErrQueueClosed", err)
+	}
+
+	drained := q.Drain()
+	if len(drained) != 1 || drained[0].trade.ID != "a2" {
+		t.Errorf("Drain() = %d items, want only a2", len(drained))
+	}
+	if !q.IsEmpty() {
+		t.Error("queue not empty after Drain")
+	}
+}
+
+func TestTradeQueuePriorityClasses(t *testing.T) {
+	q := NewTradeQueue()
+	buy := newTestItem("A", "a-buy")
+	sell := newTestItem("B", "b-sell")
+	sell.priority = types.PriorityRiskReducing
+	liquidate := newTestItem("C", "c-liquidate")
+	liquidate.priority = types.PriorityEmergency
+	for _, wi := range []*workItem{buy, sell, liquidate} {
+		q.Enqueue(wi)
+	}
+
+	// C's liquidation jumps B's sell, which jumps A's buy
+	var got []string
+	for i := 0; i < 3; i++ {
+		wi := q.Dequeue()
+		got = append(got, wi.trade.ID)
+		q.Done(wi)
+	}
+	want := []string{"c-liquidate", "b-sell", "a-buy"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestTradeQueueRoundRobinAndAging(t *testing.T) {
+	q := NewTradeQueue()
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	for _, wi := range []*workItem{newTestItem("A", "a1"), newTestItem("A", "a2"), newTestItem("B", "b1")} {
+		q.Enqueue(wi)
+	}
+	first := q.Dequeue()
+	q.Done(first)
+	if second := q.Dequeue(); second.trade.ID != "b1" {
+		t.Errorf("after serving A, Dequeue() = %s, want b1 (round-robin)", second.trade.ID)
+	} else {
+		q.Done(second)
+	}
+	q.Dequeue() // a2
+
+	// an old normal order ages past a fresh risk-reducing one
+	q = NewTradeQueue()
+	q.now = func() time.Time { return now }
+	q.Enqueue(newTestItem("A", "old-buy"))
+	now = now.Add(priorityAging)
+	sell := newTestItem("B", "new-sell")
+	sell.priority = types.PriorityRiskReducing
+	q.Enqueue(sell)
+	if wi := q.Dequeue(); wi.trade.ID != "old-buy" {
+		t.Errorf("Dequeue() = %s, want old-buy after aging", wi.trade.ID)
+	}
+}
+
+func TestTradeQueueKeepsAccountOrder(t *testing.T) {
+	q := NewTradeQueue()
+
+	// a decision's sell frees the cash for the buy after it, so a more urgent
+	// item later in the account's queue never jumps the ones before it
+	sell := newTestItem("A", "a-sell")
+	buy := newTestItem("A", "a-buy")
+	liquidate := newTestItem("A", "a-liquidate")
+	liquidate.priority = types.PriorityEmergency
+	other := newTestItem("B", "b-sell")
+	other.priority = types.PriorityRiskReducing
+	for _, wi := range []*workItem{sell, buy, liquidate, other} {
+		q.Enqueue(wi)
+	}
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		wi := q.Dequeue()
+		got = append(got, wi.trade.ID)
+		q.Done(wi)
+	}
+	want := []string{"b-sell", "a-sell", "a-buy", "a-liquidate"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("order = %v, want %v", got, want)
+		}
+	}
+}
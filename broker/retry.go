@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/rs/zerolog/log"
+)
+
+// Retry bounds for placing an order. The backoff doubles after every retryable
+// failure, up to retryMaxBackoff, with random jitter so agents that failed
+// together do not retry together.
+var (
+	retryMaxAttempts    = 5
+	retryInitialBackoff = 500 * time.Millisecond
+	retryMaxBackoff     = 10 * time.Second
+	retryJitter         = func(d time.Duration) time.Duration { return d/2 + time.Duration(rand.Int63n(int64(d/2)+1)) }
+)
+
+// placeOrder submits the work item's trade, retrying transient failures. The
+// trade's ID is the order's client order id, so before every retry the venue is
+// asked whether an earlier attempt went through after all; a retry never places
+// the same trade twice. Errors are returned as *services.OrderError.
+func (b *Broker) placeOrder(ctx context.Context, wi *workItem) (*alpaca.Order, error) {
+	trade := wi.trade
+	backoff := retryInitialBackoff
+
+	var lastErr error
+	for attempt := 1; attempt <= retryMaxAttempts; attempt++ {
+		if attempt > 1 {
+			wait := retryJitter(backoff)
+			log.Warn().Err(lastErr).Str("order_id", trade.ID).Int("attempt", attempt).Dur("backoff", wait).Msg("Retrying order")
+
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, &services.OrderError{Kind: services.ErrorRetryable, Attempts: attempt - 1, Err: lastErr}
+			case <-timer.C:
+			}
+			backoff = min(backoff*2, retryMaxBackoff)
+
+			order, err := wi.venue.GetOrderByClientOrderID(trade.ID)
+			if err == nil {
+				log.Info().Str("order_id", trade.ID).Str("alpaca_id", order.ID).Msg("Found order placed by an earlier attempt")
+				return order, nil
+			}
+			if !services.IsNotFound(err) {
+				// without knowing whether the order exists it is not safe to place it again
+				lastErr = err
+				if services.IsRetryable(err) {
+					continue
+				}
+				return nil, &services.OrderError{Kind: services.ClassifyError(err), Attempts: attempt - 1, Err: err}
+			}
+		}
+
+		order, err := wi.venue.PlaceOrder(trade)
+		if err == nil {
+			return order, nil
+		}
+		lastErr = err
+		if !services.IsRetryable(err) {
+			return nil, &services.OrderError{Kind: services.ClassifyError(err), Attempts: attempt, Err: err}
+		}
+	}
+
+	return nil, &services.OrderError{Kind: services.ErrorRetryable, Attempts: retryMaxAttempts, Err: lastErr}
+}
@@ -0,0 +1,78 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+)
+
+// flakyVenue fails PlaceOrder with the scripted errors. When lost is set the
+// first failed request still creates the order, as if only the response was lost.
+type flakyVenue struct {
+	scriptedVenue
+	errs   []error
+	lost   bool
+	placed int
+	order  *alpaca.Order
+}
+
+func (v *flakyVenue) PlaceOrder(trade *types.Trade) (*alpaca.Order, error) {
+	v.placed++
+	if v.placed <= len(v.errs) {
+		if v.lost && v.order == nil {
+			v.order = &alpaca.Order{ID: "o1", ClientOrderID: trade.ID, Status: types.OrderStatusNew}
+		}
+		return nil, v.errs[v.placed-1]
+	}
+	v.order = &alpaca.Order{ID: "o1", ClientOrderID: trade.ID, Status: types.OrderStatusNew}
+	return v.order, nil
+}
+
+func (v *flakyVenue) GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error) {
+	if v.order == nil || v.order.ClientOrderID != clientOrderID {
+		return nil, &alpaca.APIError{StatusCode: 404, Message: "order not found"}
+	}
+	return v.order, nil
+}
+
+func TestPlaceOrderRetries(t *testing.T) {
+	jitter := retryJitter
+	retryJitter = func(time.Duration) time.Duration { return time.Millisecond }
+	defer func() { retryJitter = jitter }()
+
+	unavailable := &alpaca.APIError{StatusCode: 503, Message: "service unavailable"}
+	tests := []struct {
+		name       string
+		venue      *flakyVenue
+		wantPlaced int
+		wantKind   services.ErrorKind
+	}{
+		{"recovers after 5xx", &flakyVenue{errs: []error{unavailable, unavailable}}, 3, ""},
+		{"finds the lost order instead of placing again", &flakyVenue{errs: []error{unavailable}, lost: true}, 1, ""},
+		{"gives up after the last attempt", &flakyVenue{errs: []error{unavailable, unavailable, unavailable, unavailable, unavailable}}, retryMaxAttempts, services.ErrorRetryable},
+		{"does not retry rejections", &flakyVenue{errs: []error{&alpaca.APIError{StatusCode: 403, Message: "insufficient buying power"}}}, 1, services.ErrorPermanent},
+	}
+	for _, tt := range tests {
+		wi := &workItem{trade: &types.Trade{ID: "t1", Symbol: "AAPL", Action: "BUY"}, venue: tt.venue}
+		order, err := NewBroker().placeOrder(context.Background(), wi)
+
+		if tt.venue.placed != tt.wantPlaced {
+			t.Errorf("%s: PlaceOrder called %d times, want %d", tt.name, tt.venue.placed, tt.wantPlaced)
+		}
+		if tt.wantKind == "" {
+			if err != nil || order == nil {
+				t.Errorf("%s: placeOrder() = %v, %v, want the order", tt.name, order, err)
+			}
+			continue
+		}
+		var orderErr *services.OrderError
+		if !errors.As(err, &orderErr) || orderErr.Kind != tt.wantKind {
+			t.Errorf("%s: placeOrder() error = %v, want %s OrderError", tt.name, err, tt.wantKind)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package broker
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+// Risk rule names reported in RiskViolation.Rule.
+const (
+	RuleSymbolDenied      = "symbol_denied"
+	RuleSymbolNotAllowed  = "symbol_not_allowed"
+	RuleAvailableQty      = "available_qty"
+	RuleMinNotional       = "min_notional"
+	RuleMaxOrderNotional  = "max_order_notional"
+	RuleBuyingPower       = "buying_power"
+	RuleCashOnly          = "cash_only"
+	RuleMaxLeverage       = "max_leverage"
+	RuleMaxOpenPositions  = "max_open_positions"
+	RuleMaxPositionWeight = "max_position_weight"
+	RuleMaxDailyTurnover  = "max_daily_turnover"
+)
+
+// RiskConfig configures the pre-trade checks every order passes before it
+// reaches a venue. Zero values disable a rule.
+type RiskConfig struct {
+	MaxPositionWeight decimal.Decimal // max share of equity in one symbol after a BUY, e.g. 0.3 = 30%
+	MaxOrderNotional  decimal.Decimal // max dollars in a single order
+	MaxDailyTurnover  decimal.Decimal // max dollars traded per account per trading day
+	MaxOpenPositions  int             // max number of distinct symbols held
+	AllowSymbols      []string        // if set, only these symbols may be traded
+	DenySymbols       []string        // symbols that may never be traded
+	MinNotional       decimal.Decimal // smallest order in dollars; Alpaca's fractional minimum is $1
+	CashOnly          bool            // BUYs must be covered by settled cash, never margin
+	MaxLeverage       decimal.Decimal // max (long market value + order) / equity when margin is allowed
+}
+
+// DefaultRiskConfig returns the rules every broker starts with: a $1 minimum
+// order and no margin.
+func DefaultRiskConfig() RiskConfig {
+	return RiskConfig{
+		MinNotional: decimal.NewFromInt(1),
+		CashOnly:    true,
+	}
+}
+
+// RiskViolation is a single failed rule.
+type RiskViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// RiskError is returned for trades rejected by the risk engine. It lists every
+// rule the trade broke so agents (and the LLM, via its last error) can see why.
+type RiskError struct {
+	Symbol     string          `json:"symbol"`
+	Action     string          `json:"action"`
+	Violations []RiskViolation `json:"violations"`
+}
+
+func (e *RiskError) Error() string {
+	reasons := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		reasons = append(reasons, v.Rule+": "+v.Message)
+	}
+	return fmt.Sprintf("%s %s rejected by risk checks: %s", e.Action, e.Symbol, strings.Join(reasons, "; "))
+}
+
+// RiskEngine runs the pre-trade checks and keeps the per-account daily turnover
+// they need.
+type RiskEngine struct {
+	mu       sync.Mutex
+	config   RiskConfig
+	turnover map[string]map[string]decimal.Decimal // account -> market date -> notional
+}
+
+// NewRiskEngine creates a risk engine with the given rules.
+func NewRiskEngine(config RiskConfig) *RiskEngine {
+	return &RiskEngine{
+		config:   config,
+		turnover: make(map[string]map[string]decimal.Decimal),
+	}
+}
+
+// Check validates the trade against the account and positions it would trade on.
+// It returns a *RiskError listing every violated rule, or nil.
+func (r *RiskEngine) Check(trade *types.Trade, account *alpaca.Account, positions []alpaca.Position, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cfg := r.config
+
+	var violations []RiskViolation
+	fail := func(rule, format string, args ...any) {
+		violations = append(violations, RiskViolation{Rule: rule, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if slices.Contains(cfg.DenySymbols, trade.Symbol) {
+		fail(RuleSymbolDenied, "%s is on the deny list", trade.Symbol)
+	}
+	if len(cfg.AllowSymbols) > 0 && !slices.Contains(cfg.AllowSymbols, trade.Symbol) {
+		fail(RuleSymbolNotAllowed, "%s is not on the allow list", trade.Symbol)
+	}
+
+	position := FindPosition(positions, trade.Symbol)
+
+	if trade.Action == "SELL" {
+		available := decimal.Zero
+		if position != nil {
+			available = position.QtyAvailable
+		}
+		if trade.Quantity != nil && trade.Quantity.GreaterThan(available) {
+			fail(RuleAvailableQty, "quantity %s is greater than the %s shares available", trade.Quantity, available)
+		}
+	}
+
+	notional, known := estimateNotional(trade, position)
+	if known {
+		// closing out a whole fractional position is allowed below the minimum
+		closesPosition := trade.Action == "SELL" && position != nil && trade.Quantity.Equal(position.Qty)
+		if cfg.MinNotional.IsPositive() && notional.LessThan(cfg.MinNotional) && !closesPosition {
+			fail(RuleMinNotional, "order notional $%s is below the $%s minimum", notional.StringFixed(2), cfg.MinNotional)
+		}
+		if cfg.MaxOrderNotional.IsPositive() && notional.GreaterThan(cfg.MaxOrderNotional) {
+			fail(RuleMaxOrderNotional, "order notional $%s exceeds the $%s limit", notional.StringFixed(2), cfg.MaxOrderNotional)
+		}
+		if cfg.MaxDailyTurnover.IsPositive() {
+			traded := r.turnover[trade.AgentName][utils.MarketDate(now)]
+			if traded.Add(notional).GreaterThan(cfg.MaxDailyTurnover) {
+				fail(RuleMaxDailyTurnover, "$%s already traded today, this order would exceed the $%s daily limit", traded.StringFixed(2), cfg.MaxDailyTurnover)
+			}
+		}
+	}
+
+	if trade.Action == "BUY" {
+		if known && notional.GreaterThan(account.BuyingPower) {
+			fail(RuleBuyingPower, "order notional $%s is greater than buying power $%s", notional.StringFixed(2), account.BuyingPower.StringFixed(2))
+		}
+		if known && cfg.CashOnly && notional.GreaterThan(account.Cash) {
+			fail(RuleCashOnly, "order notional $%s is greater than cash $%s and margin is not allowed", notional.StringFixed(2), account.Cash.StringFixed(2))
+		}
+		if known && !cfg.CashOnly && cfg.MaxLeverage.IsPositive() && account.Equity.IsPositive() {
+			leverage := account.LongMarketValue.Add(notional).Div(account.Equity)
+			if leverage.GreaterThan(cfg.MaxLeverage) {
+				fail(RuleMaxLeverage, "leverage would be %sx, above the %sx limit", leverage.StringFixed(2), cfg.MaxLeverage)
+			}
+		}
+		if position == nil && cfg.MaxOpenPositions > 0 && len(positions) >= cfg.MaxOpenPositions {
+			fail(RuleMaxOpenPositions, "already holding %d positions, the limit is %d", len(positions), cfg.MaxOpenPositions)
+		}
+		if known && cfg.MaxPositionWeight.IsPositive() && account.Equity.IsPositive() {
+			held := decimal.Zero
+			if position != nil && position.MarketValue != nil {
+				held = *position.MarketValue
+			}
+			weight := held.Add(notional).Div(account.Equity)
+			if weight.GreaterThan(cfg.MaxPositionWeight) {
+				fail(RuleMaxPositionWeight, "%s would be %s%% of equity, above the %s%% limit",
+					trade.Symbol, weight.Mul(decimal.NewFromInt(100)).StringFixed(1), cfg.MaxPositionWeight.Mul(decimal.NewFromInt(100)).StringFixed(1))
+			}
+		}
+	}
+
+	if len(violations) > 0 {
+		return &RiskError{Symbol: trade.Symbol, Action: trade.Action, Violations: violations}
+	}
+	return nil
+}
+
+// Record adds an accepted order's notional to its account's daily turnover.
+func (r *RiskEngine) Record(trade *types.Trade, position *alpaca.Position, now time.Time) {
+	notional, known := estimateNotional(trade, position)
+	if !known {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	days, ok := r.turnover[trade.AgentName]
+	if !ok {
+		days = make(map[string]decimal.Decimal)
+		r.turnover[trade.AgentName] = days
+	}
+	date := utils.MarketDate(now)
+	// only today's total is ever read
+	for d := range days {
+		if d != date {
+			delete(days, d)
+		}
+	}
+	days[date] = days[date].Add(notional)
+}
+
+// estimateNotional returns the dollar value of the order: the amount for
+// notional BUYs, otherwise quantity times the best price available.
+func estimateNotional(trade *types.Trade, position *alpaca.Position) (decimal.Decimal, bool) {
+	if trade.Action == "BUY" && trade.Amount != nil && trade.Amount.IsPositive() {
+		return *trade.Amount, true
+	}
+	if trade.Quantity == nil {
+		return decimal.Zero, false
+	}
+
+	var price *decimal.Decimal
+	switch {
+	case trade.LimitPrice != nil:
+		price = trade.LimitPrice
+	case trade.Price != nil:
+		price = trade.Price
+	case position != nil && position.CurrentPrice != nil:
+		price = position.CurrentPrice
+	default:
+		return decimal.Zero, false
+	}
+	return trade.Quantity.Mul(*price), true
+}
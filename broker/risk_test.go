@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+func decimalPtr(v string) *decimal.Decimal {
+	d := decimal.RequireFromString(v)
+	return &d
+}
+
+func TestRiskEngineCheck(t *testing.T) {
+	account := &alpaca.Account{
+		Cash:            decimal.NewFromInt(500),
+		BuyingPower:     decimal.NewFromInt(1000),
+		Equity:          decimal.NewFromInt(1000),
+		LongMarketValue: decimal.NewFromInt(500),
+	}
+	positions := []alpaca.Position{{
+		Symbol:       "AAPL",
+		Qty:          decimal.NewFromInt(2),
+		QtyAvailable: decimal.NewFromInt(2),
+		MarketValue:  decimalPtr("400"),
+		CurrentPrice: decimalPtr("200"),
+	}, {
+		Symbol:       "NVDA",
+		Qty:          decimal.RequireFromString("0.001"),
+		QtyAvailable: decimal.RequireFromString("0.001"),
+		MarketValue:  decimalPtr("0.1"),
+		CurrentPrice: decimalPtr("100"),
+	}}
+
+	tests := []struct {
+		name   string
+		config RiskConfig
+		trade  *types.Trade
+		want   []string
+	}{
+		{"within limits", DefaultRiskConfig(), &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("100")}, nil},
+		{"cash only", DefaultRiskConfig(), &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("600")}, []string{RuleCashOnly}},
+		{"margin within leverage", RiskConfig{MaxLeverage: decimal.NewFromInt(2)}, &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("600")}, nil},
+		{"over leverage", RiskConfig{MaxLeverage: decimal.NewFromInt(1)}, &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("600")}, []string{RuleMaxLeverage}},
+		{"below minimum", DefaultRiskConfig(), &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("0.5")}, []string{RuleMinNotional}},
+		{"closing dust position", DefaultRiskConfig(), &types.Trade{Symbol: "NVDA", Action: "SELL", Quantity: decimalPtr("0.001")}, nil},
+		{"oversell", DefaultRiskConfig(), &types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: decimalPtr("3")}, []string{RuleAvailableQty}},
+		{"deny list", RiskConfig{DenySymbols: []string{"GME"}}, &types.Trade{Symbol: "GME", Action: "BUY", Amount: decimalPtr("10")}, []string{RuleSymbolDenied}},
+		{"allow list", RiskConfig{AllowSymbols: []string{"AAPL"}}, &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("10")}, []string{RuleSymbolNotAllowed}},
+		{"order notional", RiskConfig{MaxOrderNotional: decimal.NewFromInt(50)}, &types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: decimalPtr("1")}, []string{RuleMaxOrderNotional}},
+		{"open positions", RiskConfig{MaxOpenPositions: 2}, &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("10")}, []string{RuleMaxOpenPositions}},
+		{"adding to a held symbol", RiskConfig{MaxOpenPositions: 2}, &types.Trade{Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("10")}, nil},
+		{"position weight", RiskConfig{MaxPositionWeight: decimal.RequireFromString("0.45")}, &types.Trade{Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("100")}, []string{RuleMaxPositionWeight}},
+		{"several rules", RiskConfig{DenySymbols: []string{"MSFT"}, CashOnly: true}, &types.Trade{Symbol: "MSFT", Action: "BUY", Amount: decimalPtr("600")}, []string{RuleSymbolDenied, RuleCashOnly}},
+	}
+	for _, tt := range tests {
+		err := NewRiskEngine(tt.config).Check(tt.trade, account, positions, time.Now())
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: Check() error = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+
+		var riskErr *RiskError
+		if !errors.As(err, &riskErr) {
+			t.Errorf("%s: Check() error = %v, want *RiskError", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, v := range riskErr.Violations {
+			got = append(got, v.Rule)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: violations = %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: violations = %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRiskEngineDailyTurnover(t *testing.T) {
+	engine := NewRiskEngine(RiskConfig{MaxDailyTurnover: decimal.NewFromInt(100)})
+	account := &alpaca.Account{Cash: decimal.NewFromInt(1000), BuyingPower: decimal.NewFromInt(1000), Equity: decimal.NewFromInt(1000)}
+	trade := &types.Trade{Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("60"), AgentName: "rng"}
+	day := time.Date(2025, 3, 3, 15, 0, 0, 0, time.UTC)
+
+	if err := engine.Check(trade, account, nil, day); err != nil {
+		t.Fatalf("first Check() error = %v", err)
+	}
+	engine.Record(trade, nil, day)
+	if err := engine.Check(trade, account, nil, day); err == nil {
+		t.Error("second Check() on the same day passed, want max_daily_turnover")
+	}
+	if err := engine.Check(trade, account, nil, day.Add(24*time.Hour)); err != nil {
+		t.Errorf("Check() on the next day error = %v, want nil", err)
+	}
+
+	other := *trade
+	other.AgentName = "llm"
+	if err := engine.Check(&other, account, nil, day); err != nil {
+		t.Errorf("Check() for another account error = %v, want nil", err)
+	}
+}
@@ -0,0 +1,119 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// Polling bounds for order tracking. The interval doubles after every poll that
+// does not reach a terminal state, up to pollMaxInterval.
+var (
+	pollInitialInterval = 500 * time.Millisecond
+	pollMaxInterval     = 30 * time.Second
+)
+
+// IsTerminalStatus reports whether an order can no longer change.
+func IsTerminalStatus(status string) bool {
+	switch status {
+	case types.OrderStatusFilled, types.OrderStatusCanceled, types.OrderStatusRejected, types.OrderStatusExpired:
+		return true
+	}
+	return false
+}
+
+// trackOrder follows an order through new/partially_filled until it is filled,
+// canceled, rejected or expired, then fires the completion callback with the
+// real filled quantity, average price and notional.
+func (b *Broker) trackOrder(ctx context.Context, wi *workItem, order *alpaca.Order) {
+	trade := wi.trade
+	interval := pollInitialInterval
+	lastStatus := order.Status
+
+	for !IsTerminalStatus(order.Status) {
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			log.Warn().Str("order_id", trade.ID).Str("status", order.Status).Msg("Stopped tracking order before it completed")
+			b.finishOpen(trade, order.Status)
+			wi.complete(trade, nil, fmt.Errorf("stopped tracking order %s in state %q: %w", trade.ID, order.Status, ctx.Err()))
+			return
+		case <-timer.C:
+		}
+
+		interval = min(interval*2, pollMaxInterval)
+
+		latest, err := wi.venue.GetOrder(order.ID)
+		if err != nil {
+			// keep the last known state and try again on the next poll
+			log.Warn().Err(err).Str("order_id", trade.ID).Msg("Error polling order status")
+			continue
+		}
+		order = latest
+
+		// a replaced order lives on as a new order, follow it
+		if order.Status == types.OrderStatusReplaced && order.ReplacedBy != nil {
+			replacement, err := wi.venue.GetOrder(*order.ReplacedBy)
+			if err != nil {
+				log.Warn().Err(err).Str("order_id", trade.ID).Msg("Error fetching replacement order")
+				continue
+			}
+			log.Info().Str("order_id", trade.ID).Str("alpaca_id", replacement.ID).Msg("Following replacement order")
+			b.followReplacement(trade, replacement)
+			order = replacement
+		}
+
+		if order.Status != lastStatus {
+			log.Info().Str("order_id", trade.ID).Str("status", order.Status).Str("filled_qty", order.FilledQty.String()).Msg("Order status changed")
+			lastStatus = order.Status
+		}
+	}
+
+	b.mu.Lock()
+	ApplyFill(trade, order)
+	b.mu.Unlock()
+	b.finishOpen(trade, order.Status)
+
+	if order.FilledQty.IsZero() {
+		log.Error().Str("order_id", trade.ID).Str("status", order.Status).Msg("Order finished without a fill")
+		wi.complete(trade, nil, fmt.Errorf("order %s was %s without a fill", trade.ID, order.Status))
+		return
+	}
+
+	if order.Status != types.OrderStatusFilled {
+		log.Warn().Str("order_id", trade.ID).Str("status", order.Status).Str("filled_qty", order.FilledQty.String()).Msg("Order partially filled")
+	} else {
+		log.Info().Str("order_id", trade.ID).Str("filled_qty", trade.Quantity.String()).Str("filled_avg_price", trade.Price.String()).Msg("Order filled")
+	}
+	wi.complete(trade, trade, nil)
+}
+
+// ApplyFill copies the venue's fill information onto the trade. The values are
+// copied so the trade never aliases memory owned by the order.
+func ApplyFill(trade *types.Trade, order *alpaca.Order) {
+	trade.Status = order.Status
+	trade.AlpacaID = order.ID
+	if order.FilledAt != nil {
+		filledAt := *order.FilledAt
+		trade.FilledAt = &filledAt
+	}
+
+	qty := order.FilledQty
+	trade.Quantity = &qty
+
+	if order.FilledAvgPrice != nil {
+		price := *order.FilledAvgPrice
+		notional := qty.Mul(price).Round(2)
+		trade.Price = &price
+		trade.Amount = &notional
+	} else {
+		notional := decimal.Zero
+		trade.Amount = &notional
+	}
+}
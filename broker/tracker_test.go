@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+// scriptedVenue returns a fixed sequence of order states from GetOrder.
+type scriptedVenue struct {
+	states []alpaca.Order
+	polls  int
+}
+
+func (v *scriptedVenue) PlaceOrder(trade *types.Trade) (*alpaca.Order, error) {
+	o := v.states[0]
+	return &o, nil
+}
+
+func (v *scriptedVenue) GetOrder(orderID string) (*alpaca.Order, error) {
+	v.polls++
+	o := v.states[min(v.polls, len(v.states)-1)]
+	return &o, nil
+}
+
+func (v *scriptedVenue) GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error) {
+	return nil, &alpaca.APIError{StatusCode: 404, Message: "order not found"}
+}
+
+func (v *scriptedVenue) ReplaceOrder(orderID string, changes types.TradeChanges) (*alpaca.Order, error) {
+	return nil, &alpaca.APIError{StatusCode: 422, Message: "not replaceable"}
+}
+
+func (v *scriptedVenue) CancelOrder(orderID string) error         { return nil }
+func (v *scriptedVenue) GetAccount() (*alpaca.Account, error)     { return &alpaca.Account{}, nil }
+func (v *scriptedVenue) GetPositions() ([]alpaca.Position, error) { return nil, nil }
+
+func TestTrackOrderPartialFill(t *testing.T) {
+	pollInitialInterval = time.Millisecond
+	defer func() { pollInitialInterval = 500 * time.Millisecond }()
+
+	price := decimal.NewFromInt(10)
+	venue := &scriptedVenue{states: []alpaca.Order{
+		{ID: "o1", Status: types.OrderStatusNew},
+		{ID: "o1", Status: types.OrderStatusPartiallyFilled, FilledQty: decimal.NewFromInt(2), FilledAvgPrice: &price},
+		{ID: "o1", Status: types.OrderStatusCanceled, FilledQty: decimal.NewFromInt(3), FilledAvgPrice: &price},
+	}}
+
+	done := make(chan struct{})
+	var got *types.Trade
+	var gotErr error
+	b := NewBroker()
+	wi := &workItem{
+		trade: &types.Trade{ID: "t1", Symbol: "AAPL", Action: "BUY"},
+		venue: venue,
+		onComplete: func(_ *types.Trade, processed *types.Trade, err error) {
+			got, gotErr = processed, err
+			close(done)
+		},
+	}
+	b.process(context.Background(), wi)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("completion callback never fired")
+	}
+	if gotErr != nil {
+		t.Fatalf("completion error = %v", gotErr)
+	}
+	if got.Status != types.OrderStatusCanceled || !got.Quantity.Equal(decimal.NewFromInt(3)) {
+		t.Errorf("trade = %s %s, want canceled with 3 filled", got.Status, got.Quantity)
+	}
+	if !got.Amount.Equal(decimal.NewFromInt(30)) || !got.Price.Equal(price) {
+		t.Errorf("amount/price = %s/%s, want 30/10", got.Amount, got.Price)
+	}
+}
+
+func TestTrackOrderRejected(t *testing.T) {
+	venue := &scriptedVenue{states: []alpaca.Order{{ID: "o1", Status: types.OrderStatusRejected}}}
+
+	var gotErr error
+	wi := &workItem{
+		trade:      &types.Trade{ID: "t1", Symbol: "AAPL", Action: "BUY"},
+		venue:      venue,
+		onComplete: func(_ *types.Trade, _ *types.Trade, err error) { gotErr = err },
+	}
+	NewBroker().trackOrder(context.Background(), wi, &venue.states[0])
+
+	if gotErr == nil {
+		t.Fatal("expected an error for a rejected order")
+	}
+}
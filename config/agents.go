@@ -0,0 +1,164 @@
+// Package config reads the agents file, which lists the agents to run with
+// their strategy, credentials, schedule, symbols and strategy parameters.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Agents is the agents file.
+type Agents struct {
+	Agents []Agent `yaml:"agents"`
+	// LLMPrices are what models cost, by model id, in place of the built-in
+	// prices and the provider's reported cost
+	LLMPrices map[string]ModelPrice `yaml:"llm_prices"`
+	// Risk are the pre-trade checks every agent's orders pass, the broker's
+	// defaults when not set
+	Risk *Risk `yaml:"risk"`
+}
+
+// Risk configures the broker's pre-trade checks. Zero values disable a rule;
+// MinNotional and CashOnly keep the broker's defaults ($1 and true) when not set.
+type Risk struct {
+	MaxPositionWeight float64  `yaml:"max_position_weight"` // max share of equity in one symbol after a BUY, e.g. 0.3
+	MaxOrderNotional  float64  `yaml:"max_order_notional"`  // max dollars in a single order
+	MaxDailyTurnover  float64  `yaml:"max_daily_turnover"`  // max dollars traded per account per trading day
+	MaxOpenPositions  int      `yaml:"max_open_positions"`  // max number of distinct symbols held
+	AllowSymbols      []string `yaml:"allow_symbols"`       // if set, only these symbols may be traded
+	DenySymbols       []string `yaml:"deny_symbols"`        // symbols that may never be traded
+	MinNotional       *float64 `yaml:"min_notional"`        // smallest order in dollars
+	CashOnly          *bool    `yaml:"cash_only"`           // BUYs must be covered by cash, never margin
+	MaxLeverage       float64  `yaml:"max_leverage"`        // max (long market value + order) / equity, with cash_only false
+}
+
+// ModelPrice is what a model costs in dollars per million tokens.
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// Agent is one agent to run.
+type Agent struct {
+	Name      string         `yaml:"name"`       // unique name the agent trades and logs under
+	Strategy  string         `yaml:"strategy"`   // registered strategy type, e.g. "rng" or "llm"
+	KeyEnv    string         `yaml:"key_env"`    // env var holding the agent's Alpaca API key
+	SecretEnv string         `yaml:"secret_env"` // env var holding the agent's Alpaca API secret
+	Schedule  Schedule       `yaml:"schedule"`
+	Symbols   []string       `yaml:"symbols"` // symbols the agent may trade; empty for every tradable symbol
+	Params    map[string]any `yaml:"params"`  // strategy parameters, e.g. the LLM model
+}
+
+// Schedule is when an agent trades. The zero value decides on every tick of the regular session.
+type Schedule struct {
+	Every           time.Duration `yaml:"every"`             // how often to decide, e.g. 30m
+	ExtendedHours   bool          `yaml:"extended_hours"`    // also trade the pre-market and after-hours sessions
+	SkipBeforeClose time.Duration `yaml:"skip_before_close"` // stop trading this long before the close, e.g. 15m
+}
+
+// Default returns the agents run when there is no agents file: one RNG and one
+// LLM agent, each on its own Alpaca account.
+func Default() *Agents {
+	return &Agents{Agents: []Agent{
+		{Name: "RNG_Agent", Strategy: "rng", KeyEnv: "ALPACA_KEY_RNG", SecretEnv: "ALPACA_SECRET_RNG"},
+		{Name: "LLM_Agent", Strategy: "llm", KeyEnv: "ALPACA_KEY_LLM", SecretEnv: "ALPACA_SECRET_LLM"},
+	}}
+}
+
+// Load reads the agents file at path. A missing file gives the Default agents
+// and ok false, so runs without a file keep working.
+func Load(path string) (agents *Agents, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return Default(), false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	agents, err = Parse(data)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", path, err)
+	}
+	return agents, true, nil
+}
+
+// Parse decodes and validates an agents file. Unknown fields are errors, so
+// a misspelled setting is not silently ignored.
+func Parse(data []byte) (*Agents, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var agents Agents
+	if err := dec.Decode(&agents); err != nil {
+		return nil, err
+	}
+	if err := agents.Validate(); err != nil {
+		return nil, err
+	}
+	return &agents, nil
+}
+
+// Validate checks that every agent has a unique name, a strategy and
+// credentials. Whether the strategy exists is checked when it is created.
+func (c *Agents) Validate() error {
+	if len(c.Agents) == 0 {
+		return fmt.Errorf("no agents configured")
+	}
+	names := make(map[string]bool, len(c.Agents))
+	for i, a := range c.Agents {
+		switch {
+		case a.Name == "":
+			return fmt.Errorf("agent %d: name is required", i+1)
+		case names[a.Name]:
+			return fmt.Errorf("agent %s: name is used twice", a.Name)
+		case a.Strategy == "":
+			return fmt.Errorf("agent %s: strategy is required", a.Name)
+		case a.KeyEnv == "" || a.SecretEnv == "":
+			return fmt.Errorf("agent %s: key_env and secret_env are required", a.Name)
+		case a.Schedule.Every < 0 || a.Schedule.SkipBeforeClose < 0:
+			return fmt.Errorf("agent %s: schedule durations must not be negative", a.Name)
+		}
+		names[a.Name] = true
+	}
+	for model, price := range c.LLMPrices {
+		if price.Prompt < 0 || price.Completion < 0 {
+			return fmt.Errorf("llm_prices: %s: prices must not be negative", model)
+		}
+	}
+	if c.Risk != nil {
+		if err := c.Risk.Validate(); err != nil {
+			return fmt.Errorf("risk: %w", err)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the limits are not negative, that the position weight
+// is a share of equity and that no symbol is both allowed and denied.
+func (r *Risk) Validate() error {
+	switch {
+	case r.MaxPositionWeight < 0 || r.MaxOrderNotional < 0 || r.MaxDailyTurnover < 0 || r.MaxOpenPositions < 0 || r.MaxLeverage < 0:
+		return fmt.Errorf("limits must not be negative")
+	case r.MinNotional != nil && *r.MinNotional < 0:
+		return fmt.Errorf("min_notional must not be negative")
+	case r.MaxPositionWeight > 1:
+		return fmt.Errorf("max_position_weight is a share of equity, at most 1")
+	case r.MaxOrderNotional > 0 && r.MinNotional != nil && *r.MinNotional > r.MaxOrderNotional:
+		return fmt.Errorf("min_notional is above max_order_notional")
+	case r.MaxLeverage > 0 && (r.CashOnly == nil || *r.CashOnly):
+		return fmt.Errorf("max_leverage needs cash_only: false")
+	}
+	for _, symbol := range r.DenySymbols {
+		if slices.Contains(r.AllowSymbols, symbol) {
+			return fmt.Errorf("%s is both allowed and denied", symbol)
+		}
+	}
+	return nil
+}
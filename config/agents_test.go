@@ -0,0 +1,70 @@
+package config
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadExample(t *testing.T) {
+	agents, found, err := Load(filepath.Join("..", "agents.example.yaml"))
+	if err != nil || !found {
+		t.Fatalf("Load(agents.example.yaml) = %v, %v", found, err)
+	}
+	if len(agents.Agents) != 2 {
+		t.Fatalf("agents = %+v, want 2", agents.Agents)
+	}
+	llm := agents.Agents[1]
+	if llm.Strategy != "llm" || llm.Schedule.SkipBeforeClose != 15*time.Minute || llm.Params["model"] != "google/gemini-2.5-flash" {
+		t.Errorf("LLM agent = %+v", llm)
+	}
+}
+
+func TestLoadMissingFileUsesDefault(t *testing.T) {
+	agents, found, err := Load(filepath.Join(t.TempDir(), "agents.yaml"))
+	if err != nil || found || len(agents.Agents) != len(Default().Agents) {
+		t.Errorf("Load(missing) = %+v, %v, %v; want the default agents", agents, found, err)
+	}
+}
+
+func TestParseRisk(t *testing.T) {
+	agents, err := Parse([]byte("agents:\n  - name: A\n    strategy: rng\n    key_env: K\n    secret_env: S\n" +
+		"risk:\n  max_position_weight: 0.3\n  max_open_positions: 5\n  deny_symbols: [GME]\n  cash_only: false\n  max_leverage: 2\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	r := agents.Risk
+	if r == nil || r.MaxPositionWeight != 0.3 || r.MaxOpenPositions != 5 || len(r.DenySymbols) != 1 || r.CashOnly == nil || *r.CashOnly || r.MaxLeverage != 2 || r.MinNotional != nil {
+		t.Errorf("Risk = %+v", r)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	agent := func(name, extra string) string {
+		return "  - name: " + name + "\n    strategy: rng\n    key_env: K\n    secret_env: S\n" + extra
+	}
+	tests := []struct {
+		name string
+		yaml string
+		want string
+	}{
+		{"no agents", "agents: []\n", "no agents"},
+		{"duplicate name", "agents:\n" + agent("A", "") + agent("A", ""), "used twice"},
+		{"misspelled field", "agents:\n" + agent("A", "    schedul:\n      every: 1m\n"), "schedul"},
+		{"missing credentials", "agents:\n  - name: A\n    strategy: rng\n", "key_env"},
+		{"negative schedule", "agents:\n" + agent("A", "    schedule:\n      every: -1m\n"), "negative"},
+		{"negative price", "agents:\n" + agent("A", "") + "llm_prices:\n  x/model:\n    prompt: -1\n", "x/model"},
+		{"negative risk limit", "agents:\n" + agent("A", "") + "risk:\n  max_order_notional: -5\n", "negative"},
+		{"position weight above one", "agents:\n" + agent("A", "") + "risk:\n  max_position_weight: 30\n", "max_position_weight"},
+		{"leverage on cash only", "agents:\n" + agent("A", "") + "risk:\n  max_leverage: 2\n", "cash_only"},
+		{"allowed and denied", "agents:\n" + agent("A", "") + "risk:\n  allow_symbols: [AAPL]\n  deny_symbols: [AAPL]\n", "AAPL"},
+		{"misspelled risk field", "agents:\n" + agent("A", "") + "risk:\n  max_positions: 3\n", "max_positions"},
+	}
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.yaml))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Parse() error = %v, want it to mention %q", tt.name, err, tt.want)
+		}
+	}
+}
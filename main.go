@@ -6,43 +6,65 @@ import (
 	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
 
 	axiomAdapter "github.com/axiomhq/axiom-go/adapters/zerolog"
 	"github.com/dickeyy/cis-320/agent"
 	"github.com/dickeyy/cis-320/broker"
+	"github.com/dickeyy/cis-320/config"
 	"github.com/dickeyy/cis-320/services"
 	"github.com/dickeyy/cis-320/types"
 	"github.com/dickeyy/cis-320/utils"
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
 )
 
 var (
-	debug   bool = false
-	devMode bool = false
+	debug      bool   = false
+	devMode    bool   = false
+	simMode    bool   = false
+	agentsFile string // agents config, see config.Agent
+	aiCache    string // LLM cache mode, see services.LLMCache
+	aiCacheDir string
+	command    string // subcommand, e.g. "backtest"; empty runs the live agents
 )
 
 func parseFlags() {
 	d := flag.Bool("debug", false, "enable debug mode")
 	dev := flag.Bool("dev", false, "enable development mode (frequent trading for testing)")
+	sim := flag.Bool("sim", false, "execute orders on an in-process simulated exchange instead of Alpaca")
+	agents := flag.String("agents", "agents.yaml", "agents config file; without one an RNG and an LLM agent run")
+	cache := flag.String("llm-cache", services.AICacheOff, "LLM cache mode: off, record (store every response) or replay (answer from the store, failing on a miss)")
+	cacheDir := flag.String("llm-cache-dir", services.DefaultAICacheDir, "directory of the LLM cache")
 	flag.Usage = func() {
-		os.Stderr.WriteString("Usage: " + os.Args[0] + " [OPTIONS] <agent_type>\n")
+		os.Stderr.WriteString("Usage: " + os.Args[0] + " [OPTIONS] [backtest BACKTEST_OPTIONS | replay REPLAY_OPTIONS]\n")
 		os.Stderr.WriteString("Example: " + os.Args[0] + " --debug --dev\n")
+		os.Stderr.WriteString("Example: " + os.Args[0] + " backtest --from 2025-01-02 --to 2025-01-31 --agents rng,llm\n")
+		os.Stderr.WriteString("Example: " + os.Args[0] + " --llm-cache replay backtest --from 2025-01-02 --to 2025-01-31 --agents llm\n")
+		os.Stderr.WriteString("Example: " + os.Args[0] + " replay --agent RNG_Agent\n")
 		os.Stderr.WriteString("\nOptions:\n")
 		flag.PrintDefaults()
 	}
 	flag.Parse()
 	debug = *d
 	devMode = *dev
+	simMode = *sim
+	agentsFile = *agents
+	aiCache = *cache
+	aiCacheDir = *cacheDir
+	command = flag.Arg(0)
 }
 
 func init() {
 	parseFlags()
 
 	err := godotenv.Load(".env.local")
-	if err != nil {
+	// the offline subcommands read their settings from the environment when there is no file
+	if err != nil && command != "backtest" && command != "replay" {
 		log.Fatal().Msg("Error loading .env file")
 	}
 
@@ -87,28 +109,177 @@ func initializeServices() {
 	// pass dev mode to services for simulated execution
 	utils.SetDevMode(devMode)
 	services.InitializeAI()
-	services.InitializeRedis()
+	initializeAICache()
+	// every agent shares one request budget per Alpaca key
+	if rpm := os.Getenv("ALPACA_REQUESTS_PER_MINUTE"); rpm != "" {
+		n, err := strconv.ParseFloat(rpm, 64)
+		if err != nil || n <= 0 {
+			log.Fatal().Str("value", rpm).Msg("ALPACA_REQUESTS_PER_MINUTE must be a positive number")
+		}
+		budget := services.DefaultRateBudget()
+		budget.Rate = n / 60
+		services.SetRateBudget(budget)
+	}
+	// simulated runs can go without Redis, trades are then only logged
+	if !simMode || os.Getenv("REDIS_URL") != "" {
+		services.InitializeRedis()
+	}
 }
 
-func initializeAgents(tradeBroker *broker.Broker) []types.Agent {
-	// parse symbols
-	err := utils.ParseSymbols()
+// initializeAICache records or replays the LLM agents' requests as --llm-cache asks.
+func initializeAICache() {
+	cache, err := services.NewLLMCache(aiCache, aiCacheDir)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error initializing LLM cache")
+	}
+	services.AICache = cache
+	if cache != nil {
+		log.Info().Str("mode", cache.Mode).Str("dir", cache.Dir).Msg("LLM cache enabled")
+	}
+}
+
+// setAIPrices applies the agents config's model prices to the LLM cost accounting.
+func setAIPrices(cfg *config.Agents) {
+	for model, price := range cfg.LLMPrices {
+		services.SetAIPrice(model, services.ModelPrice{
+			Prompt:     decimal.NewFromFloat(price.Prompt),
+			Completion: decimal.NewFromFloat(price.Completion),
+		})
+	}
+}
+
+// riskConfig returns the broker's pre-trade checks with the agents config's
+// risk section applied over the defaults.
+func riskConfig(cfg *config.Agents) broker.RiskConfig {
+	risk := broker.DefaultRiskConfig()
+	r := cfg.Risk
+	if r == nil {
+		return risk
+	}
+	risk.MaxPositionWeight = decimal.NewFromFloat(r.MaxPositionWeight)
+	risk.MaxOrderNotional = decimal.NewFromFloat(r.MaxOrderNotional)
+	risk.MaxDailyTurnover = decimal.NewFromFloat(r.MaxDailyTurnover)
+	risk.MaxOpenPositions = r.MaxOpenPositions
+	risk.AllowSymbols = r.AllowSymbols
+	risk.DenySymbols = r.DenySymbols
+	risk.MaxLeverage = decimal.NewFromFloat(r.MaxLeverage)
+	if r.MinNotional != nil {
+		risk.MinNotional = decimal.NewFromFloat(*r.MinNotional)
+	}
+	if r.CashOnly != nil {
+		risk.CashOnly = *r.CashOnly
+	}
+	return risk
+}
+
+// newVenue returns the execution venue for an agent, either its Alpaca paper
+// account (credentials read from the given env vars) or a simulated exchange.
+func newVenue(keyEnv, secretEnv string, prices services.PriceSource) types.ExecutionVenue {
+	if simMode {
+		return services.NewSimulatedVenue(decimal.NewFromInt(100000), prices)
+	}
+
+	venue, _, _, err := services.InitializeAlpaca(os.Getenv(keyEnv), os.Getenv(secretEnv))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error initializing Alpaca")
+	}
+	return venue
+}
+
+// calendarFile is where the market calendar is saved for when Alpaca cannot be reached.
+const calendarFile = "data/calendar.json"
+
+// newClock returns the clock the agents and the broker run on. In dev mode the
+// market never closes, simulated runs assume regular hours and live runs follow
+// Alpaca's calendar, read with the first agent's credentials.
+func newClock(cfg *config.Agents) types.Clock {
+	switch {
+	case devMode:
+		return &services.SystemClock{AlwaysOpen: true}
+	case simMode:
+		return services.NewSystemClock()
+	default:
+		// any valid credentials can read the calendar
+		first := cfg.Agents[0]
+		calendar := services.NewCalendar(os.Getenv(first.KeyEnv), os.Getenv(first.SecretEnv), calendarFile)
+		return services.NewAlpacaClock(calendar)
+	}
+}
+
+// newMarketData returns the market data the LLM's tools read: the simulated
+// prices, or Alpaca's market data read with the first agent's credentials.
+func newMarketData(cfg *config.Agents, prices services.PriceSource) services.MarketData {
+	if simMode {
+		return services.PriceSourceMarketData{Prices: prices}
+	}
+	first := cfg.Agents[0]
+	return services.NewAlpacaMarketData(os.Getenv(first.KeyEnv), os.Getenv(first.SecretEnv))
+}
+
+// loadAgents reads the agents config, falling back to the default agents when there is no file.
+func loadAgents() *config.Agents {
+	cfg, found, err := config.Load(agentsFile)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error parsing symbols")
+		log.Fatal().Err(err).Msg("Error loading agents config")
+	}
+	if !found {
+		log.Info().Str("path", agentsFile).Msg("No agents config, running the default agents")
+	}
+	return cfg
+}
+
+func initializeAgents(cfg *config.Agents, tradeBroker *broker.Broker, clock types.Clock) []types.Agent {
+	// parse symbols
+	if simMode {
+		utils.UseDefaultSymbols()
+	} else {
+		// any configured agent's credentials can list the assets
+		first := cfg.Agents[0]
+		err := services.LoadSymbols(os.Getenv(first.KeyEnv), os.Getenv(first.SecretEnv))
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error parsing symbols")
+		}
 	}
 	log.Info().Int("symbols_count", len(utils.Symbols)).Msg("Parsed symbols")
 
-	rngAgent := agent.NewRNGAgent("RNG_Agent")
-	rngAgent.SetBroker(tradeBroker)
+	// all simulated accounts share one market so their results are comparable
+	// they move once per tick, so every account and order in a tick sees the same prices
+	prices := services.NewRandomWalkPrices(time.Now().UnixNano())
+	prices.Interval = agent.TickPeriod()
+	prices.SetClock(clock.Now)
+	services.Market = newMarketData(cfg, prices)
 
-	llmAgent := agent.NewLLMAgent("LLM_Agent")
-	llmAgent.SetBroker(tradeBroker)
+	agentsToStart := make([]types.Agent, 0, len(cfg.Agents))
+	for _, c := range cfg.Agents {
+		strategy, err := agent.NewStrategy(c.Strategy, c.Params)
+		if err != nil {
+			log.Fatal().Err(err).Str("agent", c.Name).Msg("Error creating strategy")
+		}
+
+		a := agent.NewStrategyAgent(c.Name, strategy, newVenue(c.KeyEnv, c.SecretEnv, prices), clock)
+		if len(c.Symbols) > 0 {
+			a.Symbols = c.Symbols
+		}
+		a.Every = c.Schedule.Every
+		a.Hours = agent.TradingHours{ExtendedHours: c.Schedule.ExtendedHours, SkipBeforeClose: c.Schedule.SkipBeforeClose}
+		a.SetBroker(tradeBroker)
 
-	agentsToStart := []types.Agent{rngAgent, llmAgent}
+		log.Info().Str("agent", c.Name).Str("strategy", c.Strategy).Msg("Agent configured")
+		agentsToStart = append(agentsToStart, a)
+	}
 	return agentsToStart
 }
 
 func main() {
+	switch command {
+	case "backtest":
+		runBacktest(flag.Args()[1:])
+		return
+	case "replay":
+		runReplay(flag.Args()[1:])
+		return
+	}
+
 	log.Info().Msg("Starting program")
 	if debug {
 		log.Debug().Msg("Debug mode enabled")
@@ -116,22 +287,34 @@ func main() {
 	if devMode {
 		log.Info().Msg("Dev mode enabled")
 	}
+	if simMode {
+		log.Info().Msg("Simulated execution enabled")
+	}
+
+	cfg := loadAgents()
+	setAIPrices(cfg)
 
 	// initialize services
 	initializeServices()
 
+	// everything runs on one clock
+	clock := newClock(cfg)
+
 	// Initialize broker
 	tradeBroker := broker.NewBroker()
+	tradeBroker.SetClock(clock)
+	tradeBroker.SetRiskConfig(riskConfig(cfg))
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start the broker's trade processing
-	tradeBroker.ProcessTrades(ctx)
-
 	// initialize agents and pass the broker
-	agents := initializeAgents(tradeBroker)
+	agents := initializeAgents(cfg, tradeBroker, clock)
+
+	// the broker shares the agents' tick to cancel stale orders, so it starts after them
+	agent.StartAgents(agents, clock, tradeBroker)
 
-	agent.StartAgents(agents)
+	// Start the broker's trade processing
+	tradeBroker.ProcessTrades(ctx)
 
 	// stay alive until the program is interrupted
 	done := make(chan os.Signal, 1)
@@ -143,4 +326,8 @@ func main() {
 		agent.Stop(ctx)
 	}
 	log.Warn().Msg("Agents stopped")
+
+	// stop the broker and let it drain its queue
+	cancel()
+	tradeBroker.Wait()
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/dickeyy/cis-320/agent"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/rs/zerolog/log"
+)
+
+// runReplay implements the replay subcommand, which checks an RNG agent's
+// recorded decisions against their seeds:
+//
+//	cis-320 replay --agent RNG_Agent
+//	cis-320 replay --file rng_decisions.jsonl
+func runReplay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	agentName := fs.String("agent", "", "RNG agent whose decisions are read from Redis")
+	file := fs.String("file", "", "JSONL file of RNG decision records to read instead of Redis")
+	fs.Parse(args)
+
+	if (*agentName == "") == (*file == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var lines []string
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error opening decision records")
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Fatal().Err(err).Msg("Error reading decision records")
+		}
+	} else {
+		services.InitializeRedis()
+		var err error
+		lines, err = services.LoadRNGDecisions(*agentName, context.Background())
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error loading decision records")
+		}
+	}
+
+	records := make([]agent.RNGRecord, 0, len(lines))
+	for i, line := range lines {
+		var record agent.RNGRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			log.Fatal().Err(err).Int("record", i).Msg("Invalid decision record")
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		log.Fatal().Msg("No decision records to replay")
+	}
+
+	matched, err := agent.ReplayRNG(records)
+	if err != nil {
+		log.Error().Err(err).Int("matched", matched).Int("records", len(records)).Msg("Replay diverged from the recorded decisions")
+		os.Exit(1)
+	}
+	fmt.Printf("all %d decisions reproduced from their seeds\n", matched)
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/rs/zerolog/log"
+)
+
+// LLM cache modes
+const (
+	AICacheOff    = "off"    // every request goes to the provider
+	AICacheRecord = "record" // every request goes to the provider and its response is stored
+	AICacheReplay = "replay" // responses are served from the store, a request not in it fails
+)
+
+// DefaultAICacheDir is where the LLM cache is stored unless configured otherwise.
+const DefaultAICacheDir = "data/llm-cache"
+
+// AICache records the LLM agents' requests or replays them; nil, the default, is off.
+var AICache *LLMCache
+
+// ErrAICacheMiss is returned in replay mode for a request that was not recorded.
+var ErrAICacheMiss = errors.New("request not recorded")
+
+// LLMCache stores chat completions in Dir, one JSON file per request named
+// after the hash of its content: the model, the messages (the system and user
+// prompts, and any tool calls and results), the sampling parameters, the tools
+// and the response format. The provider is not part of the key, so a run
+// recorded on OpenRouter can be replayed without it.
+type LLMCache struct {
+	Mode string // AICacheRecord or AICacheReplay
+	Dir  string
+}
+
+// NewLLMCache creates a cache in mode over dir, DefaultAICacheDir when empty.
+// The off mode returns nil.
+func NewLLMCache(mode, dir string) (*LLMCache, error) {
+	if dir == "" {
+		dir = DefaultAICacheDir
+	}
+	switch mode {
+	case "", AICacheOff:
+		return nil, nil
+	case AICacheRecord:
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, fmt.Errorf("failed to create LLM cache directory: %w", err)
+		}
+	case AICacheReplay:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("LLM cache to replay: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown LLM cache mode %q, want %s, %s or %s", mode, AICacheOff, AICacheRecord, AICacheReplay)
+	}
+	return &LLMCache{Mode: mode, Dir: dir}, nil
+}
+
+// aiCacheEntry is one recorded request and what the provider answered: its
+// response or, for a request it turned down, the error.
+type aiCacheEntry struct {
+	Key        string                             `json:"key"`
+	Provider   string                             `json:"provider"`
+	RecordedAt time.Time                          `json:"recorded_at"`
+	Request    json.RawMessage                    `json:"request"`
+	Response   *openrouter.ChatCompletionResponse `json:"response,omitempty"`
+	Error      *aiCacheError                      `json:"error,omitempty"`
+}
+
+type aiCacheError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// provider returns provider behind the cache, or provider itself when the cache is off.
+func (c *LLMCache) provider(provider LLMProvider) LLMProvider {
+	if c == nil {
+		return provider
+	}
+	return &cachedProvider{cache: c, inner: provider}
+}
+
+// cacheKey hashes the request. Marshalling sorts map keys, so equal requests
+// have equal keys.
+func cacheKey(request openrouter.ChatCompletionRequest) (string, []byte, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), data, nil
+}
+
+func (c *LLMCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// cachedProvider records or replays the completions of inner.
+type cachedProvider struct {
+	cache *LLMCache
+	inner LLMProvider
+}
+
+// replaying reports whether answers come from the store instead of the provider.
+func (p *cachedProvider) replaying() bool {
+	return p.cache.Mode == AICacheReplay
+}
+
+func (p *cachedProvider) Name() string {
+	return p.inner.Name()
+}
+
+func (p *cachedProvider) CreateChatCompletion(ctx context.Context, request openrouter.ChatCompletionRequest) (openrouter.ChatCompletionResponse, error) {
+	key, data, err := cacheKey(request)
+	if err != nil {
+		return openrouter.ChatCompletionResponse{}, err
+	}
+	if p.replaying() {
+		return p.cache.replay(key, request.Model)
+	}
+
+	res, err := p.inner.CreateChatCompletion(ctx, request)
+	entry := aiCacheEntry{Key: key, Provider: p.inner.Name(), RecordedAt: time.Now(), Request: data}
+	switch {
+	case err == nil:
+		entry.Response = &res
+	case rejectedRequest(err):
+		// a turned down request is part of the run, e.g. the structured outputs fallback
+		entry.Error = &aiCacheError{Status: errorStatus(err), Message: err.Error()}
+	default:
+		// nothing to replay for a request that did not reach the model
+		return res, err
+	}
+	if saveErr := p.cache.save(entry); saveErr != nil {
+		log.Error().Err(saveErr).Str("key", key).Msg("Error recording LLM response")
+	}
+	return res, err
+}
+
+func (c *LLMCache) replay(key, model string) (openrouter.ChatCompletionResponse, error) {
+	data, err := os.ReadFile(c.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Error().Str("key", key).Str("model", model).Str("dir", c.Dir).Msg("LLM request not in the replay cache")
+		return openrouter.ChatCompletionResponse{}, fmt.Errorf("replaying %s request %s: %w", model, key, ErrAICacheMiss)
+	}
+	if err != nil {
+		return openrouter.ChatCompletionResponse{}, fmt.Errorf("failed to read LLM cache: %w", err)
+	}
+	var entry aiCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return openrouter.ChatCompletionResponse{}, fmt.Errorf("invalid LLM cache entry %s: %w", key, err)
+	}
+	switch {
+	case entry.Error != nil:
+		return openrouter.ChatCompletionResponse{}, &openrouter.APIError{HTTPStatusCode: entry.Error.Status, Message: entry.Error.Message}
+	case entry.Response == nil:
+		return openrouter.ChatCompletionResponse{}, fmt.Errorf("LLM cache entry %s has no response", key)
+	}
+	return *entry.Response, nil
+}
+
+func (c *LLMCache) save(entry aiCacheEntry) error {
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return err
+	}
+	// written whole and then renamed, so a replay never reads half an entry
+	tmp := c.path(entry.Key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(entry.Key))
+}
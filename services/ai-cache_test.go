@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dickeyy/cis-320/types"
+	openrouter "github.com/revrost/go-openrouter"
+)
+
+func useAICache(t *testing.T, mode, dir string) {
+	t.Helper()
+	cache, err := NewLLMCache(mode, dir)
+	if err != nil {
+		t.Fatalf("NewLLMCache(%s) error = %v", mode, err)
+	}
+	previous := AICache
+	AICache = cache
+	t.Cleanup(func() { AICache = previous })
+}
+
+func TestLLMCacheRecordReplay(t *testing.T) {
+	dir := t.TempDir()
+	req := AIRequest{Model: "test/cache", Temperature: 0.5, AgentState: &types.AgentState{}}
+	forget := func() {
+		structuredMu.Lock()
+		delete(unstructured, ProviderMock+" test/cache")
+		structuredMu.Unlock()
+	}
+	t.Cleanup(forget)
+
+	// the recorded provider turns down response_format, so the run includes the fallback
+	live := &MockProvider{Respond: func(r openrouter.ChatCompletionRequest) (openrouter.ChatCompletionMessage, error) {
+		if r.ResponseFormat != nil {
+			return openrouter.ChatCompletionMessage{}, &openrouter.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "response_format is not supported"}
+		}
+		return openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: finalDecision}}, nil
+	}, Usage: &openrouter.Usage{PromptTokens: 1000, CompletionTokens: 100, Cost: 0.01}}
+	useAICache(t, AICacheRecord, dir)
+	req.Provider = live
+	recorded, err := GetAITradeDecision(context.Background(), req)
+	if err != nil {
+		t.Fatalf("recording: GetAITradeDecision() error = %v", err)
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(entries) != 2 {
+		t.Errorf("recorded %d entries, want the rejected request and the answer", len(entries))
+	}
+
+	// a fresh process replays the same run without the provider
+	forget()
+	offline := &MockProvider{Respond: func(r openrouter.ChatCompletionRequest) (openrouter.ChatCompletionMessage, error) {
+		return openrouter.ChatCompletionMessage{}, errors.New("the provider was called in replay mode")
+	}}
+	useAICache(t, AICacheReplay, dir)
+	req.Provider = offline
+	replayed, err := GetAITradeDecision(context.Background(), req)
+	if err != nil {
+		t.Fatalf("replaying: GetAITradeDecision() error = %v", err)
+	}
+	if replayed.Content != recorded.Content || replayed.ParsePath != recorded.ParsePath || len(replayed.Decisions.Actions) != 1 {
+		t.Errorf("replayed %q (%s), recorded %q (%s)", replayed.Content, replayed.ParsePath, recorded.Content, recorded.ParsePath)
+	}
+	if n := len(offline.Requests()); n != 0 {
+		t.Errorf("provider was asked %d times in replay mode", n)
+	}
+
+	// the recording was paid for, the replay was not
+	if recorded.Usage.Calls != 1 || !recorded.Usage.Cost.IsPositive() || recorded.Usage.Cached != 0 {
+		t.Errorf("recorded Usage = %+v, want one paid call", recorded.Usage)
+	}
+	if u := replayed.Usage; u.Calls != 0 || u.Cached != 1 || u.PromptTokens != 0 || !u.Cost.IsZero() {
+		t.Errorf("replayed Usage = %+v, want one cached call and nothing spent", u)
+	}
+
+	// any change to the request is a miss
+	req.Temperature = 0.6
+	if _, err := GetAITradeDecision(context.Background(), req); !errors.Is(err, ErrAICacheMiss) {
+		t.Errorf("replaying a changed request: error = %v, want ErrAICacheMiss", err)
+	}
+}
+
+func TestNewLLMCache(t *testing.T) {
+	if cache, err := NewLLMCache(AICacheOff, ""); cache != nil || err != nil {
+		t.Errorf("NewLLMCache(off) = %v, %v, want no cache", cache, err)
+	}
+	if _, err := NewLLMCache(AICacheReplay, filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("NewLLMCache(replay) of a missing directory succeeded")
+	}
+	dir := filepath.Join(t.TempDir(), "new")
+	if _, err := NewLLMCache(AICacheRecord, dir); err != nil {
+		t.Errorf("NewLLMCache(record) error = %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("record mode did not create its directory: %v", err)
+	}
+	if _, err := NewLLMCache("refresh", dir); err == nil {
+		t.Error("NewLLMCache(refresh) succeeded")
+	}
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	openrouter "github.com/revrost/go-openrouter"
+)
+
+// LLM provider types, as named in the agents config
+const (
+	ProviderOpenRouter = "openrouter" // OpenRouter with OPENROUTER_KEY, the default
+	ProviderOpenAI     = "openai"     // any OpenAI-compatible endpoint, e.g. a local llama.cpp or Ollama server
+	ProviderMock       = "mock"       // scripted answers, holding when the script is empty
+)
+
+// LLMProvider sends chat completions to a model. Requests and responses are in
+// the OpenAI chat completions format that OpenRouter and local servers share.
+type LLMProvider interface {
+	// Name identifies the provider in logs and in what is remembered about its models.
+	Name() string
+	CreateChatCompletion(ctx context.Context, request openrouter.ChatCompletionRequest) (openrouter.ChatCompletionResponse, error)
+}
+
+// OpenRouterProvider sends completions to OpenRouter.
+type OpenRouterProvider struct {
+	client *openrouter.Client
+}
+
+// NewOpenRouterProvider creates an OpenRouter provider with the API key.
+func NewOpenRouterProvider(apiKey string) *OpenRouterProvider {
+	return &OpenRouterProvider{client: openrouter.NewClient(apiKey, openrouter.WithXTitle("CIS-320"))}
+}
+
+func (p *OpenRouterProvider) Name() string {
+	return ProviderOpenRouter
+}
+
+// CreateChatCompletion asks OpenRouter to include the cost of the call in its usage.
+func (p *OpenRouterProvider) CreateChatCompletion(ctx context.Context, request openrouter.ChatCompletionRequest) (openrouter.ChatCompletionResponse, error) {
+	request.Usage = &openrouter.IncludeUsage{Include: true}
+	return p.client.CreateChatCompletion(ctx, request)
+}
+
+// OpenAICompatibleProvider sends completions to an OpenAI-compatible endpoint,
+// e.g. http://localhost:11434/v1 for Ollama or http://localhost:8080/v1 for llama.cpp.
+type OpenAICompatibleProvider struct {
+	baseURL string
+	client  *openrouter.Client
+}
+
+// NewOpenAICompatibleProvider creates a provider for the endpoint at baseURL,
+// which the chat completions path is appended to. Local servers usually need no API key.
+func NewOpenAICompatibleProvider(baseURL, apiKey string) *OpenAICompatibleProvider {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+	config := openrouter.DefaultConfig(apiKey)
+	config.BaseURL = baseURL
+	return &OpenAICompatibleProvider{baseURL: baseURL, client: openrouter.NewClientWithConfig(*config)}
+}
+
+func (p *OpenAICompatibleProvider) Name() string {
+	return ProviderOpenAI + ":" + p.baseURL
+}
+
+func (p *OpenAICompatibleProvider) CreateChatCompletion(ctx context.Context, request openrouter.ChatCompletionRequest) (openrouter.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletion(ctx, request)
+}
+
+// mockHold is the mock provider's answer when it has no script.
+const mockHold = `{"reasoning": "mock provider without a script", "actions": []}`
+
+// maxMockRequests is how many of its last requests a mock keeps.
+const maxMockRequests = 100
+
+// MockProvider answers without a model, for tests and dry runs. Respond, when
+// set, answers every request; otherwise Script is answered in order, the last
+// message repeating, and without a script the mock holds. The last
+// maxMockRequests requests are kept.
+type MockProvider struct {
+	Respond func(request openrouter.ChatCompletionRequest) (openrouter.ChatCompletionMessage, error)
+	Script  []openrouter.ChatCompletionMessage
+	Usage   *openrouter.Usage // reported with every answer
+
+	mu       sync.Mutex
+	calls    int
+	requests []openrouter.ChatCompletionRequest
+}
+
+// NewMockProvider creates a mock answering with the script's messages as text.
+func NewMockProvider(script ...string) *MockProvider {
+	m := &MockProvider{}
+	for _, text := range script {
+		m.Script = append(m.Script, openrouter.ChatCompletionMessage{
+			Role:    openrouter.ChatMessageRoleAssistant,
+			Content: openrouter.Content{Text: text},
+		})
+	}
+	return m
+}
+
+func (m *MockProvider) Name() string {
+	return ProviderMock
+}
+
+func (m *MockProvider) CreateChatCompletion(ctx context.Context, request openrouter.ChatCompletionRequest) (openrouter.ChatCompletionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return openrouter.ChatCompletionResponse{}, err
+	}
+	m.mu.Lock()
+	n := m.calls
+	m.calls++
+	m.requests = append(m.requests, request)
+	if over := len(m.requests) - maxMockRequests; over > 0 {
+		m.requests = slices.Delete(m.requests, 0, over)
+	}
+	m.mu.Unlock()
+
+	var message openrouter.ChatCompletionMessage
+	switch {
+	case m.Respond != nil:
+		var err error
+		if message, err = m.Respond(request); err != nil {
+			return openrouter.ChatCompletionResponse{}, err
+		}
+	case len(m.Script) > 0:
+		message = m.Script[min(n, len(m.Script)-1)]
+	default:
+		message = openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: mockHold}}
+	}
+	return openrouter.ChatCompletionResponse{
+		Model:   request.Model,
+		Choices: []openrouter.ChatCompletionChoice{{Message: message, FinishReason: openrouter.FinishReasonStop}},
+		Usage:   m.Usage,
+	}, nil
+}
+
+// Requests returns the last requests the mock was sent, in order.
+func (m *MockProvider) Requests() []openrouter.ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]openrouter.ChatCompletionRequest(nil), m.requests...)
+}
+
+// ProviderConfig selects and sets up an LLM agent's provider.
+type ProviderConfig struct {
+	Type    string // one of the Provider* constants, ProviderOpenRouter when empty
+	BaseURL string // the endpoint of an OpenAI-compatible provider
+	APIKey  string // the key of an OpenAI-compatible provider, if it needs one
+}
+
+// NewLLMProvider creates the configured provider. OpenRouter uses OPENROUTER_KEY.
+func NewLLMProvider(config ProviderConfig) (LLMProvider, error) {
+	switch config.Type {
+	case "", ProviderOpenRouter:
+		return NewOpenRouterProvider(os.Getenv("OPENROUTER_KEY")), nil
+	case ProviderOpenAI:
+		if config.BaseURL == "" {
+			return nil, fmt.Errorf("the %s provider needs a base_url", ProviderOpenAI)
+		}
+		return NewOpenAICompatibleProvider(config.BaseURL, config.APIKey), nil
+	case ProviderMock:
+		return NewMockProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q, want %s, %s or %s", config.Type, ProviderOpenRouter, ProviderOpenAI, ProviderMock)
+	}
+}
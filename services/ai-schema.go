@@ -0,0 +1,136 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/dickeyy/cis-320/types"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/revrost/go-openrouter/jsonschema"
+)
+
+// How a decision was read from the model's answer, from the most to the least reliable.
+const (
+	ParsePathStructured = "structured" // the answer was held to the schema by response_format
+	ParsePathJSON       = "json"       // the whole answer was the JSON object, without response_format
+	ParsePathCodeFence  = "code_fence" // the JSON was in a fenced code block
+	ParsePathBraces     = "braces"     // the JSON was found by matching braces in other text
+)
+
+var (
+	structuredMu sync.Mutex
+	// unstructured are the models, by provider, that rejected response_format
+	unstructured = make(map[string]bool)
+)
+
+// supportsStructured reports whether the model is sent response_format: until
+// one of its requests is rejected for it.
+func supportsStructured(provider LLMProvider, model string) bool {
+	structuredMu.Lock()
+	defer structuredMu.Unlock()
+	return !unstructured[provider.Name()+" "+model]
+}
+
+func markUnstructured(provider LLMProvider, model string) {
+	structuredMu.Lock()
+	defer structuredMu.Unlock()
+	unstructured[provider.Name()+" "+model] = true
+}
+
+// rejectedRequest reports whether err is the API turning the request down, as
+// it does for a response_format the model does not support, rather than a
+// failure to reach it.
+func rejectedRequest(err error) bool {
+	switch errorStatus(err) {
+	case http.StatusBadRequest, http.StatusNotFound, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
+
+// errorStatus returns the HTTP status of an API error, 0 for other errors.
+func errorStatus(err error) int {
+	var apiErr *openrouter.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+	var reqErr *openrouter.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+	return 0
+}
+
+// tradeDecisionsFormat is the strict response_format for types.TradeDecisions.
+func tradeDecisionsFormat() (*openrouter.ChatCompletionResponseFormat, error) {
+	definition, err := jsonschema.GenerateSchemaForType(types.TradeDecisions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate schema: %w", err)
+	}
+	data, err := json.Marshal(definition)
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, err
+	}
+	strictSchema(schema)
+	data, err = json.Marshal(schema)
+	if err != nil {
+		return nil, err
+	}
+
+	return &openrouter.ChatCompletionResponseFormat{
+		Type: openrouter.ChatCompletionResponseFormatTypeJSONSchema,
+		JSONSchema: &openrouter.ChatCompletionResponseFormatJSONSchema{
+			Name:   "trade_decisions",
+			Schema: json.RawMessage(data),
+			Strict: true,
+		},
+	}, nil
+}
+
+// strictSchema fixes up a generated schema for strict mode. The generator
+// makes an object without properties of decimal.Decimal, which is a string in
+// JSON, and marks nullable fields with a keyword strict mode does not know
+// instead of a null type.
+func strictSchema(node map[string]any) {
+	if props, _ := node["properties"].(map[string]any); node["type"] == "object" && len(props) == 0 {
+		node["type"] = "string"
+		delete(node, "properties")
+		delete(node, "required")
+		delete(node, "additionalProperties")
+	}
+	if nullable, _ := node["nullable"].(bool); nullable {
+		node["type"] = []any{node["type"], "null"}
+	}
+	delete(node, "nullable")
+
+	if props, ok := node["properties"].(map[string]any); ok {
+		for _, prop := range props {
+			if p, ok := prop.(map[string]any); ok {
+				strictSchema(p)
+			}
+		}
+	}
+	if items, ok := node["items"].(map[string]any); ok {
+		strictSchema(items)
+	}
+}
+
+// parseTradeDecisions reads the decisions from the model's answer and says how.
+// structured is whether the answer was held to the schema.
+func parseTradeDecisions(text string, structured bool) (*types.TradeDecisions, string, error) {
+	if decisions, err := unmarshalTradeDecisions(strings.TrimSpace(text)); err == nil {
+		if structured {
+			return decisions, ParsePathStructured, nil
+		}
+		return decisions, ParsePathJSON, nil
+	}
+	return parseTradeDecisionsFromText(text)
+}
@@ -0,0 +1,336 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/revrost/go-openrouter/jsonschema"
+	"github.com/shopspring/decimal"
+)
+
+// default limits of the model's tool calls before it decides
+const (
+	DefaultAIToolSteps   = 5
+	DefaultAIToolTimeout = 90 * time.Second
+)
+
+const (
+	maxToolBars          = 200 // bars get_bars returns at most
+	maxToolSymbols       = 25  // symbols search_symbols returns at most
+	defaultToolBars      = 30
+	defaultToolPeriod    = 14
+	defaultToolTimeframe = "1Day"
+)
+
+// AITools are the local functions the model may call before it decides: market
+// data from Data, and the agent's holdings and tradable symbols at the time of
+// the decision. Without Data only get_position and search_symbols are offered.
+type AITools struct {
+	Data     MarketData
+	Holdings []alpaca.Position
+	Symbols  []string
+	MaxSteps int           // model turns that may call tools, DefaultAIToolSteps when zero
+	Timeout  time.Duration // for the turns that may call tools, not the final decision; DefaultAIToolTimeout when zero
+}
+
+// toolArgs are the arguments of every tool; each uses some of them.
+type toolArgs struct {
+	Symbol    string `json:"symbol"`
+	Timeframe string `json:"timeframe"`
+	Limit     int    `json:"limit"`
+	Query     string `json:"query"`
+	Indicator string `json:"indicator"`
+	Period    int    `json:"period"`
+}
+
+type aiTool struct {
+	definition openrouter.FunctionDefinition
+	market     bool // needs market data
+	call       func(t *AITools, args toolArgs) (any, error)
+}
+
+var timeframes = map[string]time.Duration{
+	"1Min":  time.Minute,
+	"5Min":  5 * time.Minute,
+	"15Min": 15 * time.Minute,
+	"1Hour": time.Hour,
+	"1Day":  24 * time.Hour,
+}
+
+var (
+	symbolParam    = jsonschema.Definition{Type: jsonschema.String, Description: "Stock ticker, e.g. AAPL"}
+	timeframeParam = jsonschema.Definition{Type: jsonschema.String, Enum: []string{"1Min", "5Min", "15Min", "1Hour", "1Day"}, Description: "Bar size, 1Day by default"}
+)
+
+var aiTools = []aiTool{
+	{
+		definition: openrouter.FunctionDefinition{
+			Name:        "get_quote",
+			Description: "Latest price of a stock, with the bid and ask when known.",
+			Parameters: jsonschema.Definition{
+				Type:       jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{"symbol": symbolParam},
+				Required:   []string{"symbol"},
+			},
+		},
+		market: true,
+		call: func(t *AITools, args toolArgs) (any, error) {
+			return t.Data.Quote(args.Symbol)
+		},
+	},
+	{
+		definition: openrouter.FunctionDefinition{
+			Name:        "get_bars",
+			Description: "Most recent OHLCV bars of a stock, oldest first.",
+			Parameters: jsonschema.Definition{
+				Type: jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{
+					"symbol":    symbolParam,
+					"timeframe": timeframeParam,
+					"limit":     {Type: jsonschema.Integer, Description: fmt.Sprintf("Number of bars, %d by default and at most %d", defaultToolBars, maxToolBars)},
+				},
+				Required: []string{"symbol"},
+			},
+		},
+		market: true,
+		call: func(t *AITools, args toolArgs) (any, error) {
+			timeframe, err := parseTimeframe(args.Timeframe)
+			if err != nil {
+				return nil, err
+			}
+			limit := args.Limit
+			if limit <= 0 {
+				limit = defaultToolBars
+			}
+			return t.Data.Bars(args.Symbol, timeframe, min(limit, maxToolBars))
+		},
+	},
+	{
+		definition: openrouter.FunctionDefinition{
+			Name:        "get_position",
+			Description: "Your position in a stock: shares, average entry price, market value and unrealized P&L.",
+			Parameters: jsonschema.Definition{
+				Type:       jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{"symbol": symbolParam},
+				Required:   []string{"symbol"},
+			},
+		},
+		call: func(t *AITools, args toolArgs) (any, error) {
+			for _, h := range t.Holdings {
+				if h.Symbol == args.Symbol {
+					return map[string]any{
+						"symbol":          h.Symbol,
+						"quantity":        h.QtyAvailable,
+						"avg_entry_price": h.AvgEntryPrice,
+						"current_price":   h.CurrentPrice,
+						"market_value":    h.MarketValue,
+						"unrealized_pl":   h.UnrealizedPL,
+					}, nil
+				}
+			}
+			return map[string]any{"symbol": args.Symbol, "quantity": "0", "note": "not held"}, nil
+		},
+	},
+	{
+		definition: openrouter.FunctionDefinition{
+			Name:        "search_symbols",
+			Description: fmt.Sprintf("Tradable symbols containing the query, at most %d, best matches first.", maxToolSymbols),
+			Parameters: jsonschema.Definition{
+				Type:       jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{"query": {Type: jsonschema.String, Description: "Part of a ticker, e.g. NV"}},
+				Required:   []string{"query"},
+			},
+		},
+		call: func(t *AITools, args toolArgs) (any, error) {
+			return searchSymbols(t.Symbols, args.Query), nil
+		},
+	},
+	{
+		definition: openrouter.FunctionDefinition{
+			Name:        "get_indicator",
+			Description: "Latest value of a technical indicator over a stock's closes: sma, ema or rsi.",
+			Parameters: jsonschema.Definition{
+				Type: jsonschema.Object,
+				Properties: map[string]jsonschema.Definition{
+					"symbol":    symbolParam,
+					"indicator": {Type: jsonschema.String, Enum: []string{"sma", "ema", "rsi"}},
+					"period":    {Type: jsonschema.Integer, Description: fmt.Sprintf("Number of bars, %d by default", defaultToolPeriod)},
+					"timeframe": timeframeParam,
+				},
+				Required: []string{"symbol", "indicator"},
+			},
+		},
+		market: true,
+		call: func(t *AITools, args toolArgs) (any, error) {
+			timeframe, err := parseTimeframe(args.Timeframe)
+			if err != nil {
+				return nil, err
+			}
+			period := args.Period
+			if period <= 0 {
+				period = defaultToolPeriod
+			}
+			if period*3+1 > maxToolBars {
+				return nil, fmt.Errorf("period must be at most %d", (maxToolBars-1)/3)
+			}
+			bars, err := t.Data.Bars(args.Symbol, timeframe, period*3+1)
+			if err != nil {
+				return nil, err
+			}
+			closes := make([]float64, 0, len(bars))
+			for _, b := range bars {
+				closes = append(closes, b.Close.InexactFloat64())
+			}
+			value, err := indicator(args.Indicator, closes, period)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]any{
+				"symbol":    args.Symbol,
+				"indicator": args.Indicator,
+				"period":    period,
+				"value":     decimal.NewFromFloat(value).Round(4),
+				"as_of":     bars[len(bars)-1].Time,
+			}, nil
+		},
+	},
+}
+
+// definitions returns the tools offered to the model.
+func (t *AITools) definitions() []openrouter.Tool {
+	var tools []openrouter.Tool
+	for i := range aiTools {
+		if aiTools[i].market && t.Data == nil {
+			continue
+		}
+		tools = append(tools, openrouter.Tool{Type: openrouter.ToolTypeFunction, Function: &aiTools[i].definition})
+	}
+	return tools
+}
+
+// call runs the named tool on the model's JSON arguments and records the call.
+// Errors are recorded and returned to the model rather than ending the exchange.
+func (t *AITools) call(name, arguments string) types.AIToolCall {
+	record := types.AIToolCall{Name: name, Arguments: arguments}
+	result, err := t.run(name, arguments)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		record.Error = err.Error()
+		return record
+	}
+	record.Result = string(data)
+	return record
+}
+
+func (t *AITools) run(name, arguments string) (any, error) {
+	for _, tool := range aiTools {
+		if tool.definition.Name != name || (tool.market && t.Data == nil) {
+			continue
+		}
+		var args toolArgs
+		if strings.TrimSpace(arguments) != "" {
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return nil, fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
+		args.Symbol = strings.ToUpper(strings.TrimSpace(args.Symbol))
+		args.Indicator = strings.ToLower(strings.TrimSpace(args.Indicator))
+		return tool.call(t, args)
+	}
+	return nil, fmt.Errorf("unknown tool %q", name)
+}
+
+func parseTimeframe(s string) (time.Duration, error) {
+	if s == "" {
+		s = defaultToolTimeframe
+	}
+	timeframe, ok := timeframes[s]
+	if !ok {
+		return 0, fmt.Errorf("unsupported timeframe %q", s)
+	}
+	return timeframe, nil
+}
+
+// searchSymbols returns the symbols containing query, those starting with it first.
+func searchSymbols(symbols []string, query string) []string {
+	query = strings.ToUpper(strings.TrimSpace(query))
+	var matches []string
+	for _, symbol := range symbols {
+		if strings.Contains(symbol, query) {
+			matches = append(matches, symbol)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		pi, pj := strings.HasPrefix(matches[i], query), strings.HasPrefix(matches[j], query)
+		if pi != pj {
+			return pi
+		}
+		return matches[i] < matches[j]
+	})
+	if len(matches) > maxToolSymbols {
+		matches = matches[:maxToolSymbols]
+	}
+	return matches
+}
+
+// indicator returns the latest value of the named indicator over closes, oldest first.
+func indicator(name string, closes []float64, period int) (float64, error) {
+	need := period
+	if name == "rsi" {
+		need = period + 1
+	}
+	if len(closes) < need {
+		return 0, fmt.Errorf("%d bars are not enough for a %d period %s", len(closes), period, name)
+	}
+
+	switch name {
+	case "sma":
+		return mean(closes[len(closes)-period:]), nil
+	case "ema":
+		k := 2 / float64(period+1)
+		ema := mean(closes[:period])
+		for _, c := range closes[period:] {
+			ema = c*k + ema*(1-k)
+		}
+		return ema, nil
+	case "rsi":
+		// Wilder's smoothing
+		var gain, loss float64
+		for i := 1; i <= period; i++ {
+			change := closes[i] - closes[i-1]
+			gain += math.Max(change, 0)
+			loss += math.Max(-change, 0)
+		}
+		gain, loss = gain/float64(period), loss/float64(period)
+		for i := period + 1; i < len(closes); i++ {
+			change := closes[i] - closes[i-1]
+			gain = (gain*float64(period-1) + math.Max(change, 0)) / float64(period)
+			loss = (loss*float64(period-1) + math.Max(-change, 0)) / float64(period)
+		}
+		if loss == 0 {
+			return 100, nil
+		}
+		return 100 - 100/(1+gain/loss), nil
+	default:
+		return 0, fmt.Errorf("unknown indicator %q, want sma, ema or rsi", name)
+	}
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestIndicator(t *testing.T) {
+	closes := []float64{44, 44.5, 44.25, 43.75, 44.75, 45.5, 45.25, 46, 46.5, 46.25}
+	tests := []struct {
+		name   string
+		period int
+		want   float64
+	}{
+		{"sma", 4, 46},
+		{"ema", 3, 46.13671875},
+		{"rsi", 9, 73.684},
+	}
+	for _, tt := range tests {
+		got, err := indicator(tt.name, closes, tt.period)
+		if err != nil || math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("indicator(%s, %d) = %v, %v; want %v", tt.name, tt.period, got, err, tt.want)
+		}
+	}
+	if _, err := indicator("rsi", closes, 10); err == nil {
+		t.Error("indicator(rsi, 10) on 10 closes succeeded")
+	}
+	if _, err := indicator("macd", closes, 3); err == nil {
+		t.Error("indicator(macd) succeeded")
+	}
+}
+
+func TestSearchSymbols(t *testing.T) {
+	got := searchSymbols([]string{"AMD", "NVDA", "NVO", "ANVS", "AAPL"}, "nv")
+	if want := "NVDA,NVO,ANVS"; strings.Join(got, ",") != want {
+		t.Errorf("searchSymbols(nv) = %v, want %s", got, want)
+	}
+}
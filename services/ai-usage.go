@@ -0,0 +1,134 @@
+package services
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/rs/zerolog/log"
+	"github.com/shopspring/decimal"
+)
+
+// ModelPrice is what a model costs in dollars per million tokens.
+type ModelPrice struct {
+	Prompt     decimal.Decimal
+	Completion decimal.Decimal
+}
+
+var (
+	pricesMu sync.RWMutex
+	// aiPrices are the prices calls are costed at; a model without one is costed
+	// at what the provider reports, which OpenRouter does
+	aiPrices = map[string]ModelPrice{
+		DefaultAIModel: {Prompt: decimal.RequireFromString("0.30"), Completion: decimal.RequireFromString("2.50")},
+	}
+)
+
+// SetAIPrice sets the price of model, replacing the default.
+func SetAIPrice(model string, price ModelPrice) {
+	pricesMu.Lock()
+	defer pricesMu.Unlock()
+	aiPrices[model] = price
+}
+
+func aiPrice(model string) (ModelPrice, bool) {
+	pricesMu.RLock()
+	defer pricesMu.RUnlock()
+	price, ok := aiPrices[model]
+	return price, ok
+}
+
+var million = decimal.NewFromInt(1_000_000)
+
+// callUsage is the usage of one call to model as the provider reported it.
+func callUsage(model string, usage *openrouter.Usage, latency time.Duration) types.AIUsage {
+	call := types.AIUsage{Model: model, Calls: 1, LatencyMS: latency.Milliseconds()}
+	if usage == nil {
+		call.Unpriced = 1
+		return call
+	}
+	call.PromptTokens = usage.PromptTokens
+	call.CompletionTokens = usage.CompletionTokens
+
+	if price, ok := aiPrice(model); ok {
+		call.Cost = price.Prompt.Mul(decimal.NewFromInt(int64(usage.PromptTokens))).
+			Add(price.Completion.Mul(decimal.NewFromInt(int64(usage.CompletionTokens)))).
+			Div(million)
+	} else if usage.Cost > 0 {
+		call.Cost = decimal.NewFromFloat(usage.Cost)
+	} else {
+		call.Unpriced = 1
+	}
+	return call
+}
+
+// callModel sends the request to provider and adds the call's usage to response.
+// A call replayed from the LLM cache only counts as cached, it cost nothing.
+func callModel(ctx context.Context, provider LLMProvider, request openrouter.ChatCompletionRequest, response *AIResponse) (openrouter.ChatCompletionResponse, error) {
+	start := time.Now()
+	res, err := provider.CreateChatCompletion(ctx, request)
+	if err != nil {
+		return res, err
+	}
+	call := types.AIUsage{Model: request.Model, Cached: 1}
+	if cached, ok := provider.(*cachedProvider); !ok || !cached.replaying() {
+		call = callUsage(request.Model, res.Usage, time.Since(start))
+	}
+	response.Calls = append(response.Calls, call)
+	response.Usage = response.Usage.Add(call)
+	return res, nil
+}
+
+// AIUsageRollup is an agent's model usage in this process, in total and per
+// trading day (YYYY-MM-DD in market time).
+type AIUsageRollup struct {
+	Total types.AIUsage            `json:"total"`
+	Daily map[string]types.AIUsage `json:"daily"`
+}
+
+var (
+	usageMu sync.Mutex
+	usage   = make(map[string]*AIUsageRollup)
+)
+
+// RecordAIUsage adds a decision's usage to the agent's rollups for the day it
+// was made on, here and in Redis under ai_usage:<agent> and ai_usage:<agent>:<day>.
+// Redis only keeps what was spent, so calls replayed from the LLM cache are
+// counted here alone.
+func RecordAIUsage(agentName string, day time.Time, decision types.AIUsage, ctx context.Context) error {
+	date := day.In(utils.MarketLocation).Format("2006-01-02")
+
+	usageMu.Lock()
+	rollup, ok := usage[agentName]
+	if !ok {
+		rollup = &AIUsageRollup{Daily: make(map[string]types.AIUsage)}
+		usage[agentName] = rollup
+	}
+	rollup.Total = rollup.Total.Add(decision)
+	rollup.Daily[date] = rollup.Daily[date].Add(decision)
+	total := rollup.Total
+	usageMu.Unlock()
+
+	log.Info().Str("agent", agentName).Int("prompt_tokens", decision.PromptTokens).Int("completion_tokens", decision.CompletionTokens).
+		Int64("latency_ms", decision.LatencyMS).Int("cached", decision.Cached).Str("cost", decision.Cost.StringFixed(6)).Str("total_cost", total.Cost.StringFixed(4)).Msg("AI usage")
+
+	if decision.Calls == 0 {
+		return nil
+	}
+	return saveAIUsage(agentName, date, decision, ctx)
+}
+
+// AIUsageOf returns the agent's model usage in this process, zero if it made no calls.
+func AIUsageOf(agentName string) AIUsageRollup {
+	usageMu.Lock()
+	defer usageMu.Unlock()
+	rollup, ok := usage[agentName]
+	if !ok {
+		return AIUsageRollup{Daily: make(map[string]types.AIUsage)}
+	}
+	return AIUsageRollup{Total: rollup.Total, Daily: maps.Clone(rollup.Daily)}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/shopspring/decimal"
+)
+
+func TestCallUsage(t *testing.T) {
+	tests := []struct {
+		name     string
+		model    string
+		usage    *openrouter.Usage
+		cost     string
+		unpriced int
+	}{
+		// $0.30 and $2.50 per million tokens
+		{"priced", DefaultAIModel, &openrouter.Usage{PromptTokens: 10_000, CompletionTokens: 2_000, Cost: 1}, "0.008", 0},
+		{"reported cost", "test/unpriced", &openrouter.Usage{PromptTokens: 10, CompletionTokens: 5, Cost: 0.0012}, "0.0012", 0},
+		{"no price", "test/unpriced", &openrouter.Usage{PromptTokens: 10, CompletionTokens: 5}, "0", 1},
+		{"no usage", DefaultAIModel, nil, "0", 1},
+	}
+	for _, tt := range tests {
+		got := callUsage(tt.model, tt.usage, 1500*time.Millisecond)
+		if !got.Cost.Equal(decimal.RequireFromString(tt.cost)) || got.Unpriced != tt.unpriced || got.Calls != 1 || got.LatencyMS != 1500 {
+			t.Errorf("%s: callUsage() = %+v, want cost %s and %d unpriced", tt.name, got, tt.cost, tt.unpriced)
+		}
+	}
+}
+
+func TestGetAITradeDecisionUsage(t *testing.T) {
+	SetAIPrice("test/usage", ModelPrice{Prompt: decimal.NewFromInt(1), Completion: decimal.NewFromInt(4)})
+	tools := &AITools{Symbols: []string{"AAPL"}}
+	calls := 0
+	mock := &MockProvider{
+		Respond: func(req openrouter.ChatCompletionRequest) (openrouter.ChatCompletionMessage, error) {
+			calls++
+			if calls == 1 {
+				return toolCallMessage("call-1", "search_symbols", `{"query": "AA"}`), nil
+			}
+			return openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: finalDecision}}, nil
+		},
+		Usage: &openrouter.Usage{PromptTokens: 1000, CompletionTokens: 250},
+	}
+
+	res, err := GetAITradeDecision(context.Background(), AIRequest{Provider: mock, Model: "test/usage", AgentState: &types.AgentState{}, Tools: tools})
+	if err != nil {
+		t.Fatalf("GetAITradeDecision() error = %v", err)
+	}
+	// two calls of $0.001 + $0.001
+	want := types.AIUsage{Model: "test/usage", Calls: 2, PromptTokens: 2000, CompletionTokens: 500, Cost: decimal.RequireFromString("0.004")}
+	got := res.Usage
+	got.LatencyMS = 0
+	if got.Model != want.Model || got.Calls != want.Calls || got.PromptTokens != want.PromptTokens || got.CompletionTokens != want.CompletionTokens || !got.Cost.Equal(want.Cost) {
+		t.Errorf("Usage = %+v, want %+v", got, want)
+	}
+	if len(res.Calls) != 2 {
+		t.Errorf("Calls = %d, want one per model call", len(res.Calls))
+	}
+}
+
+func TestRecordAIUsage(t *testing.T) {
+	day1 := time.Date(2025, 1, 2, 15, 0, 0, 0, time.UTC)
+	// 01:00 UTC on the 4th is still the 3rd in New York
+	day2 := time.Date(2025, 1, 4, 1, 0, 0, 0, time.UTC)
+	call := types.AIUsage{Calls: 1, PromptTokens: 100, CompletionTokens: 10, Cost: decimal.RequireFromString("0.01")}
+	for _, day := range []time.Time{day1, day1, day2} {
+		if err := RecordAIUsage("Usage_Test", day, call, context.Background()); err != nil {
+			t.Fatalf("RecordAIUsage() error = %v", err)
+		}
+	}
+
+	usage := AIUsageOf("Usage_Test")
+	if usage.Total.Calls != 3 || !usage.Total.Cost.Equal(decimal.RequireFromString("0.03")) {
+		t.Errorf("Total = %+v, want 3 calls for $0.03", usage.Total)
+	}
+	if usage.Daily["2025-01-02"].Calls != 2 || usage.Daily["2025-01-03"].Calls != 1 || len(usage.Daily) != 2 {
+		t.Errorf("Daily = %+v, want 2 calls on the 2nd and 1 on the 3rd", usage.Daily)
+	}
+	if other := AIUsageOf("Usage_Other"); other.Total.Calls != 0 {
+		t.Errorf("AIUsageOf(unknown agent) = %+v", other)
+	}
+}
@@ -10,18 +10,20 @@ import (
 	"github.com/dickeyy/cis-320/types"
 	"github.com/dickeyy/cis-320/utils"
 	openrouter "github.com/revrost/go-openrouter"
-	"github.com/revrost/go-openrouter/jsonschema"
 	"github.com/rs/zerolog/log"
 )
 
 var (
-	AI                *openrouter.Client
-	SystemPrompt      string
-	PreviousResponses []string
+	// AI is the OpenRouter provider LLM agents use unless configured with their own
+	AI           LLMProvider
+	SystemPrompt utils.Prompt
 )
 
+// DefaultAIModel is the OpenRouter model LLM agents use unless configured otherwise.
+const DefaultAIModel = "google/gemini-2.5-flash"
+
 func InitializeAI() {
-	AI = openrouter.NewClient(os.Getenv("OPENROUTER_KEY"), openrouter.WithXTitle("CIS-320"))
+	AI = NewOpenRouterProvider(os.Getenv("OPENROUTER_KEY"))
 
 	s, err := utils.GetSystemPrompt()
 	if err != nil {
@@ -29,101 +31,218 @@ func InitializeAI() {
 	}
 	SystemPrompt = s
 
-	log.Info().Msg("System prompt initialized")
+	log.Info().Str("version", s.Version).Msg("System prompt initialized")
+}
+
+// AIRequest is what the model is asked to decide on.
+type AIRequest struct {
+	Provider          LLMProvider // AI when nil
+	Model             string      // DefaultAIModel when empty
+	Temperature       float32     // the provider's default when zero
+	MaxTokens         int         // of the answer, unlimited when zero
+	AgentState        *types.AgentState
+	PreviousResponses []string
+	LastError         error
+	LastActions       []types.ActionResult // what became of the actions of the last decision
+	Symbols           []string             // the symbols the agent may trade
+	Tools             *AITools             // nil offers the model no tools
+}
+
+// AIResponse is the model's decision and how it got there.
+type AIResponse struct {
+	Decisions *types.TradeDecisions
+	Content   string             // the model's final response
+	ToolCalls []types.AIToolCall // the tool calls it made before deciding, in order
+	ParsePath string             // how the decisions were read from Content, one of the ParsePath* constants
+	Usage     types.AIUsage      // of all the calls together
+	Calls     []types.AIUsage    // of each call, in order
+	// the versions of the prompt templates the decision was asked with
+	SystemPromptVersion string
+	UserPromptVersion   string
 }
 
-func GetAITradeDecision(ctx context.Context, agentState *types.AgentState, lastError error) (*types.TradeDecision, error) {
-	userPrompt, err := utils.GetUserPrompt(agentState, PreviousResponses, lastError)
+// GetAITradeDecision asks the model for this tick's actions. When tools are
+// offered the model may call them first: their results are sent back and the
+// model asked again, for up to Tools.MaxSteps turns and within Tools.Timeout.
+// After that it is asked once more, on ctx alone, to decide without them.
+func GetAITradeDecision(ctx context.Context, req AIRequest) (*AIResponse, error) {
+	userPrompt, err := utils.GetUserPrompt(req.AgentState, req.Symbols, req.PreviousResponses, req.LastError, req.LastActions)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user prompt: %w", err)
 	}
 
-	type Result struct {
-		TradeDecision types.TradeDecision `json:"trade_decision"`
+	provider := req.Provider
+	if provider == nil {
+		provider = AI
+	}
+	provider = AICache.provider(provider)
+	model := req.Model
+	if model == "" {
+		model = DefaultAIModel
 	}
-	var result Result
-	_, err = jsonschema.GenerateSchemaForType(result)
+	format, err := tradeDecisionsFormat()
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate schema: %w", err)
+		return nil, err
 	}
-
 	request := openrouter.ChatCompletionRequest{
-		Model: "google/gemini-2.5-flash",
+		Model: model,
 		Messages: []openrouter.ChatCompletionMessage{
 			{
 				Role:    openrouter.ChatMessageRoleSystem,
-				Content: openrouter.Content{Text: SystemPrompt},
+				Content: openrouter.Content{Text: SystemPrompt.Text},
 			},
 			{
 				Role:    openrouter.ChatMessageRoleUser,
-				Content: openrouter.Content{Text: userPrompt},
+				Content: openrouter.Content{Text: userPrompt.Text},
 			},
 		},
-		// Temperature: 0.7,
+		Temperature: req.Temperature,
+		MaxTokens:   req.MaxTokens,
+	}
+	// hold the answer to the schema where the model supports it
+	if supportsStructured(provider, model) {
+		request.ResponseFormat = format
 	}
 
-	res, err := AI.CreateChatCompletion(ctx, request)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create chat completion: %w", err)
+	toolCtx, maxSteps := ctx, 0
+	if req.Tools != nil {
+		request.Tools = req.Tools.definitions()
+		maxSteps = req.Tools.MaxSteps
+		if maxSteps <= 0 {
+			maxSteps = DefaultAIToolSteps
+		}
+		timeout := req.Tools.Timeout
+		if timeout <= 0 {
+			timeout = DefaultAIToolTimeout
+		}
+		var cancel context.CancelFunc
+		toolCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
 	}
 
-	content := res.Choices[0].Message.Content.Text
-	// println(content)
+	response := &AIResponse{SystemPromptVersion: SystemPrompt.Version, UserPromptVersion: userPrompt.Version}
+	for step := 0; ; step++ {
+		// tool turns run within the tool timeout, the decision only within ctx
+		callCtx := ctx
+		if len(request.Tools) > 0 {
+			if step >= maxSteps || toolCtx.Err() != nil {
+				// out of tool turns or time, the next answer has to be the decision
+				request.ToolChoice = "none"
+			} else {
+				callCtx = toolCtx
+			}
+		}
 
-	tradeDecision, err := parseTradeDecisionFromText(content)
+		res, err := callModel(callCtx, provider, request, response)
+		if err != nil && request.ResponseFormat != nil && rejectedRequest(err) {
+			// the model may not support structured outputs, ask again without
+			request.ResponseFormat = nil
+			res, err = callModel(callCtx, provider, request, response)
+			if err == nil {
+				log.Warn().Str("provider", provider.Name()).Str("model", model).Msg("Model does not support structured outputs, parsing its answers instead")
+				markUnstructured(provider, model)
+			}
+		}
+		if err != nil && request.ToolChoice != "none" && toolCtx.Err() != nil && ctx.Err() == nil {
+			// the tool turns ran out of time mid-call, the next turn asks for the decision
+			log.Warn().Str("provider", provider.Name()).Str("model", model).Int("tool_calls", len(response.ToolCalls)).Msg("Tool turns timed out, asking the model to decide without them")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create chat completion: %w", err)
+		}
+		if len(res.Choices) == 0 {
+			return nil, fmt.Errorf("chat completion has no choices")
+		}
+		message := res.Choices[0].Message
+		if len(message.ToolCalls) == 0 || request.ToolChoice == "none" {
+			response.Content = message.Content.Text
+			break
+		}
+
+		request.Messages = append(request.Messages, message)
+		for _, call := range message.ToolCalls {
+			record := req.Tools.call(call.Function.Name, call.Function.Arguments)
+			response.ToolCalls = append(response.ToolCalls, record)
+			log.Debug().Str("tool", record.Name).Str("arguments", record.Arguments).Str("error", record.Error).Msg("AI tool call")
+
+			content := record.Result
+			if record.Error != "" {
+				content = fmt.Sprintf(`{"error": %q}`, record.Error)
+			}
+			request.Messages = append(request.Messages, openrouter.ChatCompletionMessage{
+				Role:       openrouter.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Content:    openrouter.Content{Text: content},
+			})
+		}
+	}
+
+	response.Decisions, response.ParsePath, err = parseTradeDecisions(response.Content, request.ResponseFormat != nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse trade decision: %w", err)
 	}
-
-	PreviousResponses = append(PreviousResponses, content)
+	log.Info().Str("provider", provider.Name()).Str("model", model).Str("parse_path", response.ParsePath).Int("actions", len(response.Decisions.Actions)).Msg("Trade decisions parsed")
 
 	if utils.DevMode {
-		log.Debug().Any("trade_decision", tradeDecision).Msg("Trade decision")
+		log.Debug().Any("trade_decisions", response.Decisions).Int("tool_calls", len(response.ToolCalls)).Msg("Trade decisions")
 	}
 
-	return tradeDecision, nil
+	return response, nil
 }
 
-// parseTradeDecisionFromText extracts a JSON object from the model response text
-// (supports fenced code blocks) and unmarshals it into a TradeDecision.
-func parseTradeDecisionFromText(text string) (*types.TradeDecision, error) {
+// parseTradeDecisionsFromText extracts a JSON object from the model response text
+// (supports fenced code blocks) and unmarshals it into TradeDecisions. It
+// returns the ParsePath* constant of the heuristic that found it.
+func parseTradeDecisionsFromText(text string) (*types.TradeDecisions, string, error) {
 	// Prefer fenced code block if present
 	if block, ok := extractFromCodeFence(text); ok {
-		td, err := unmarshalTradeDecision(strings.TrimSpace(block))
+		td, err := unmarshalTradeDecisions(strings.TrimSpace(block))
 		if err == nil {
-			return td, nil
+			return td, ParsePathCodeFence, nil
 		}
 		// fall through to brace-based extraction as a fallback
 	}
 
 	jsonStr, err := extractFirstJSONObject(text)
 	if err != nil {
-		return nil, fmt.Errorf("no JSON object found in response: %w", err)
+		return nil, "", fmt.Errorf("no JSON object found in response: %w", err)
 	}
-	return unmarshalTradeDecision(strings.TrimSpace(jsonStr))
+	td, err := unmarshalTradeDecisions(strings.TrimSpace(jsonStr))
+	return td, ParsePathBraces, err
 }
 
-func unmarshalTradeDecision(jsonStr string) (*types.TradeDecision, error) {
-	// If top-level object includes a trade_decision key, use its value; otherwise use the object itself
+// unmarshalTradeDecisions accepts the actions list, and a single decision
+// either on its own or under a trade_decision key as a list of one.
+func unmarshalTradeDecisions(jsonStr string) (*types.TradeDecisions, error) {
 	var root map[string]json.RawMessage
-	var payload []byte
-	if err := json.Unmarshal([]byte(jsonStr), &root); err == nil && root != nil {
+	if err := json.Unmarshal([]byte(jsonStr), &root); err != nil {
+		return nil, fmt.Errorf("invalid trade decision JSON: %w", err)
+	}
+
+	var decisions types.TradeDecisions
+	if _, ok := root["actions"]; ok {
+		if err := json.Unmarshal([]byte(jsonStr), &decisions); err != nil {
+			return nil, fmt.Errorf("invalid trade decisions JSON: %w", err)
+		}
+	} else {
+		// If top-level object includes a trade_decision key, use its value; otherwise use the object itself
+		payload := []byte(jsonStr)
 		if v, ok := root["trade_decision"]; ok {
 			payload = v
-		} else {
-			payload = []byte(jsonStr)
 		}
-	} else {
-		payload = []byte(jsonStr)
+		var td types.TradeDecision
+		if err := json.Unmarshal(payload, &td); err != nil {
+			return nil, fmt.Errorf("invalid trade decision JSON: %w", err)
+		}
+		decisions = types.TradeDecisions{Reasoning: td.Reasoning, Actions: []types.TradeDecision{td}}
 	}
 
-	var td types.TradeDecision
-	if err := json.Unmarshal(payload, &td); err != nil {
-		return nil, fmt.Errorf("invalid trade decision JSON: %w", err)
-	}
 	// Normalize action casing
-	td.Action = strings.ToUpper(strings.TrimSpace(td.Action))
-	return &td, nil
+	for i := range decisions.Actions {
+		decisions.Actions[i].Action = strings.ToUpper(strings.TrimSpace(decisions.Actions[i].Action))
+	}
+	return &decisions, nil
 }
 
 // extractFromCodeFence returns the contents of the first triple-backtick code block, if any.
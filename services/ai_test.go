@@ -0,0 +1,434 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	openrouter "github.com/revrost/go-openrouter"
+	"github.com/shopspring/decimal"
+)
+
+// TestMain reads the prompt templates from the repository root.
+func TestMain(m *testing.M) {
+	utils.PromptsDir = filepath.Join("..", "prompts")
+	os.Exit(m.Run())
+}
+
+func TestParseTradeDecisions(t *testing.T) {
+	tests := []struct {
+		name       string
+		text       string
+		structured bool
+		reasoning  string
+		actions    []string // action and symbol of each
+		path       string
+	}{
+		{
+			"actions",
+			`{"reasoning": "rebalance", "actions": [{"action": "sell", "symbol": "AAPL", "quantity": "3"}, {"action": "BUY", "symbol": "NVDA", "amount": "500"}]}`,
+			false,
+			"rebalance",
+			[]string{"SELL AAPL", "BUY NVDA"},
+			ParsePathJSON,
+		},
+		{
+			"structured",
+			`{"reasoning": "hold", "actions": [{"action": "NONE", "symbol": null, "quantity": null, "amount": null}]}`,
+			true,
+			"hold",
+			[]string{"NONE "},
+			ParsePathStructured,
+		},
+		{
+			"fenced actions",
+			"Here you go:\n```json\n{\"reasoning\": \"wait\", \"actions\": []}\n```",
+			false,
+			"wait",
+			nil,
+			ParsePathCodeFence,
+		},
+		{
+			"single decision in text",
+			`My decision: {"action": "BUY", "symbol": "AMD", "amount": "100", "reasoning": "momentum"} good luck`,
+			false,
+			"momentum",
+			[]string{"BUY AMD"},
+			ParsePathBraces,
+		},
+		{
+			"wrapped single decision",
+			`{"trade_decision": {"action": "liquidate", "reasoning": "reset"}}`,
+			false,
+			"reset",
+			[]string{"LIQUIDATE "},
+			ParsePathJSON,
+		},
+	}
+	for _, tt := range tests {
+		got, path, err := parseTradeDecisions(tt.text, tt.structured)
+		if err != nil {
+			t.Errorf("%s: parseTradeDecisions() error = %v", tt.name, err)
+			continue
+		}
+		var actions []string
+		for _, d := range got.Actions {
+			actions = append(actions, d.Action+" "+d.Symbol)
+		}
+		if got.Reasoning != tt.reasoning || strings.Join(actions, ",") != strings.Join(tt.actions, ",") {
+			t.Errorf("%s: got %q %v, want %q %v", tt.name, got.Reasoning, actions, tt.reasoning, tt.actions)
+		}
+		if path != tt.path {
+			t.Errorf("%s: parse path = %s, want %s", tt.name, path, tt.path)
+		}
+	}
+
+	if _, _, err := parseTradeDecisions(`{"actions": {"action": "BUY"}}`, true); err == nil {
+		t.Error("parseTradeDecisions() accepted actions that are not a list")
+	}
+}
+
+func TestTradeDecisionsFormat(t *testing.T) {
+	format, err := tradeDecisionsFormat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		JSONSchema struct {
+			Strict bool `json:"strict"`
+			Schema struct {
+				Properties struct {
+					Actions struct {
+						Items struct {
+							Properties           map[string]map[string]any `json:"properties"`
+							Required             []string                  `json:"required"`
+							AdditionalProperties bool                      `json:"additionalProperties"`
+						} `json:"items"`
+					} `json:"actions"`
+				} `json:"properties"`
+			} `json:"schema"`
+		} `json:"json_schema"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	action := schema.JSONSchema.Schema.Properties.Actions.Items
+	if !schema.JSONSchema.Strict || action.AdditionalProperties || len(action.Required) != len(action.Properties) {
+		t.Errorf("action schema is not strict: %s", data)
+	}
+	if got := fmt.Sprint(action.Properties["amount"]["type"]); got != "[string null]" {
+		t.Errorf("amount type = %s, want a nullable decimal string", got)
+	}
+	if _, ok := action.Properties["action"]["enum"]; !ok {
+		t.Errorf("action has no enum: %v", action.Properties["action"])
+	}
+}
+
+// decodeChatRequest reads a chat completion request. Its response_format
+// schema is an interface, so it is read apart as raw JSON.
+func decodeChatRequest(body io.Reader) (openrouter.ChatCompletionRequest, error) {
+	var fields map[string]json.RawMessage
+	var req openrouter.ChatCompletionRequest
+	if err := json.NewDecoder(body).Decode(&fields); err != nil {
+		return req, err
+	}
+	format, ok := fields["response_format"]
+	delete(fields, "response_format")
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return req, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return req, err
+	}
+	if ok {
+		var f struct {
+			Type       openrouter.ChatCompletionResponseFormatType `json:"type"`
+			JSONSchema *struct {
+				Name   string          `json:"name"`
+				Schema json.RawMessage `json:"schema"`
+				Strict bool            `json:"strict"`
+			} `json:"json_schema"`
+		}
+		if err := json.Unmarshal(format, &f); err != nil {
+			return req, err
+		}
+		req.ResponseFormat = &openrouter.ChatCompletionResponseFormat{Type: f.Type}
+		if f.JSONSchema != nil {
+			req.ResponseFormat.JSONSchema = &openrouter.ChatCompletionResponseFormatJSONSchema{Name: f.JSONSchema.Name, Schema: f.JSONSchema.Schema, Strict: f.JSONSchema.Strict}
+		}
+	}
+	return req, nil
+}
+
+// fakeModel serves chat completions from respond, keeping every request.
+type fakeModel struct {
+	mu       sync.Mutex
+	requests []openrouter.ChatCompletionRequest
+	respond  func(req openrouter.ChatCompletionRequest) openrouter.ChatCompletionMessage
+	// rejectFormat turns down requests with a response_format, like a model without structured outputs
+	rejectFormat bool
+}
+
+// useFakeModel points AI at a fake OpenRouter for the test.
+func useFakeModel(t *testing.T, respond func(req openrouter.ChatCompletionRequest) openrouter.ChatCompletionMessage) *fakeModel {
+	t.Helper()
+	m := &fakeModel{respond: respond}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req, err := decodeChatRequest(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		m.requests = append(m.requests, req)
+		m.mu.Unlock()
+		if m.rejectFormat && req.ResponseFormat != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"message": "response_format is not supported", "code": 400}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(openrouter.ChatCompletionResponse{
+			Choices: []openrouter.ChatCompletionChoice{{Message: m.respond(req)}},
+		})
+	}))
+	previous := AI
+	AI = NewOpenAICompatibleProvider(srv.URL, "key")
+	t.Cleanup(func() {
+		AI = previous
+		srv.Close()
+	})
+	return m
+}
+
+func toolCallMessage(id, name, arguments string) openrouter.ChatCompletionMessage {
+	return openrouter.ChatCompletionMessage{
+		Role: openrouter.ChatMessageRoleAssistant,
+		ToolCalls: []openrouter.ToolCall{{
+			ID:       id,
+			Type:     openrouter.ToolTypeFunction,
+			Function: openrouter.FunctionCall{Name: name, Arguments: arguments},
+		}},
+	}
+}
+
+const finalDecision = `{"reasoning": "cheap", "actions": [{"action": "BUY", "symbol": "AAPL", "amount": "100"}]}`
+
+func TestGetAITradeDecisionCallsTools(t *testing.T) {
+	model := useFakeModel(t, func(req openrouter.ChatCompletionRequest) openrouter.ChatCompletionMessage {
+		if len(req.Messages) == 2 {
+			return toolCallMessage("call-1", "get_quote", `{"symbol": "aapl"}`)
+		}
+		return openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: finalDecision}}
+	})
+
+	tools := &AITools{Data: PriceSourceMarketData{Prices: StaticPrices{"AAPL": decimal.NewFromFloat(187.5)}}}
+	res, err := GetAITradeDecision(context.Background(), AIRequest{AgentState: &types.AgentState{}, Tools: tools})
+	if err != nil {
+		t.Fatalf("GetAITradeDecision() error = %v", err)
+	}
+	if len(res.Decisions.Actions) != 1 || res.Decisions.Actions[0].Symbol != "AAPL" {
+		t.Errorf("Decisions = %+v, want the AAPL buy", res.Decisions)
+	}
+	if len(res.ToolCalls) != 1 || res.ToolCalls[0].Name != "get_quote" || !strings.Contains(res.ToolCalls[0].Result, "187.5") {
+		t.Errorf("ToolCalls = %+v, want the AAPL quote", res.ToolCalls)
+	}
+
+	// the quote went back to the model as the answer to its call
+	if len(model.requests) != 2 {
+		t.Fatalf("model was asked %d times, want 2", len(model.requests))
+	}
+	answer := model.requests[1].Messages[3]
+	if answer.Role != openrouter.ChatMessageRoleTool || answer.ToolCallID != "call-1" || !strings.Contains(answer.Content.Text, "187.5") {
+		t.Errorf("tool answer = %+v", answer)
+	}
+}
+
+func TestGetAITradeDecisionToolStepLimit(t *testing.T) {
+	model := useFakeModel(t, func(req openrouter.ChatCompletionRequest) openrouter.ChatCompletionMessage {
+		if req.ToolChoice == "none" {
+			return openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: finalDecision}}
+		}
+		return toolCallMessage(fmt.Sprintf("call-%d", len(req.Messages)), "get_bars", `{"symbol": "AAPL"}`)
+	})
+
+	// without market data the model is only offered get_position and search_symbols
+	tools := &AITools{MaxSteps: 2, Symbols: []string{"AAPL"}}
+	res, err := GetAITradeDecision(context.Background(), AIRequest{AgentState: &types.AgentState{}, Tools: tools})
+	if err != nil {
+		t.Fatalf("GetAITradeDecision() error = %v", err)
+	}
+	if len(model.requests) != 3 {
+		t.Errorf("model was asked %d times, want 2 tool turns and the decision", len(model.requests))
+	}
+	if len(model.requests[0].Tools) != 2 {
+		t.Errorf("offered %d tools without market data, want 2", len(model.requests[0].Tools))
+	}
+	if len(res.ToolCalls) != 2 || !strings.Contains(res.ToolCalls[0].Error, "unknown tool") {
+		t.Errorf("ToolCalls = %+v, want two failed get_bars calls", res.ToolCalls)
+	}
+}
+
+// stalledTools is a provider whose tool turns never answer within their context.
+type stalledTools struct {
+	requests []openrouter.ChatCompletionRequest
+}
+
+func (p *stalledTools) Name() string { return "stalled" }
+
+func (p *stalledTools) CreateChatCompletion(ctx context.Context, req openrouter.ChatCompletionRequest) (openrouter.ChatCompletionResponse, error) {
+	p.requests = append(p.requests, req)
+	if req.ToolChoice != "none" {
+		<-ctx.Done()
+		return openrouter.ChatCompletionResponse{}, ctx.Err()
+	}
+	return openrouter.ChatCompletionResponse{Choices: []openrouter.ChatCompletionChoice{{
+		Message: openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: finalDecision}},
+	}}}, nil
+}
+
+func TestGetAITradeDecisionToolTimeout(t *testing.T) {
+	provider := &stalledTools{}
+	tools := &AITools{Timeout: 10 * time.Millisecond, Symbols: []string{"AAPL"}}
+	res, err := GetAITradeDecision(context.Background(), AIRequest{Provider: provider, AgentState: &types.AgentState{}, Tools: tools})
+	if err != nil {
+		t.Fatalf("GetAITradeDecision() error = %v, want the decision made without tools", err)
+	}
+	if len(res.Decisions.Actions) != 1 {
+		t.Errorf("Decisions = %+v, want the final buy", res.Decisions)
+	}
+	if len(provider.requests) != 2 || provider.requests[1].ToolChoice != "none" {
+		t.Errorf("model was asked %d times, want the timed out tool turn and the decision", len(provider.requests))
+	}
+}
+
+func TestGetAITradeDecisionFallsBackWithoutStructuredOutputs(t *testing.T) {
+	model := useFakeModel(t, func(req openrouter.ChatCompletionRequest) openrouter.ChatCompletionMessage {
+		return openrouter.ChatCompletionMessage{Role: openrouter.ChatMessageRoleAssistant, Content: openrouter.Content{Text: "```json\n" + finalDecision + "\n```"}}
+	})
+	model.rejectFormat = true
+	t.Cleanup(func() {
+		structuredMu.Lock()
+		for key := range unstructured {
+			if strings.HasSuffix(key, " test/no-schema") {
+				delete(unstructured, key)
+			}
+		}
+		structuredMu.Unlock()
+	})
+
+	for i := 0; i < 2; i++ {
+		res, err := GetAITradeDecision(context.Background(), AIRequest{Model: "test/no-schema", AgentState: &types.AgentState{}})
+		if err != nil {
+			t.Fatalf("GetAITradeDecision() error = %v", err)
+		}
+		if res.ParsePath != ParsePathCodeFence || len(res.Decisions.Actions) != 1 {
+			t.Errorf("ParsePath = %s with %d actions, want the code fence heuristic to find the buy", res.ParsePath, len(res.Decisions.Actions))
+		}
+	}
+	// the model is only tried with response_format once
+	if len(model.requests) != 3 || model.requests[2].ResponseFormat != nil {
+		t.Errorf("model was asked %d times, want once with and twice without response_format", len(model.requests))
+	}
+}
+
+func TestGetAITradeDecisionMockProvider(t *testing.T) {
+	mock := NewMockProvider(
+		`{"reasoning": "buy", "actions": [{"action": "BUY", "symbol": "AAPL", "amount": "100"}]}`,
+		"I will hold this time: {\"reasoning\": \"hold\", \"actions\": []}",
+	)
+	req := AIRequest{Provider: mock, Model: "local-model", Temperature: 0.2, MaxTokens: 512, AgentState: &types.AgentState{}, Symbols: []string{"AAPL", "MSFT"}}
+
+	var got []string
+	for i := 0; i < 3; i++ {
+		res, err := GetAITradeDecision(context.Background(), req)
+		if err != nil {
+			t.Fatalf("GetAITradeDecision() error = %v", err)
+		}
+		got = append(got, fmt.Sprintf("%s %d", res.Decisions.Reasoning, len(res.Decisions.Actions)))
+		if res.UserPromptVersion == "" {
+			t.Error("response has no user prompt version")
+		}
+	}
+	// the last scripted answer repeats
+	if want := "buy 1,hold 0,hold 0"; strings.Join(got, ",") != want {
+		t.Errorf("decisions = %v, want %s", got, want)
+	}
+
+	requests := mock.Requests()
+	if len(requests) != 3 {
+		t.Fatalf("mock was asked %d times, want 3", len(requests))
+	}
+	if r := requests[0]; r.Model != "local-model" || r.Temperature != 0.2 || r.MaxTokens != 512 || r.ResponseFormat == nil {
+		t.Errorf("request model %s, temperature %v, max tokens %d, response format %v", r.Model, r.Temperature, r.MaxTokens, r.ResponseFormat)
+	}
+	if prompt := requests[0].Messages[1].Content.Text; !strings.Contains(prompt, "Tradable symbols: AAPL, MSFT") {
+		t.Errorf("user prompt does not list the tradable symbols:\n%s", prompt)
+	}
+
+	res, err := GetAITradeDecision(context.Background(), AIRequest{Provider: NewMockProvider(), AgentState: &types.AgentState{}})
+	if err != nil || len(res.Decisions.Actions) != 0 {
+		t.Errorf("unscripted mock = %v, %v, want a hold", res, err)
+	}
+}
+
+func TestNewLLMProvider(t *testing.T) {
+	tests := []struct {
+		config  ProviderConfig
+		name    string // of the provider, empty on error
+		wantErr bool
+	}{
+		{ProviderConfig{}, "openrouter", false},
+		{ProviderConfig{Type: ProviderOpenRouter}, "openrouter", false},
+		{ProviderConfig{Type: ProviderOpenAI, BaseURL: "http://localhost:11434/v1/"}, "openai:http://localhost:11434/v1", false},
+		{ProviderConfig{Type: ProviderOpenAI}, "", true},
+		{ProviderConfig{Type: ProviderMock}, "mock", false},
+		{ProviderConfig{Type: "anthropic"}, "", true},
+	}
+	for _, tt := range tests {
+		provider, err := NewLLMProvider(tt.config)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("NewLLMProvider(%+v) error = %v, wantErr %v", tt.config, err, tt.wantErr)
+			continue
+		}
+		var name string
+		if provider != nil {
+			name = provider.Name()
+		}
+		if name != tt.name {
+			t.Errorf("NewLLMProvider(%+v) = %q, want %q", tt.config, name, tt.name)
+		}
+	}
+}
+
+func TestMockProviderKeepsLastRequests(t *testing.T) {
+	mock := NewMockProvider("first", "second")
+	for i := range maxMockRequests + 10 {
+		if _, err := mock.CreateChatCompletion(context.Background(), openrouter.ChatCompletionRequest{Model: fmt.Sprint(i)}); err != nil {
+			t.Fatalf("CreateChatCompletion() error = %v", err)
+		}
+	}
+	requests := mock.Requests()
+	if len(requests) != maxMockRequests || requests[0].Model != "10" {
+		t.Errorf("kept %d requests from %s, want the last %d", len(requests), requests[0].Model, maxMockRequests)
+	}
+	res, err := mock.CreateChatCompletion(context.Background(), openrouter.ChatCompletionRequest{})
+	if err != nil || res.Choices[0].Message.Content.Text != "second" {
+		t.Errorf("answer after the script = %v, %v, want its last message repeated", res, err)
+	}
+}
@@ -2,92 +2,175 @@ package services
 
 import (
 	"fmt"
-	"os"
+	"strings"
 
 	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
 	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
 )
 
-func InitializeAlpaca(apiKey, apiSecret string) (*a.Client, *a.Account, []a.Position, error) {
-	baseURL := os.Getenv("ALPACA_API")
+// AlpacaVenue is an ExecutionVenue backed by an Alpaca trading account. Every
+// request waits on the rate limiter shared by all clients of the account's API key.
+type AlpacaVenue struct {
+	client  *a.Client
+	limiter *RateLimiter
+}
+
+// NewAlpacaVenue wraps an existing Alpaca client as an ExecutionVenue. A nil
+// limiter disables rate limiting.
+func NewAlpacaVenue(client *a.Client, limiter *RateLimiter) *AlpacaVenue {
+	return &AlpacaVenue{client: client, limiter: limiter}
+}
 
-	if baseURL == "" {
-		baseURL = "https://paper-api.alpaca.markets"
+// wait blocks until the venue may make another request within its budget.
+func (v *AlpacaVenue) wait() error {
+	if v.limiter == nil {
+		return nil
 	}
+	return v.limiter.Wait()
+}
+
+func InitializeAlpaca(apiKey, apiSecret string) (*AlpacaVenue, *a.Account, []a.Position, error) {
 	if apiKey == "" || apiSecret == "" {
 		return nil, nil, nil, fmt.Errorf("ALPACA_KEY and ALPACA_SECRET must be set for live mode")
 	}
 
-	client := a.NewClient(a.ClientOpts{
+	venue := NewAlpacaVenue(a.NewClient(a.ClientOpts{
 		APIKey:    apiKey,
 		APISecret: apiSecret,
-		BaseURL:   baseURL,
-	})
+		BaseURL:   utils.AlpacaBaseURL(),
+	}), RateLimiterFor(apiKey))
 
-	account, err := GetAccount(client)
+	account, err := GetAccount(venue)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	holdings, err := GetHoldings(client)
+	holdings, err := GetHoldings(venue)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	return client, account, holdings, nil
+	return venue, account, holdings, nil
 }
 
-func PlaceOrder(trade *types.Trade, client *a.Client) (*types.Trade, error) {
-	switch trade.Action {
-	case "BUY":
-		order, err := client.PlaceOrder(a.PlaceOrderRequest{
-			Side:          a.Side("buy"),
-			Type:          a.OrderType("market"),
-			Notional:      trade.Amount,
-			Symbol:        trade.Symbol,
-			TimeInForce:   a.TimeInForce("day"),
-			ClientOrderID: trade.ID,
-		})
-		if err != nil {
-			return nil, err
-		}
+// LoadSymbols sets utils.Symbols to the tradable US equities, listed with the
+// given credentials within their key's rate limit; any account's will do.
+func LoadSymbols(apiKey, apiSecret string) error {
+	venue := NewAlpacaVenue(a.NewClient(a.ClientOpts{
+		APIKey:    apiKey,
+		APISecret: apiSecret,
+		BaseURL:   utils.AlpacaBaseURL(),
+	}), RateLimiterFor(apiKey))
+
+	assets, err := venue.GetAssets(a.GetAssetsRequest{
+		Status:     "active",
+		AssetClass: "us_equity",
+	})
+	if err != nil {
+		return err
+	}
+	return utils.ParseSymbols(assets)
+}
+
+func GetHoldings(venue types.ExecutionVenue) ([]a.Position, error) {
+	holdings, err := venue.GetPositions()
+	if err != nil {
+		return nil, err
+	}
+
+	return holdings, nil
+}
+
+func GetAccount(venue types.ExecutionVenue) (*a.Account, error) {
+	account, err := venue.GetAccount()
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+// PlaceOrder maps the trade onto an Alpaca order request and submits it.
+func (v *AlpacaVenue) PlaceOrder(trade *types.Trade) (*a.Order, error) {
+	req, err := orderRequest(trade)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.wait(); err != nil {
+		return nil, err
+	}
+	return v.client.PlaceOrder(req)
+}
 
-		// Persist the Alpaca order id onto the trade for downstream usage
-		trade.AlpacaID = order.ID
-	case "SELL":
-		order, err := client.PlaceOrder(a.PlaceOrderRequest{
-			Side:          a.Side("sell"),
-			Type:          a.OrderType("market"),
-			Qty:           trade.Quantity,
-			Symbol:        trade.Symbol,
-			TimeInForce:   a.TimeInForce("day"),
-			ClientOrderID: trade.ID,
-		})
+// CancelOrder cancels an open Alpaca order.
+func (v *AlpacaVenue) CancelOrder(orderID string) error {
+	if err := v.wait(); err != nil {
+		return err
+	}
+	return v.client.CancelOrder(orderID)
+}
+
+// ReplaceOrder replaces an open Alpaca order. Alpaca resets an omitted time in
+// force, so the order's current one is kept unless the changes set another.
+func (v *AlpacaVenue) ReplaceOrder(orderID string, changes types.TradeChanges) (*a.Order, error) {
+	tif := a.TimeInForce(strings.ToLower(changes.TimeInForce))
+	if tif == "" {
+		current, err := v.GetOrder(orderID)
 		if err != nil {
 			return nil, err
 		}
+		tif = current.TimeInForce
+	}
 
-		// Persist the Alpaca order id onto the trade for downstream usage
-		trade.AlpacaID = order.ID
+	if err := v.wait(); err != nil {
+		return nil, err
 	}
+	return v.client.ReplaceOrder(orderID, a.ReplaceOrderRequest{
+		Qty:         changes.Quantity,
+		LimitPrice:  changes.LimitPrice,
+		StopPrice:   changes.StopPrice,
+		Trail:       changes.TrailPercent,
+		TimeInForce: tif,
+	})
+}
 
-	return trade, nil
+// GetOrder fetches an Alpaca order by id.
+func (v *AlpacaVenue) GetOrder(orderID string) (*a.Order, error) {
+	if err := v.wait(); err != nil {
+		return nil, err
+	}
+	return v.client.GetOrder(orderID)
 }
 
-func GetHoldings(client *a.Client) ([]a.Position, error) {
-	holdings, err := client.GetPositions()
-	if err != nil {
+// GetOrderByClientOrderID fetches an Alpaca order by its client order id.
+func (v *AlpacaVenue) GetOrderByClientOrderID(clientOrderID string) (*a.Order, error) {
+	if err := v.wait(); err != nil {
 		return nil, err
 	}
+	return v.client.GetOrderByClientOrderID(clientOrderID)
+}
 
-	return holdings, nil
+// GetAccount fetches the Alpaca account.
+func (v *AlpacaVenue) GetAccount() (*a.Account, error) {
+	if err := v.wait(); err != nil {
+		return nil, err
+	}
+	return v.client.GetAccount()
 }
 
-func GetAccount(client *a.Client) (*a.Account, error) {
-	account, err := client.GetAccount()
-	if err != nil {
+// GetPositions fetches all open Alpaca positions.
+func (v *AlpacaVenue) GetPositions() ([]a.Position, error) {
+	if err := v.wait(); err != nil {
 		return nil, err
 	}
+	return v.client.GetPositions()
+}
 
-	return account, nil
+// GetAssets lists the Alpaca assets matching the request.
+func (v *AlpacaVenue) GetAssets(req a.GetAssetsRequest) ([]a.Asset, error) {
+	if err := v.wait(); err != nil {
+		return nil, err
+	}
+	return v.client.GetAssets(req)
 }
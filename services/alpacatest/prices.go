@@ -0,0 +1,52 @@
+package alpacatest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// Prices is a PriceSource tests can move while the server is running.
+type Prices struct {
+	mu     sync.Mutex
+	prices map[string]decimal.Decimal
+}
+
+// NewPrices creates an empty price table.
+func NewPrices() *Prices {
+	return &Prices{prices: make(map[string]decimal.Decimal)}
+}
+
+// Set sets the price of symbol.
+func (p *Prices) Set(symbol string, price decimal.Decimal) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prices[symbol] = price
+}
+
+// GetPrice returns the current price of symbol.
+func (p *Prices) GetPrice(symbol string) (decimal.Decimal, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	price, ok := p.prices[symbol]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("no price for symbol %s", symbol)
+	}
+	return price, nil
+}
+
+// Symbols returns every priced symbol in alphabetical order.
+func (p *Prices) Symbols() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	symbols := make([]string, 0, len(p.prices))
+	for symbol := range p.prices {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+	return symbols
+}
@@ -0,0 +1,527 @@
+// Package alpacatest provides a fake Alpaca trading API for tests. It serves
+// the account, positions, orders, assets and clock endpoints the services use,
+// fills orders on a SimulatedVenue, and lets tests script its state and
+// inject failures.
+package alpacatest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+// Server is a fake Alpaca API on a local httptest server. Orders are filled by a
+// SimulatedVenue at the prices set with SetPrice; the account and positions
+// follow from those fills unless a test overrides them.
+type Server struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	prices    *Prices
+	venue     *services.SimulatedVenue
+	orderIDs  []string          // venue order ids in placement order
+	statuses  map[string]string // order id -> status forced by SetOrderStatus
+	account   *a.Account
+	positions []a.Position
+	assets    []a.Asset
+	clock     *a.Clock
+	calendar  []a.CalendarDay
+	failures  []*Failure
+	requests  []Request
+}
+
+// Request is a call the server received.
+type Request struct {
+	Method string
+	Path   string
+	Query  string
+	APIKey string
+}
+
+// Failure makes the next Times requests matching Method and Path fail. An empty
+// Method matches any method and Path matches by prefix, e.g. "/v2/orders". A
+// zero Status drops the connection instead of answering, like a network error;
+// Go's HTTP client silently retries idempotent requests such as GET once when
+// that happens, so those need Times: 2 for the caller to see it.
+type Failure struct {
+	Method  string
+	Path    string
+	Status  int
+	Code    int
+	Message string
+	Times   int
+}
+
+// NewServer starts a fake Alpaca API for an account funded with cash. The
+// market is open and every symbol given a price is an active, fractionable asset.
+// Close the server when done.
+func NewServer(cash decimal.Decimal) *Server {
+	prices := NewPrices()
+	s := &Server{
+		prices:   prices,
+		venue:    services.NewSimulatedVenue(cash, prices),
+		statuses: make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an Alpaca client pointed at the server.
+func (s *Server) Client() *a.Client {
+	return a.NewClient(a.ClientOpts{
+		APIKey:     "test-key",
+		APISecret:  "test-secret",
+		BaseURL:    s.URL,
+		RetryLimit: -1, // surface 429s to the caller instead of sleeping on them
+	})
+}
+
+// Venue returns an AlpacaVenue talking to the server without rate limiting.
+func (s *Server) Venue() *services.AlpacaVenue {
+	return services.NewAlpacaVenue(s.Client(), nil)
+}
+
+// UseAsBaseURL points every Alpaca client the services create at the server
+// until the returned function is called.
+func (s *Server) UseAsBaseURL() (restore func()) {
+	utils.SetAlpacaBaseURL(s.URL)
+	return func() { utils.SetAlpacaBaseURL("") }
+}
+
+// SimulatedVenue returns the venue the server fills orders on, e.g. to call
+// Match after moving prices or to read its fills.
+func (s *Server) SimulatedVenue() *services.SimulatedVenue {
+	return s.venue
+}
+
+// SetPrice sets the price orders in symbol fill at.
+func (s *Server) SetPrice(symbol string, price decimal.Decimal) {
+	s.prices.Set(symbol, price)
+}
+
+// SetAccount makes the account endpoint return account instead of the venue's
+// account. Nil goes back to the venue.
+func (s *Server) SetAccount(account *a.Account) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.account = account
+}
+
+// SetPositions makes the positions endpoint return positions instead of the
+// venue's positions. Nil goes back to the venue.
+func (s *Server) SetPositions(positions []a.Position) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.positions = positions
+}
+
+// SetAssets replaces the asset list, which defaults to every priced symbol.
+func (s *Server) SetAssets(assets []a.Asset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.assets = assets
+}
+
+// SetClock replaces the market clock, which defaults to an open market.
+func (s *Server) SetClock(clock a.Clock) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clock = &clock
+}
+
+// SetCalendar replaces the market calendar, which defaults to a 9:30-16:00
+// session on every weekday of the requested range.
+func (s *Server) SetCalendar(days []a.CalendarDay) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calendar = days
+}
+
+// SetOrderStatus forces the status reported for an order, e.g. to have the
+// venue reject or expire an order that would otherwise fill.
+func (s *Server) SetOrderStatus(orderID, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.statuses[orderID] = status
+}
+
+// Fail queues a failure; failures are matched in the order they were added.
+func (s *Server) Fail(f Failure) {
+	if f.Times <= 0 {
+		f.Times = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, &f)
+}
+
+// Requests returns every request the server received, oldest first.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	requests := make([]Request, len(s.requests))
+	copy(requests, s.requests)
+	return requests
+}
+
+// Count returns how many requests matched method and path, with the same
+// matching rules as Failure.
+func (s *Server) Count(method, path string) int {
+	n := 0
+	for _, r := range s.Requests() {
+		if matches(method, path, r.Method, r.Path) {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Query: r.URL.RawQuery, APIKey: r.Header.Get("APCA-API-KEY-ID")})
+	failure := s.takeFailureLocked(r.Method, r.URL.Path)
+	s.mu.Unlock()
+
+	if failure != nil {
+		if failure.Status == 0 {
+			dropConnection(w)
+			return
+		}
+		writeError(w, failure.Status, failure.Code, failure.Message)
+		return
+	}
+	if r.Header.Get("APCA-API-KEY-ID") == "" {
+		writeError(w, http.StatusUnauthorized, 40110000, "request is not authorized")
+		return
+	}
+
+	path := r.URL.Path
+	switch {
+	case path == "/v2/account" && r.Method == http.MethodGet:
+		s.getAccount(w)
+	case path == "/v2/positions" && r.Method == http.MethodGet:
+		s.getPositions(w)
+	case path == "/v2/assets" && r.Method == http.MethodGet:
+		s.getAssets(w, r)
+	case path == "/v2/clock" && r.Method == http.MethodGet:
+		s.getClock(w)
+	case path == "/v2/calendar" && r.Method == http.MethodGet:
+		s.getCalendar(w, r)
+	case path == "/v2/orders" && r.Method == http.MethodPost:
+		s.placeOrder(w, r)
+	case path == "/v2/orders" && r.Method == http.MethodGet:
+		s.listOrders(w, r)
+	case path == "/v2/orders:by_client_order_id" && r.Method == http.MethodGet:
+		order, err := s.venue.GetOrderByClientOrderID(r.URL.Query().Get("client_order_id"))
+		s.respondOrder(w, order, err)
+	case strings.HasPrefix(path, "/v2/orders/"):
+		id := strings.TrimPrefix(path, "/v2/orders/")
+		switch r.Method {
+		case http.MethodGet:
+			order, err := s.venue.GetOrder(id)
+			s.respondOrder(w, order, err)
+		case http.MethodPatch:
+			s.replaceOrder(w, r, id)
+		case http.MethodDelete:
+			if err := s.venue.CancelOrder(id); err != nil {
+				writeVenueError(w, err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, 40510000, "method not allowed")
+		}
+	default:
+		writeError(w, http.StatusNotFound, 40410000, "endpoint not found")
+	}
+}
+
+// takeFailureLocked returns the first queued failure matching the request and uses it up.
+func (s *Server) takeFailureLocked(method, path string) *Failure {
+	for i, f := range s.failures {
+		if !matches(f.Method, f.Path, method, path) {
+			continue
+		}
+		f.Times--
+		if f.Times <= 0 {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+		}
+		return f
+	}
+	return nil
+}
+
+func matches(wantMethod, wantPath, method, path string) bool {
+	return (wantMethod == "" || strings.EqualFold(wantMethod, method)) && strings.HasPrefix(path, wantPath)
+}
+
+func (s *Server) getAccount(w http.ResponseWriter) {
+	s.mu.Lock()
+	account := s.account
+	s.mu.Unlock()
+
+	if account == nil {
+		var err error
+		if account, err = s.venue.GetAccount(); err != nil {
+			writeVenueError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, account)
+}
+
+func (s *Server) getPositions(w http.ResponseWriter) {
+	s.mu.Lock()
+	positions := s.positions
+	s.mu.Unlock()
+
+	if positions == nil {
+		var err error
+		if positions, err = s.venue.GetPositions(); err != nil {
+			writeVenueError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, positions)
+}
+
+func (s *Server) getAssets(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	assets := s.assets
+	s.mu.Unlock()
+
+	if assets == nil {
+		for _, symbol := range s.prices.Symbols() {
+			assets = append(assets, a.Asset{
+				ID:           "asset-" + symbol,
+				Class:        a.USEquity,
+				Exchange:     "NASDAQ",
+				Symbol:       symbol,
+				Name:         symbol,
+				Status:       a.AssetActive,
+				Tradable:     true,
+				Fractionable: true,
+			})
+		}
+	}
+
+	status, class := r.URL.Query().Get("status"), r.URL.Query().Get("asset_class")
+	filtered := make([]a.Asset, 0, len(assets))
+	for _, asset := range assets {
+		if (status == "" || string(asset.Status) == status) && (class == "" || string(asset.Class) == class) {
+			filtered = append(filtered, asset)
+		}
+	}
+	writeJSON(w, http.StatusOK, filtered)
+}
+
+func (s *Server) getClock(w http.ResponseWriter) {
+	s.mu.Lock()
+	clock := s.clock
+	s.mu.Unlock()
+
+	if clock == nil {
+		now := time.Now()
+		clock = &a.Clock{Timestamp: now, IsOpen: true, NextClose: now.Add(time.Hour), NextOpen: now.Add(24 * time.Hour)}
+	}
+	writeJSON(w, http.StatusOK, clock)
+}
+
+func (s *Server) getCalendar(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	days := s.calendar
+	s.mu.Unlock()
+
+	start, end := r.URL.Query().Get("start"), r.URL.Query().Get("end")
+	if days == nil {
+		from, err := time.Parse("2006-01-02", start)
+		if err != nil {
+			from = time.Now()
+		}
+		to, err := time.Parse("2006-01-02", end)
+		if err != nil {
+			to = from.AddDate(0, 0, 30)
+		}
+		for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+			if day.Weekday() != time.Saturday && day.Weekday() != time.Sunday {
+				days = append(days, a.CalendarDay{Date: day.Format("2006-01-02"), Open: "09:30", Close: "16:00"})
+			}
+		}
+	}
+
+	filtered := make([]a.CalendarDay, 0, len(days))
+	for _, day := range days {
+		if (start == "" || day.Date >= start) && (end == "" || day.Date <= end) {
+			filtered = append(filtered, day)
+		}
+	}
+	writeJSON(w, http.StatusOK, filtered)
+}
+
+func (s *Server) placeOrder(w http.ResponseWriter, r *http.Request) {
+	var req a.PlaceOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, 40010001, "invalid order request: "+err.Error())
+		return
+	}
+
+	order, err := s.venue.PlaceOrder(tradeFromRequest(req))
+	if err != nil {
+		writeVenueError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.orderIDs = append(s.orderIDs, order.ID)
+	s.mu.Unlock()
+	s.respondOrder(w, order, nil)
+}
+
+func (s *Server) replaceOrder(w http.ResponseWriter, r *http.Request, id string) {
+	var req a.ReplaceOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, 40010001, "invalid replace request: "+err.Error())
+		return
+	}
+
+	order, err := s.venue.ReplaceOrder(id, types.TradeChanges{
+		Quantity:     req.Qty,
+		LimitPrice:   req.LimitPrice,
+		StopPrice:    req.StopPrice,
+		TrailPercent: req.Trail,
+		TimeInForce:  string(req.TimeInForce),
+	})
+	if err != nil {
+		writeVenueError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	s.orderIDs = append(s.orderIDs, order.ID)
+	s.mu.Unlock()
+	s.respondOrder(w, order, nil)
+}
+
+// listOrders serves the open (default), closed or all orders, newest first.
+func (s *Server) listOrders(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = "open"
+	}
+
+	s.mu.Lock()
+	ids := make([]string, len(s.orderIDs))
+	copy(ids, s.orderIDs)
+	s.mu.Unlock()
+
+	orders := make([]a.Order, 0, len(ids))
+	for i := len(ids) - 1; i >= 0; i-- {
+		order, err := s.venue.GetOrder(ids[i])
+		if err != nil {
+			writeVenueError(w, err)
+			return
+		}
+		s.applyStatus(order)
+
+		open := isOpen(order.Status)
+		if status == "all" || (status == "open") == open {
+			orders = append(orders, *order)
+		}
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// respondOrder writes the order with any forced status applied, or the venue error.
+func (s *Server) respondOrder(w http.ResponseWriter, order *a.Order, err error) {
+	if err != nil {
+		writeVenueError(w, err)
+		return
+	}
+	s.applyStatus(order)
+	writeJSON(w, http.StatusOK, order)
+}
+
+func (s *Server) applyStatus(order *a.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if status, ok := s.statuses[order.ID]; ok {
+		order.Status = status
+	}
+}
+
+func isOpen(status string) bool {
+	switch status {
+	case types.OrderStatusNew, types.OrderStatusAccepted, types.OrderStatusPendingNew,
+		types.OrderStatusPartiallyFilled, types.OrderStatusHeld,
+		types.OrderStatusPendingCancel, types.OrderStatusPendingReplace:
+		return true
+	}
+	return false
+}
+
+// tradeFromRequest maps an Alpaca order request back onto the trade the venue places.
+func tradeFromRequest(req a.PlaceOrderRequest) *types.Trade {
+	trade := &types.Trade{
+		ID:            req.ClientOrderID,
+		Symbol:        req.Symbol,
+		Action:        strings.ToUpper(string(req.Side)),
+		Quantity:      req.Qty,
+		Amount:        req.Notional,
+		OrderType:     string(req.Type),
+		TimeInForce:   string(req.TimeInForce),
+		LimitPrice:    req.LimitPrice,
+		StopPrice:     req.StopPrice,
+		TrailPercent:  req.TrailPercent,
+		ExtendedHours: req.ExtendedHours,
+	}
+	if req.TakeProfit != nil {
+		trade.TakeProfit = req.TakeProfit.LimitPrice
+	}
+	if req.StopLoss != nil {
+		trade.StopLoss = req.StopLoss.StopPrice
+	}
+	return trade
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers in Alpaca's error format.
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]any{"code": code, "message": message})
+}
+
+// writeVenueError passes an Alpaca-style error from the venue through unchanged.
+func writeVenueError(w http.ResponseWriter, err error) {
+	if apiErr, ok := err.(*a.APIError); ok {
+		writeError(w, apiErr.StatusCode, apiErr.Code, apiErr.Message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, 50010000, err.Error())
+}
+
+// dropConnection closes the connection without a response.
+func dropConnection(w http.ResponseWriter) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		panic("alpacatest: response writer cannot be hijacked")
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		panic(err)
+	}
+	conn.Close()
+}
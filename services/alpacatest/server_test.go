@@ -0,0 +1,154 @@
+package alpacatest
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+func decimalPtr(v string) *decimal.Decimal {
+	d := decimal.RequireFromString(v)
+	return &d
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s := NewServer(decimal.NewFromInt(1000))
+	t.Cleanup(s.Close)
+	s.SetPrice("AAPL", decimal.NewFromInt(200))
+	s.SetPrice("NVDA", decimal.NewFromInt(100))
+	return s
+}
+
+func TestServerOrdersThroughAlpacaVenue(t *testing.T) {
+	s := newTestServer(t)
+	venue := s.Venue()
+
+	order, err := venue.PlaceOrder(&types.Trade{ID: "buy-1", Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("500")})
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if order.Status != types.OrderStatusFilled || !order.FilledQty.Equal(decimal.RequireFromString("2.5")) {
+		t.Errorf("order = %s %s, want filled 2.5", order.Status, order.FilledQty)
+	}
+
+	byClientID, err := venue.GetOrderByClientOrderID("buy-1")
+	if err != nil || byClientID.ID != order.ID {
+		t.Errorf("GetOrderByClientOrderID() = %v, %v, want order %s", byClientID, err, order.ID)
+	}
+
+	account, err := venue.GetAccount()
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if !account.Cash.Equal(decimal.NewFromInt(500)) {
+		t.Errorf("Cash = %s, want 500", account.Cash)
+	}
+	positions, err := venue.GetPositions()
+	if err != nil || len(positions) != 1 || positions[0].Symbol != "AAPL" {
+		t.Fatalf("GetPositions() = %+v, %v, want one AAPL position", positions, err)
+	}
+
+	// a resting limit order can be replaced, canceled and listed
+	limit, err := venue.PlaceOrder(&types.Trade{ID: "buy-2", Symbol: "NVDA", Action: "BUY", Quantity: decimalPtr("1"), OrderType: types.OrderTypeLimit, LimitPrice: decimalPtr("90")})
+	if err != nil || limit.Status != types.OrderStatusNew {
+		t.Fatalf("PlaceOrder(limit) = %v, %v, want a new order", limit, err)
+	}
+	replaced, err := venue.ReplaceOrder(limit.ID, types.TradeChanges{LimitPrice: decimalPtr("95")})
+	if err != nil || !replaced.LimitPrice.Equal(decimal.NewFromInt(95)) {
+		t.Fatalf("ReplaceOrder() = %v, %v, want limit 95", replaced, err)
+	}
+	open, err := s.Client().GetOrders(a.GetOrdersRequest{Status: "open"})
+	if err != nil || len(open) != 1 || open[0].ID != replaced.ID {
+		t.Errorf("GetOrders(open) = %v, %v, want only %s", open, err, replaced.ID)
+	}
+	if err := venue.CancelOrder(replaced.ID); err != nil {
+		t.Fatalf("CancelOrder() error = %v", err)
+	}
+	if got, _ := venue.GetOrder(replaced.ID); got.Status != types.OrderStatusCanceled {
+		t.Errorf("status after cancel = %s, want canceled", got.Status)
+	}
+
+	// forced statuses override what the venue did
+	s.SetOrderStatus(order.ID, types.OrderStatusRejected)
+	if got, _ := venue.GetOrder(order.ID); got.Status != types.OrderStatusRejected {
+		t.Errorf("forced status = %s, want rejected", got.Status)
+	}
+}
+
+func TestServerFailureInjection(t *testing.T) {
+	s := newTestServer(t)
+	venue := s.Venue()
+
+	s.Fail(Failure{Method: http.MethodPost, Path: "/v2/orders", Status: http.StatusServiceUnavailable, Message: "down for maintenance", Times: 2})
+	s.Fail(Failure{Path: "/v2/account", Status: http.StatusForbidden, Code: 40310000, Message: "forbidden"})
+	s.Fail(Failure{Method: http.MethodDelete, Path: "/v2/orders/"})
+
+	trade := &types.Trade{ID: "buy-1", Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("10")}
+	for i := 0; i < 2; i++ {
+		if _, err := venue.PlaceOrder(trade); services.ClassifyError(err) != services.ErrorRetryable {
+			t.Errorf("PlaceOrder() #%d error = %v, want a retryable error", i+1, err)
+		}
+	}
+	if _, err := venue.PlaceOrder(trade); err != nil {
+		t.Errorf("PlaceOrder() after the failures error = %v", err)
+	}
+	if _, err := venue.GetAccount(); services.ClassifyError(err) != services.ErrorPermanent {
+		t.Errorf("GetAccount() error = %v, want a permanent error", err)
+	}
+	if err := venue.CancelOrder("any"); services.ClassifyError(err) != services.ErrorRetryable {
+		t.Errorf("CancelOrder() error = %v, want a retryable connection error", err)
+	}
+	if n := s.Count(http.MethodPost, "/v2/orders"); n != 3 {
+		t.Errorf("Count(POST /v2/orders) = %d, want 3", n)
+	}
+}
+
+func TestServerAsBaseURL(t *testing.T) {
+	s := newTestServer(t)
+	restore := s.UseAsBaseURL()
+	defer restore()
+
+	s.SetAssets([]a.Asset{
+		{Symbol: "AAPL", Class: a.USEquity, Status: a.AssetActive, Tradable: true, Fractionable: true},
+		{Symbol: "BRK.A", Class: a.USEquity, Status: a.AssetActive, Tradable: true},
+	})
+	if err := services.LoadSymbols("key", "secret"); err != nil {
+		t.Fatalf("LoadSymbols() error = %v", err)
+	}
+	if len(utils.Symbols) != 1 || utils.Symbols[0] != "AAPL" {
+		t.Errorf("Symbols = %v, want [AAPL]", utils.Symbols)
+	}
+
+	// tomorrow is the only session, and it closes early
+	tomorrow := time.Now().In(utils.MarketLocation).AddDate(0, 0, 1)
+	s.SetCalendar([]a.CalendarDay{{Date: tomorrow.Format("2006-01-02"), Open: "09:30", Close: "13:00"}})
+	clock := services.NewAlpacaClock(services.NewCalendar("key", "secret", ""))
+	if clock.IsOpen() {
+		t.Error("IsOpen() = true, want the fake calendar's closed market")
+	}
+	wantClose := time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 13, 0, 0, 0, utils.MarketLocation)
+	if got := clock.NextClose(); !got.Equal(wantClose) {
+		t.Errorf("NextClose() = %s, want %s", got, wantClose)
+	}
+	if n := s.Count(http.MethodGet, "/v2/calendar"); n != 1 {
+		t.Errorf("Count(GET /v2/calendar) = %d, want 1 (the calendar is cached)", n)
+	}
+
+	venue, _, _, err := services.InitializeAlpaca("key", "secret")
+	if err != nil {
+		t.Fatalf("InitializeAlpaca() error = %v", err)
+	}
+	if _, err := venue.GetAccount(); err != nil {
+		t.Errorf("GetAccount() error = %v", err)
+	}
+	if n := s.Count(http.MethodGet, "/v2/account"); n != 2 {
+		t.Errorf("Count(GET /v2/account) = %d, want 2", n)
+	}
+}
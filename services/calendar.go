@@ -0,0 +1,259 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/rs/zerolog/log"
+)
+
+// Extended session hours, as offsets from midnight New York time. On early-close
+// days the after-hours session ends at earlyPostClose instead.
+const (
+	preMarketOpen   = 4 * time.Hour
+	regularOpen     = 9*time.Hour + 30*time.Minute
+	regularClose    = 16 * time.Hour
+	postMarketClose = 20 * time.Hour
+	earlyPostClose  = 17 * time.Hour
+)
+
+// calendarDays is how far ahead the Alpaca calendar is loaded, which is also
+// how long the fallback file lasts offline.
+const calendarDays = 30
+
+// CalendarDay is one trading day's sessions. Weekends and holidays have none.
+type CalendarDay struct {
+	Date      string    `json:"date"`       // trading date, e.g. "2025-11-28"
+	PreOpen   time.Time `json:"pre_open"`   // start of the pre-market session
+	Open      time.Time `json:"open"`       // start of the regular session
+	Close     time.Time `json:"close"`      // end of the regular session
+	PostClose time.Time `json:"post_close"` // end of the after-hours session
+}
+
+// EarlyClose reports whether the regular session ends before 16:00.
+func (d CalendarDay) EarlyClose() bool {
+	return d.Close.In(utils.MarketLocation).Hour() < 16
+}
+
+// newCalendarDay builds the sessions of the day with the given regular open and
+// close. Offsets are wall clock time, so they hold on daylight saving days too.
+func newCalendarDay(day time.Time, open, close time.Duration) CalendarDay {
+	post := postMarketClose
+	if close < regularClose {
+		post = earlyPostClose
+	}
+	day = day.In(utils.MarketLocation)
+	at := func(offset time.Duration) time.Time {
+		return time.Date(day.Year(), day.Month(), day.Day(), 0, int(offset/time.Minute), 0, 0, utils.MarketLocation)
+	}
+	return CalendarDay{
+		Date:      day.Format("2006-01-02"),
+		PreOpen:   at(preMarketOpen),
+		Open:      at(open),
+		Close:     at(close),
+		PostClose: at(post),
+	}
+}
+
+// regularSessions returns the regular 9:30-16:00 New York sessions of every
+// weekday from the day of from through the day of to. Holidays are not known.
+func regularSessions(from, to time.Time) []CalendarDay {
+	loc := utils.MarketLocation
+	day := time.Date(from.In(loc).Year(), from.In(loc).Month(), from.In(loc).Day(), 0, 0, 0, 0, loc)
+	var days []CalendarDay
+	for ; !day.After(to); day = day.AddDate(0, 0, 1) {
+		if day.Weekday() == time.Saturday || day.Weekday() == time.Sunday {
+			continue
+		}
+		days = append(days, newCalendarDay(day, regularOpen, regularClose))
+	}
+	return days
+}
+
+// marketState returns the session phase at now among the ordered days, and the
+// next regular open and close after now. Zero times mean the days end too soon.
+func marketState(days []CalendarDay, now time.Time) (phase string, nextOpen, nextClose time.Time) {
+	phase = types.PhaseClosed
+	for _, d := range days {
+		switch {
+		case !now.Before(d.Open) && now.Before(d.Close):
+			phase = types.PhaseRegular
+		case !now.Before(d.PreOpen) && now.Before(d.Open):
+			phase = types.PhasePreMarket
+		case !now.Before(d.Close) && now.Before(d.PostClose):
+			phase = types.PhaseAfterHours
+		}
+		if nextOpen.IsZero() && d.Open.After(now) {
+			nextOpen = d.Open
+		}
+		if nextClose.IsZero() && d.Close.After(now) {
+			nextClose = d.Close
+		}
+	}
+	return phase, nextOpen, nextClose
+}
+
+// Calendar is the market calendar from Alpaca, so it knows holidays and early
+// closes. It is loaded once per trading date and saved to a file; when Alpaca
+// cannot be reached the saved calendar is used, or failing that the regular
+// weekday sessions, until the next attempt a minute later.
+type Calendar struct {
+	mu      sync.Mutex
+	client  *a.Client
+	limiter *RateLimiter
+	path    string // fallback file, empty for none
+	days    []CalendarDay
+	loaded  string    // market date the calendar was loaded from Alpaca on
+	retryAt time.Time // when to try Alpaca again after a failed load
+}
+
+// NewCalendar creates a calendar read with the given credentials from the
+// configured Alpaca base URL, within the key's rate limit, and saved to path.
+func NewCalendar(apiKey, apiSecret, path string) *Calendar {
+	return &Calendar{
+		client: a.NewClient(a.ClientOpts{
+			APIKey:    apiKey,
+			APISecret: apiSecret,
+			BaseURL:   utils.AlpacaBaseURL(),
+		}),
+		limiter: RateLimiterFor(apiKey),
+		path:    path,
+	}
+}
+
+// Days returns the trading days from today through calendarDays ahead,
+// refreshing them first when they were not loaded today.
+func (c *Calendar) Days() []CalendarDay {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	date := utils.MarketDate(now)
+	if c.loaded == date || now.Before(c.retryAt) {
+		return c.days
+	}
+
+	days, err := c.fetch(now)
+	if err == nil {
+		c.days, c.loaded = days, date
+		log.Debug().Int("days", len(days)).Msg("Market calendar refreshed")
+		if err := c.save(); err != nil {
+			log.Warn().Err(err).Str("path", c.path).Msg("Error saving market calendar")
+		}
+		return c.days
+	}
+
+	c.retryAt = now.Add(time.Minute)
+	if c.days != nil && c.covers(c.days, now) {
+		log.Error().Err(err).Msg("Error refreshing market calendar, keeping the loaded one")
+		return c.days
+	}
+	if saved, loadErr := c.load(); loadErr == nil && c.covers(saved, now) {
+		log.Error().Err(err).Str("path", c.path).Msg("Error loading market calendar, using the saved one")
+		c.days = saved
+		return c.days
+	}
+	log.Error().Err(err).Msg("Error loading market calendar, assuming regular hours")
+	c.days = regularSessions(now, now.AddDate(0, 0, calendarDays))
+	return c.days
+}
+
+// Day returns the sessions of t's trading date, or false on weekends, holidays
+// and dates outside the loaded range.
+func (c *Calendar) Day(t time.Time) (CalendarDay, bool) {
+	date := utils.MarketDate(t)
+	for _, d := range c.Days() {
+		if d.Date == date {
+			return d, true
+		}
+	}
+	return CalendarDay{}, false
+}
+
+// IsHoliday reports whether t falls on a weekday the market is closed.
+func (c *Calendar) IsHoliday(t time.Time) bool {
+	weekday := t.In(utils.MarketLocation).Weekday()
+	if weekday == time.Saturday || weekday == time.Sunday {
+		return false
+	}
+	_, ok := c.Day(t)
+	return !ok
+}
+
+// covers reports whether the days reach past now, so a stale calendar is not
+// mistaken for a run of holidays.
+func (c *Calendar) covers(days []CalendarDay, now time.Time) bool {
+	return len(days) > 0 && days[len(days)-1].PostClose.After(now)
+}
+
+// fetch reads the trading days from today through calendarDays ahead from Alpaca.
+func (c *Calendar) fetch(now time.Time) ([]CalendarDay, error) {
+	if c.limiter != nil {
+		if err := c.limiter.Wait(); err != nil {
+			return nil, err
+		}
+	}
+	today := now.In(utils.MarketLocation)
+	res, err := c.client.GetCalendar(a.GetCalendarRequest{Start: today, End: today.AddDate(0, 0, calendarDays)})
+	if err != nil {
+		return nil, err
+	}
+
+	days := make([]CalendarDay, 0, len(res))
+	for _, day := range res {
+		date, err := time.ParseInLocation("2006-01-02", day.Date, utils.MarketLocation)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar day %+v: %w", day, err)
+		}
+		open, err := time.Parse("15:04", day.Open)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar day %+v: %w", day, err)
+		}
+		closeAt, err := time.Parse("15:04", day.Close)
+		if err != nil {
+			return nil, fmt.Errorf("invalid calendar day %+v: %w", day, err)
+		}
+		// the times parse on January 1 of year 0, so they are offsets from midnight
+		midnight := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+		days = append(days, newCalendarDay(date, open.Sub(midnight), closeAt.Sub(midnight)))
+	}
+	return days, nil
+}
+
+// save writes the days to the fallback file.
+func (c *Calendar) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(c.days, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0o644)
+}
+
+// load reads the days from the fallback file.
+func (c *Calendar) load() ([]CalendarDay, error) {
+	if c.path == "" {
+		return nil, fmt.Errorf("no calendar file")
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil, err
+	}
+	var days []CalendarDay
+	if err := json.Unmarshal(data, &days); err != nil {
+		return nil, fmt.Errorf("invalid calendar file %s: %w", c.path, err)
+	}
+	return days, nil
+}
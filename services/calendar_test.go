@@ -0,0 +1,49 @@
+package services_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/services"
+	"github.com/dickeyy/cis-320/services/alpacatest"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+func TestCalendarHolidaysAndFallbackFile(t *testing.T) {
+	s := alpacatest.NewServer(decimal.NewFromInt(1000))
+	restore := s.UseAsBaseURL()
+	defer restore()
+
+	// a holiday today, then an early close tomorrow
+	now := time.Now().In(utils.MarketLocation)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 12, 0, 0, 0, utils.MarketLocation)
+	tomorrow := today.AddDate(0, 0, 1)
+	s.SetCalendar([]a.CalendarDay{{Date: tomorrow.Format("2006-01-02"), Open: "09:30", Close: "13:00"}})
+
+	path := filepath.Join(t.TempDir(), "calendar.json")
+	calendar := services.NewCalendar("key", "secret", path)
+	if _, ok := calendar.Day(today); ok {
+		t.Error("Day(today) found a session on the holiday")
+	}
+	day, ok := calendar.Day(tomorrow)
+	if !ok || !day.EarlyClose() {
+		t.Fatalf("Day(tomorrow) = %+v, %v; want an early close", day, ok)
+	}
+	if weekday := today.Weekday(); weekday != time.Saturday && weekday != time.Sunday && !calendar.IsHoliday(today) {
+		t.Error("IsHoliday(today) = false, want true")
+	}
+	if n := s.Count(http.MethodGet, "/v2/calendar"); n != 1 {
+		t.Errorf("Count(GET /v2/calendar) = %d, want 1 (loaded once a day)", n)
+	}
+
+	// offline, a new calendar falls back to the saved file
+	s.Close()
+	offline := services.NewCalendar("key", "secret", path)
+	if day, ok := offline.Day(tomorrow); !ok || !day.Close.Equal(time.Date(tomorrow.Year(), tomorrow.Month(), tomorrow.Day(), 13, 0, 0, 0, utils.MarketLocation)) {
+		t.Errorf("offline Day(tomorrow) = %+v, %v; want the saved 13:00 close", day, ok)
+	}
+}
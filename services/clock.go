@@ -0,0 +1,216 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+)
+
+// SystemClock is the wall clock with the regular weekday sessions as market
+// hours. It needs no network; use AlpacaClock to also know holidays and early closes.
+type SystemClock struct {
+	AlwaysOpen bool // treat the market as always open, e.g. in dev mode
+}
+
+// NewSystemClock returns the wall clock with regular market hours.
+func NewSystemClock() *SystemClock {
+	return &SystemClock{}
+}
+
+func (c *SystemClock) Now() time.Time                         { return time.Now() }
+func (c *SystemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// IsOpen reports whether the regular session is in progress.
+func (c *SystemClock) IsOpen() bool {
+	return c.Phase() == types.PhaseRegular
+}
+
+// Phase returns the session phase of the regular weekday sessions.
+func (c *SystemClock) Phase() string {
+	if c.AlwaysOpen {
+		return types.PhaseRegular
+	}
+	phase, _, _ := c.state()
+	return phase
+}
+
+// NextOpen returns the start of the next regular session.
+func (c *SystemClock) NextOpen() time.Time {
+	_, next, _ := c.state()
+	return next
+}
+
+// NextClose returns the end of the current or next regular session.
+func (c *SystemClock) NextClose() time.Time {
+	_, _, next := c.state()
+	return next
+}
+
+func (c *SystemClock) state() (string, time.Time, time.Time) {
+	now := time.Now()
+	return marketState(regularSessions(now, now.AddDate(0, 0, 7)), now)
+}
+
+// AlpacaClock is the wall clock with market hours from a Calendar, so it knows
+// holidays, early closes and the extended sessions.
+type AlpacaClock struct {
+	calendar *Calendar
+}
+
+// NewAlpacaClock creates a clock following the calendar.
+func NewAlpacaClock(calendar *Calendar) *AlpacaClock {
+	return &AlpacaClock{calendar: calendar}
+}
+
+func (c *AlpacaClock) Now() time.Time                         { return time.Now() }
+func (c *AlpacaClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// IsOpen reports whether a regular calendar session is in progress.
+func (c *AlpacaClock) IsOpen() bool {
+	return c.Phase() == types.PhaseRegular
+}
+
+// Phase returns the calendar session phase.
+func (c *AlpacaClock) Phase() string {
+	phase, _, _ := c.state()
+	return phase
+}
+
+// NextOpen returns the start of the next regular calendar session.
+func (c *AlpacaClock) NextOpen() time.Time {
+	_, next, _ := c.state()
+	return next
+}
+
+// NextClose returns the end of the current or next regular calendar session.
+func (c *AlpacaClock) NextClose() time.Time {
+	_, _, next := c.state()
+	return next
+}
+
+func (c *AlpacaClock) state() (string, time.Time, time.Time) {
+	now := time.Now()
+	return marketState(c.calendar.Days(), now)
+}
+
+// FakeClock is a clock tests and backtests move by hand. Its market follows the
+// regular weekday sessions, or the days given to SetCalendar, unless SetOpen overrides it.
+type FakeClock struct {
+	mu       sync.Mutex
+	now      time.Time
+	open     *bool
+	calendar []CalendarDay
+	waiters  []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+// NewFakeClock creates a fake clock stopped at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel that receives the fake time once the clock has been
+// moved at least d forward.
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Set moves the clock to t and fires every After that has come due.
+func (c *FakeClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if t.Before(w.at) {
+			waiting = append(waiting, w)
+			continue
+		}
+		w.ch <- t
+	}
+	c.waiters = waiting
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// SetOpen fixes whether the market is open regardless of the time.
+func (c *FakeClock) SetOpen(open bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.open = &open
+}
+
+// SetCalendar replaces the regular weekday sessions with the given days, e.g. to
+// test holidays and early closes.
+func (c *FakeClock) SetCalendar(days []CalendarDay) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calendar = days
+}
+
+// IsOpen reports whether the regular session is in progress at the fake time.
+func (c *FakeClock) IsOpen() bool {
+	return c.Phase() == types.PhaseRegular
+}
+
+// Phase returns the session phase at the fake time; SetOpen makes it regular or closed.
+func (c *FakeClock) Phase() string {
+	phase, _, _ := c.state()
+	return phase
+}
+
+// NextOpen returns the start of the next regular session after the fake time.
+func (c *FakeClock) NextOpen() time.Time {
+	_, next, _ := c.state()
+	return next
+}
+
+// NextClose returns the end of the current or next regular session.
+func (c *FakeClock) NextClose() time.Time {
+	_, _, next := c.state()
+	return next
+}
+
+func (c *FakeClock) state() (string, time.Time, time.Time) {
+	c.mu.Lock()
+	now, override, days := c.now, c.open, c.calendar
+	c.mu.Unlock()
+
+	if days == nil {
+		days = regularSessions(now, now.AddDate(0, 0, 7))
+	}
+	phase, nextOpen, nextClose := marketState(days, now)
+	switch {
+	case override == nil:
+	case *override:
+		phase = types.PhaseRegular
+	default:
+		phase = types.PhaseClosed
+	}
+	return phase, nextOpen, nextClose
+}
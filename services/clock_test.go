@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/types"
+	"github.com/dickeyy/cis-320/utils"
+)
+
+func TestRegularMarketState(t *testing.T) {
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2025, 1, day, hour, minute, 0, 0, utils.MarketLocation)
+	}
+	tests := []struct {
+		name      string
+		now       time.Time
+		phase     string
+		nextOpen  time.Time
+		nextClose time.Time
+	}{
+		{"friday overnight", at(3, 3, 0), types.PhaseClosed, at(3, 9, 30), at(3, 16, 0)},
+		{"friday before the open", at(3, 9, 0), types.PhasePreMarket, at(3, 9, 30), at(3, 16, 0)},
+		{"friday session", at(3, 10, 0), types.PhaseRegular, at(6, 9, 30), at(3, 16, 0)},
+		{"friday after the close", at(3, 17, 0), types.PhaseAfterHours, at(6, 9, 30), at(6, 16, 0)},
+		{"weekend", at(4, 12, 0), types.PhaseClosed, at(6, 9, 30), at(6, 16, 0)},
+	}
+	for _, tt := range tests {
+		clock := NewFakeClock(tt.now)
+		if clock.Phase() != tt.phase || !clock.NextOpen().Equal(tt.nextOpen) || !clock.NextClose().Equal(tt.nextClose) {
+			t.Errorf("%s: phase %s, next open %s, next close %s; want %s, %s, %s", tt.name,
+				clock.Phase(), clock.NextOpen(), clock.NextClose(), tt.phase, tt.nextOpen, tt.nextClose)
+		}
+		if clock.IsOpen() != (tt.phase == types.PhaseRegular) {
+			t.Errorf("%s: IsOpen() = %v in phase %s", tt.name, clock.IsOpen(), tt.phase)
+		}
+	}
+}
+
+func TestEarlyCloseSessions(t *testing.T) {
+	// the day after Thanksgiving closes at 13:00, and after hours end at 17:00
+	day := time.Date(2025, 11, 28, 0, 0, 0, 0, utils.MarketLocation)
+	clock := NewFakeClock(day.Add(14 * time.Hour))
+	clock.SetCalendar([]CalendarDay{newCalendarDay(day, regularOpen, 13*time.Hour)})
+
+	if phase := clock.Phase(); phase != types.PhaseAfterHours {
+		t.Errorf("Phase() at 14:00 = %s, want %s", phase, types.PhaseAfterHours)
+	}
+	clock.Set(day.Add(17*time.Hour + time.Minute))
+	if phase := clock.Phase(); phase != types.PhaseClosed {
+		t.Errorf("Phase() at 17:01 = %s, want %s", phase, types.PhaseClosed)
+	}
+}
+
+func TestCalendarDayAcrossDaylightSaving(t *testing.T) {
+	// clocks go forward at 2:00 on March 9, 2025
+	d := newCalendarDay(time.Date(2025, 3, 9, 0, 0, 0, 0, utils.MarketLocation), regularOpen, regularClose)
+	if got := d.Open.In(utils.MarketLocation).Format("15:04"); got != "09:30" {
+		t.Errorf("Open = %s, want 09:30", got)
+	}
+	if d.EarlyClose() {
+		t.Error("EarlyClose() = true for a 16:00 close")
+	}
+}
+
+func TestFakeClockAfter(t *testing.T) {
+	start := time.Date(2025, 1, 3, 10, 0, 0, 0, utils.MarketLocation)
+	clock := NewFakeClock(start)
+	ch := clock.After(time.Minute)
+
+	clock.Advance(30 * time.Second)
+	select {
+	case <-ch:
+		t.Fatal("After(1m) fired after 30s")
+	default:
+	}
+
+	clock.Advance(30 * time.Second)
+	select {
+	case got := <-ch:
+		if !got.Equal(start.Add(time.Minute)) {
+			t.Errorf("After(1m) fired with %s, want %s", got, start.Add(time.Minute))
+		}
+	default:
+		t.Fatal("After(1m) did not fire after 1m")
+	}
+
+	clock.SetOpen(false)
+	if clock.IsOpen() {
+		t.Error("IsOpen() = true after SetOpen(false)")
+	}
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+)
+
+// ErrorKind classifies a venue error by what the caller can do about it.
+type ErrorKind string
+
+const (
+	// ErrorRetryable is a transient failure (network, 5xx, 429): the same request may succeed later.
+	ErrorRetryable ErrorKind = "retryable"
+	// ErrorPermanent is a rejection of the request itself (4xx such as insufficient
+	// buying power, or an invalid order): retrying it unchanged will fail again.
+	ErrorPermanent ErrorKind = "permanent"
+)
+
+// OrderError is the error agents receive when the broker could not place their
+// order. Kind tells them whether the decision failed on its merits or only
+// because the venue was unavailable.
+type OrderError struct {
+	Kind     ErrorKind
+	Attempts int
+	Err      error
+}
+
+func (e *OrderError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *OrderError) Unwrap() error {
+	return e.Err
+}
+
+// ClassifyError reports whether err is worth retrying. Errors the venue returned
+// are classified by HTTP status; connection failures and timeouts are
+// retryable; anything else, such as a local validation failure, is permanent.
+func ClassifyError(err error) ErrorKind {
+	var orderErr *OrderError
+	if errors.As(err, &orderErr) {
+		return orderErr.Kind
+	}
+
+	if errors.Is(err, ErrRateLimited) {
+		return ErrorRetryable
+	}
+
+	var apiErr *a.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.StatusCode == http.StatusTooManyRequests || apiErr.StatusCode >= 500 {
+			return ErrorRetryable
+		}
+		return ErrorPermanent
+	}
+
+	// the caller gave up, there is nothing to retry
+	if errors.Is(err, context.Canceled) {
+		return ErrorPermanent
+	}
+
+	var netErr net.Error
+	var urlErr *url.Error
+	if errors.As(err, &netErr) || errors.As(err, &urlErr) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorRetryable
+	}
+
+	return ErrorPermanent
+}
+
+// IsRetryable reports whether err is a transient failure.
+func IsRetryable(err error) bool {
+	return err != nil && ClassifyError(err) == ErrorRetryable
+}
+
+// IsNotFound reports whether the venue answered that the requested object does not exist.
+func IsNotFound(err error) bool {
+	var apiErr *a.APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
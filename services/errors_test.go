@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorKind
+	}{
+		{"rate limited", &a.APIError{StatusCode: 429}, ErrorRetryable},
+		{"server error", &a.APIError{StatusCode: 502}, ErrorRetryable},
+		{"insufficient buying power", &a.APIError{StatusCode: 403, Message: "insufficient buying power"}, ErrorPermanent},
+		{"wrapped api error", fmt.Errorf("placing order: %w", &a.APIError{StatusCode: 503}), ErrorRetryable},
+		{"connection refused", &url.Error{Op: "Post", URL: "https://paper-api.alpaca.markets", Err: errors.New("connection refused")}, ErrorRetryable},
+		{"timeout", context.DeadlineExceeded, ErrorRetryable},
+		{"canceled", context.Canceled, ErrorPermanent},
+		{"validation", errors.New("limit_price is required for limit orders"), ErrorPermanent},
+		{"order error", &OrderError{Kind: ErrorRetryable, Err: errors.New("gave up")}, ErrorRetryable},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("%s: ClassifyError() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/alpacahq/alpaca-trade-api-go/v3/marketdata"
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+// Market is the market data the LLM's tools read; nil offers the model no market data tools.
+var Market MarketData
+
+// MarketData answers the LLM's questions about the market.
+type MarketData interface {
+	// Quote returns the latest price of the symbol.
+	Quote(symbol string) (MarketQuote, error)
+	// Bars returns up to limit of the symbol's most recent bars of timeframe, oldest first.
+	Bars(symbol string, timeframe time.Duration, limit int) ([]MarketBar, error)
+}
+
+// MarketQuote is a symbol's latest price, with the bid and ask when known.
+type MarketQuote struct {
+	Symbol string           `json:"symbol"`
+	Price  decimal.Decimal  `json:"price"`
+	Bid    *decimal.Decimal `json:"bid,omitempty"`
+	Ask    *decimal.Decimal `json:"ask,omitempty"`
+	Time   time.Time        `json:"time,omitzero"`
+}
+
+// MarketBar is one OHLCV bar.
+type MarketBar struct {
+	Time   time.Time       `json:"time"`
+	Open   decimal.Decimal `json:"open"`
+	High   decimal.Decimal `json:"high"`
+	Low    decimal.Decimal `json:"low"`
+	Close  decimal.Decimal `json:"close"`
+	Volume int64           `json:"volume"`
+}
+
+// AlpacaMarketData reads quotes and bars from Alpaca's market data API on the
+// IEX feed, which every account can use. Every request waits on the rate
+// limiter shared by all clients of the account's API key.
+type AlpacaMarketData struct {
+	client  *marketdata.Client
+	limiter *RateLimiter
+}
+
+// NewAlpacaMarketData creates a market data source with the account's
+// credentials, read from the configured market data URL within the key's rate limit.
+func NewAlpacaMarketData(apiKey, apiSecret string) *AlpacaMarketData {
+	return &AlpacaMarketData{
+		client: marketdata.NewClient(marketdata.ClientOpts{
+			APIKey:    apiKey,
+			APISecret: apiSecret,
+			BaseURL:   utils.AlpacaDataURL(),
+			Feed:      marketdata.IEX,
+		}),
+		limiter: RateLimiterFor(apiKey),
+	}
+}
+
+// Quote returns the latest trade price with the latest bid and ask.
+func (m *AlpacaMarketData) Quote(symbol string) (MarketQuote, error) {
+	if err := m.limiter.Wait(); err != nil {
+		return MarketQuote{}, err
+	}
+	trade, err := m.client.GetLatestTrade(symbol, marketdata.GetLatestTradeRequest{})
+	if err != nil {
+		return MarketQuote{}, err
+	}
+	if trade == nil {
+		return MarketQuote{}, fmt.Errorf("no trades for symbol %s", symbol)
+	}
+	q := MarketQuote{Symbol: symbol, Price: decimal.NewFromFloat(trade.Price), Time: trade.Timestamp}
+	// the bid and ask are extras, left out when the budget is spent
+	if err := m.limiter.Wait(); err != nil {
+		return q, nil
+	}
+	if quote, err := m.client.GetLatestQuote(symbol, marketdata.GetLatestQuoteRequest{}); err == nil && quote != nil {
+		bid, ask := decimal.NewFromFloat(quote.BidPrice), decimal.NewFromFloat(quote.AskPrice)
+		q.Bid, q.Ask = &bid, &ask
+	}
+	return q, nil
+}
+
+// Bars returns the most recent bars. Minute and hour bars only cover trading
+// hours, so the request looks back further than limit bars to find enough.
+func (m *AlpacaMarketData) Bars(symbol string, timeframe time.Duration, limit int) ([]MarketBar, error) {
+	var tf marketdata.TimeFrame
+	switch {
+	case timeframe%(24*time.Hour) == 0:
+		tf = marketdata.NewTimeFrame(int(timeframe/(24*time.Hour)), marketdata.Day)
+	case timeframe%time.Hour == 0:
+		tf = marketdata.NewTimeFrame(int(timeframe/time.Hour), marketdata.Hour)
+	case timeframe%time.Minute == 0 && timeframe > 0:
+		tf = marketdata.NewTimeFrame(int(timeframe/time.Minute), marketdata.Min)
+	default:
+		return nil, fmt.Errorf("unsupported timeframe %s", timeframe)
+	}
+
+	end := time.Now()
+	start := end.Add(-time.Duration(limit)*timeframe*4 - 5*24*time.Hour)
+	if err := m.limiter.Wait(); err != nil {
+		return nil, err
+	}
+	bars, err := m.client.GetBars(symbol, marketdata.GetBarsRequest{TimeFrame: tf, Start: start, End: end})
+	if err != nil {
+		return nil, err
+	}
+	if len(bars) > limit {
+		bars = bars[len(bars)-limit:]
+	}
+	out := make([]MarketBar, 0, len(bars))
+	for _, b := range bars {
+		out = append(out, MarketBar{
+			Time:   b.Timestamp,
+			Open:   decimal.NewFromFloat(b.Open),
+			High:   decimal.NewFromFloat(b.High),
+			Low:    decimal.NewFromFloat(b.Low),
+			Close:  decimal.NewFromFloat(b.Close),
+			Volume: int64(b.Volume),
+		})
+	}
+	return out, nil
+}
+
+// PriceSourceMarketData quotes the prices of a PriceSource, e.g. the simulated
+// venue's. It has no history, so it has no bars.
+type PriceSourceMarketData struct {
+	Prices PriceSource
+}
+
+// Quote returns the source's current price.
+func (m PriceSourceMarketData) Quote(symbol string) (MarketQuote, error) {
+	price, err := m.Prices.GetPrice(symbol)
+	if err != nil {
+		return MarketQuote{}, err
+	}
+	return MarketQuote{Symbol: symbol, Price: price}, nil
+}
+
+// Bars always fails, the source keeps no history.
+func (m PriceSourceMarketData) Bars(symbol string, timeframe time.Duration, limit int) ([]MarketBar, error) {
+	return nil, fmt.Errorf("no bar history in simulation mode")
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+// orderType returns the trade's order type, defaulting to market.
+func orderType(trade *types.Trade) string {
+	if trade.OrderType == "" {
+		return types.OrderTypeMarket
+	}
+	return strings.ToLower(trade.OrderType)
+}
+
+// timeInForce returns the trade's time in force, defaulting to day.
+func timeInForce(trade *types.Trade) string {
+	if trade.TimeInForce == "" {
+		return types.TimeInForceDay
+	}
+	return strings.ToLower(trade.TimeInForce)
+}
+
+// orderClass picks the Alpaca order class from the protective legs on the trade:
+// an entry with both legs is a bracket, with one leg an OTO, and an exit with
+// both legs an OCO.
+func orderClass(trade *types.Trade) a.OrderClass {
+	hasTP, hasSL := trade.TakeProfit != nil, trade.StopLoss != nil
+	switch {
+	case trade.Action == "BUY" && hasTP && hasSL:
+		return a.Bracket
+	case trade.Action == "BUY" && (hasTP || hasSL):
+		return a.OTO
+	case trade.Action == "SELL" && hasTP && hasSL:
+		return a.OCO
+	default:
+		return a.Simple
+	}
+}
+
+// ValidateOrder checks that the trade carries every price its order type and
+// order class need. Both the Alpaca and simulated venues call it before placing.
+func ValidateOrder(trade *types.Trade) error {
+	if trade.Symbol == "" {
+		return fmt.Errorf("symbol is required")
+	}
+	if trade.Action != "BUY" && trade.Action != "SELL" {
+		return fmt.Errorf("unsupported trade action %q", trade.Action)
+	}
+
+	for name, d := range map[string]*decimal.Decimal{
+		"limit_price":   trade.LimitPrice,
+		"stop_price":    trade.StopPrice,
+		"trail_percent": trade.TrailPercent,
+		"take_profit":   trade.TakeProfit,
+		"stop_loss":     trade.StopLoss,
+	} {
+		if d != nil && !d.IsPositive() {
+			return fmt.Errorf("%s must be positive", name)
+		}
+	}
+
+	switch orderType(trade) {
+	case types.OrderTypeMarket:
+	case types.OrderTypeLimit:
+		if trade.LimitPrice == nil {
+			return fmt.Errorf("limit_price is required for limit orders")
+		}
+	case types.OrderTypeStop:
+		if trade.StopPrice == nil {
+			return fmt.Errorf("stop_price is required for stop orders")
+		}
+	case types.OrderTypeStopLimit:
+		if trade.LimitPrice == nil || trade.StopPrice == nil {
+			return fmt.Errorf("limit_price and stop_price are required for stop_limit orders")
+		}
+	case types.OrderTypeTrailingStop:
+		if trade.TrailPercent == nil {
+			return fmt.Errorf("trail_percent is required for trailing_stop orders")
+		}
+	default:
+		return fmt.Errorf("unsupported order type %q", trade.OrderType)
+	}
+
+	switch timeInForce(trade) {
+	case types.TimeInForceDay, types.TimeInForceGTC, types.TimeInForceIOC,
+		types.TimeInForceFOK, types.TimeInForceOPG, types.TimeInForceCLS:
+	default:
+		return fmt.Errorf("unsupported time in force %q", trade.TimeInForce)
+	}
+
+	class := orderClass(trade)
+	if trade.ExtendedHours && (orderType(trade) != types.OrderTypeLimit || timeInForce(trade) != types.TimeInForceDay || class != a.Simple) {
+		return fmt.Errorf("extended hours orders must be simple day limit orders")
+	}
+	if trade.Action == "SELL" && class == a.Simple && (trade.TakeProfit != nil || trade.StopLoss != nil) {
+		return fmt.Errorf("a single exit leg should be placed as a limit or stop order instead")
+	}
+	if class != a.Simple {
+		if tif := timeInForce(trade); tif != types.TimeInForceDay && tif != types.TimeInForceGTC {
+			return fmt.Errorf("%s orders must be day or gtc", class)
+		}
+		if class == a.OCO && orderType(trade) != types.OrderTypeLimit && orderType(trade) != types.OrderTypeMarket {
+			return fmt.Errorf("oco orders are placed as limit orders")
+		}
+		if trade.TakeProfit != nil && trade.StopLoss != nil && !trade.TakeProfit.GreaterThan(*trade.StopLoss) {
+			return fmt.Errorf("take_profit must be above stop_loss")
+		}
+	}
+
+	_, _, err := orderQuantity(trade)
+	return err
+}
+
+// orderQuantity returns either the share quantity or the notional for the order.
+// Alpaca only accepts notional amounts on simple market orders, so every other
+// BUY given only an amount is converted to whole shares at its reference price
+// (limit price, then stop price, then the expected price on the trade).
+func orderQuantity(trade *types.Trade) (*decimal.Decimal, *decimal.Decimal, error) {
+	if trade.Quantity != nil && trade.Quantity.IsPositive() {
+		return trade.Quantity, nil, nil
+	}
+	if trade.Action == "SELL" {
+		return nil, nil, fmt.Errorf("quantity is required")
+	}
+	if trade.Amount == nil || !trade.Amount.IsPositive() {
+		return nil, nil, fmt.Errorf("amount or quantity is required")
+	}
+	if orderType(trade) == types.OrderTypeMarket && orderClass(trade) == a.Simple {
+		return nil, trade.Amount, nil
+	}
+
+	var ref *decimal.Decimal
+	for _, p := range []*decimal.Decimal{trade.LimitPrice, trade.StopPrice, trade.Price} {
+		if p != nil && p.IsPositive() {
+			ref = p
+			break
+		}
+	}
+	if ref == nil {
+		return nil, nil, fmt.Errorf("quantity or a reference price is required for %s %s orders", orderClass(trade), orderType(trade))
+	}
+
+	qty := trade.Amount.Div(*ref).Floor()
+	if !qty.IsPositive() {
+		return nil, nil, fmt.Errorf("amount $%s does not buy one whole share at $%s", trade.Amount, ref)
+	}
+	return &qty, nil, nil
+}
+
+// orderRequest maps a trade onto an Alpaca order request, including bracket,
+// OTO and OCO legs.
+func orderRequest(trade *types.Trade) (a.PlaceOrderRequest, error) {
+	if err := ValidateOrder(trade); err != nil {
+		return a.PlaceOrderRequest{}, err
+	}
+	qty, notional, _ := orderQuantity(trade)
+
+	req := a.PlaceOrderRequest{
+		Symbol:        trade.Symbol,
+		Qty:           qty,
+		Notional:      notional,
+		Side:          a.Side(strings.ToLower(trade.Action)),
+		Type:          a.OrderType(orderType(trade)),
+		TimeInForce:   a.TimeInForce(timeInForce(trade)),
+		LimitPrice:    trade.LimitPrice,
+		StopPrice:     trade.StopPrice,
+		TrailPercent:  trade.TrailPercent,
+		ClientOrderID: trade.ID,
+		ExtendedHours: trade.ExtendedHours,
+	}
+
+	class := orderClass(trade)
+	if class != a.Simple {
+		req.OrderClass = class
+	}
+	if trade.TakeProfit != nil {
+		req.TakeProfit = &a.TakeProfit{LimitPrice: trade.TakeProfit}
+	}
+	if trade.StopLoss != nil {
+		req.StopLoss = &a.StopLoss{StopPrice: trade.StopLoss}
+	}
+	if class == a.OCO {
+		// the OCO parent is the take-profit limit order
+		req.Type = a.Limit
+		req.LimitPrice = trade.TakeProfit
+	}
+
+	return req, nil
+}
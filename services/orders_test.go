@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+)
+
+func TestOrderRequest(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade types.Trade
+		class a.OrderClass
+		typ   a.OrderType
+	}{
+		{"market notional", types.Trade{Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("50")}, "", a.Market},
+		{"limit buy", types.Trade{Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("500"), OrderType: "limit", LimitPrice: decimalPtr("100")}, "", a.Limit},
+		{"bracket", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("3"), TakeProfit: decimalPtr("120"), StopLoss: decimalPtr("90")}, a.Bracket, a.Market},
+		{"oto", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("3"), StopLoss: decimalPtr("90")}, a.OTO, a.Market},
+		{"oco", types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: decimalPtr("3"), TakeProfit: decimalPtr("120"), StopLoss: decimalPtr("90")}, a.OCO, a.Limit},
+	}
+	for _, tt := range tests {
+		req, err := orderRequest(&tt.trade)
+		if err != nil {
+			t.Errorf("%s: orderRequest() error = %v", tt.name, err)
+			continue
+		}
+		if req.OrderClass != tt.class || req.Type != tt.typ {
+			t.Errorf("%s: class/type = %q/%q, want %q/%q", tt.name, req.OrderClass, req.Type, tt.class, tt.typ)
+		}
+		if tt.typ != a.Market || tt.class != "" {
+			if req.Notional != nil || req.Qty == nil {
+				t.Errorf("%s: want whole-share qty and no notional, got qty=%v notional=%v", tt.name, req.Qty, req.Notional)
+			}
+		}
+	}
+}
+
+func TestValidateOrderRejects(t *testing.T) {
+	tests := []struct {
+		name  string
+		trade types.Trade
+	}{
+		{"limit without price", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("1"), OrderType: "limit"}},
+		{"trailing without percent", types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: decimalPtr("1"), OrderType: "trailing_stop"}},
+		{"bracket with ioc", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("1"), TakeProfit: decimalPtr("2"), StopLoss: decimalPtr("1"), TimeInForce: "ioc"}},
+		{"inverted legs", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("1"), TakeProfit: decimalPtr("1"), StopLoss: decimalPtr("2")}},
+		{"single exit leg", types.Trade{Symbol: "AAPL", Action: "SELL", Quantity: decimalPtr("1"), StopLoss: decimalPtr("2")}},
+		{"extended hours market", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("1"), ExtendedHours: true}},
+		{"extended hours gtc", types.Trade{Symbol: "AAPL", Action: "BUY", Quantity: decimalPtr("1"), OrderType: "limit", LimitPrice: decimalPtr("100"), TimeInForce: "gtc", ExtendedHours: true}},
+		{"notional below one share", types.Trade{Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("50"), OrderType: "limit", LimitPrice: decimalPtr("100")}},
+	}
+	for _, tt := range tests {
+		if err := ValidateOrder(&tt.trade); err == nil {
+			t.Errorf("%s: ValidateOrder() = nil, want error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// PriceSource supplies the prices a simulated venue fills orders at.
+type PriceSource interface {
+	GetPrice(symbol string) (decimal.Decimal, error)
+}
+
+// StaticPrices is a fixed symbol -> price table, mostly useful in tests.
+type StaticPrices map[string]decimal.Decimal
+
+// GetPrice returns the fixed price for the symbol.
+func (p StaticPrices) GetPrice(symbol string) (decimal.Decimal, error) {
+	price, ok := p[symbol]
+	if !ok {
+		return decimal.Zero, fmt.Errorf("no price for symbol %s", symbol)
+	}
+	return price, nil
+}
+
+// RandomWalkPrices generates prices for any symbol without market data.
+// Each symbol starts at a price derived from its name and moves by up to
+// MaxStep (as a fraction) once every Interval of the clock, at multiples of
+// Interval like the agents' ticks.
+// Reading a price never moves it, so every account and every order in the same
+// interval sees the same prices.
+type RandomWalkPrices struct {
+	MaxStep  float64
+	Interval time.Duration
+
+	mu    sync.Mutex
+	seed  int64
+	now   func() time.Time
+	start time.Time
+	walks map[string]*randomWalk
+}
+
+// randomWalk is one symbol's walk, drawn from its own generator so the prices
+// do not depend on which symbols are read in which order.
+type randomWalk struct {
+	price float64
+	step  int64 // intervals applied to price
+	rng   *rand.Rand
+}
+
+// NewRandomWalkPrices creates a random walk price source on the system clock
+// with a 1% max step every minute.
+func NewRandomWalkPrices(seed int64) *RandomWalkPrices {
+	return &RandomWalkPrices{
+		MaxStep:  0.01,
+		Interval: time.Minute,
+		seed:     seed,
+		now:      time.Now,
+		start:    time.Now(),
+		walks:    make(map[string]*randomWalk),
+	}
+}
+
+// SetClock sets the clock the prices move on; the walk starts at its current time.
+func (p *RandomWalkPrices) SetClock(now func() time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.now = now
+	p.start = now()
+}
+
+// GetPrice returns the symbol's price in the current interval.
+func (p *RandomWalkPrices) GetPrice(symbol string) (decimal.Decimal, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	w, ok := p.walks[symbol]
+	if !ok {
+		// deterministic starting price between $5 and $500 so runs are comparable
+		h := fnv.New32a()
+		h.Write([]byte(symbol))
+		w = &randomWalk{
+			price: 5 + float64(h.Sum32()%49500)/100,
+			rng:   rand.New(rand.NewSource(p.seed ^ int64(h.Sum32()))),
+		}
+		p.walks[symbol] = w
+	}
+
+	// intervals are aligned like the agents' ticks, so prices move on a tick
+	step := int64(0)
+	if p.Interval > 0 {
+		step = int64(p.now().Truncate(p.Interval).Sub(p.start.Truncate(p.Interval)) / p.Interval)
+	}
+	// catch the walk up to the current interval
+	for ; w.step < step; w.step++ {
+		w.price *= 1 + (w.rng.Float64()*2-1)*p.MaxStep
+	}
+
+	return decimal.NewFromFloat(w.price).Round(2), nil
+}
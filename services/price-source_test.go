@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRandomWalkPricesMoveOnlyWithTheClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2025, 1, 2, 15, 0, 0, 0, time.UTC))
+	newPrices := func() *RandomWalkPrices {
+		p := NewRandomWalkPrices(7)
+		p.Interval = 10 * time.Minute
+		p.SetClock(clock.Now)
+		return p
+	}
+	prices, other := newPrices(), newPrices()
+
+	first, _ := prices.GetPrice("AAPL")
+	for i := 0; i < 5; i++ {
+		// reads, e.g. quotes of another agent, do not move the price
+		prices.GetPrice("AAPL")
+		prices.GetPrice("MSFT")
+	}
+	clock.Advance(9 * time.Minute)
+	if again, _ := prices.GetPrice("AAPL"); !again.Equal(first) {
+		t.Errorf("price moved within the interval: %s, then %s", first, again)
+	}
+
+	clock.Advance(time.Minute)
+	moved, _ := prices.GetPrice("AAPL")
+	if moved.Equal(first) {
+		t.Errorf("price did not move on the next interval: %s", moved)
+	}
+	// the walk does not depend on how often or in which order symbols were read
+	if got, _ := other.GetPrice("AAPL"); !got.Equal(moved) {
+		t.Errorf("price read once = %s, read many times = %s", got, moved)
+	}
+}
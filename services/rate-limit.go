@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ErrRateLimited is returned instead of calling Alpaca when a request would have
+// to queue longer than the budget allows. It is classified as retryable.
+var ErrRateLimited = errors.New("alpaca request budget exhausted")
+
+// RateBudget configures how many Alpaca requests one API key may make.
+type RateBudget struct {
+	Rate    float64       // sustained requests per second
+	Burst   int           // requests allowed back to back before calls start to queue
+	MaxWait time.Duration // longest a call queues for its turn before it is shed
+}
+
+// DefaultRateBudget stays under Alpaca's 200 requests per minute per key.
+func DefaultRateBudget() RateBudget {
+	return RateBudget{Rate: 180.0 / 60.0, Burst: 10, MaxWait: 5 * time.Second}
+}
+
+// RateLimitCounters are the running totals for one API key.
+type RateLimitCounters struct {
+	Calls     uint64        `json:"calls"`     // requests let through
+	Throttled uint64        `json:"throttled"` // requests that had to queue first
+	Shed      uint64        `json:"shed"`      // requests rejected with ErrRateLimited
+	Waited    time.Duration `json:"waited"`    // total time spent queueing
+}
+
+// RateLimiter is a token bucket shared by every client using the same API key.
+type RateLimiter struct {
+	mu       sync.Mutex
+	key      string
+	budget   RateBudget
+	tokens   float64
+	last     time.Time
+	counters RateLimitCounters
+}
+
+var (
+	rateLimitersMu sync.Mutex
+	rateLimiters   = make(map[string]*RateLimiter)
+	rateBudget     = DefaultRateBudget()
+)
+
+// RateLimiterFor returns the limiter shared by every caller using apiKey.
+func RateLimiterFor(apiKey string) *RateLimiter {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	l, ok := rateLimiters[apiKey]
+	if !ok {
+		l = &RateLimiter{key: apiKey, budget: rateBudget, tokens: float64(rateBudget.Burst), last: time.Now()}
+		rateLimiters[apiKey] = l
+	}
+	return l
+}
+
+// SetRateBudget changes the budget of every limiter, existing and future.
+func SetRateBudget(budget RateBudget) {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	rateBudget = budget
+	for _, l := range rateLimiters {
+		l.mu.Lock()
+		l.budget = budget
+		l.tokens = math.Min(l.tokens, float64(budget.Burst))
+		l.mu.Unlock()
+	}
+}
+
+// RateLimitStats returns the counters of every limiter, keyed by a redacted API key.
+func RateLimitStats() map[string]RateLimitCounters {
+	rateLimitersMu.Lock()
+	defer rateLimitersMu.Unlock()
+
+	stats := make(map[string]RateLimitCounters, len(rateLimiters))
+	for key, l := range rateLimiters {
+		stats[redactKey(key)] = l.Counters()
+	}
+	return stats
+}
+
+// LogRateLimitStats logs the counters of every limiter that has let a request through.
+func LogRateLimitStats() {
+	for key, c := range RateLimitStats() {
+		if c.Calls == 0 && c.Shed == 0 {
+			continue
+		}
+		log.Info().Str("api_key", key).Uint64("calls", c.Calls).Uint64("throttled", c.Throttled).Uint64("shed", c.Shed).Dur("waited", c.Waited).Msg("Alpaca rate limit")
+	}
+}
+
+// Wait blocks until the caller may make a request, or returns ErrRateLimited
+// straight away if that would take longer than the budget's MaxWait.
+func (l *RateLimiter) Wait() error {
+	l.mu.Lock()
+	now := time.Now()
+	l.refillLocked(now)
+
+	// take the token now, even if it is only available in the future, so
+	// queued callers are served in order
+	wait := time.Duration(0)
+	if l.tokens < 1 {
+		wait = time.Duration((1 - l.tokens) / l.budget.Rate * float64(time.Second))
+		if wait > l.budget.MaxWait {
+			l.counters.Shed++
+			l.mu.Unlock()
+			log.Warn().Str("api_key", redactKey(l.key)).Dur("wait", wait).Msg("Alpaca request shed by rate limiter")
+			return ErrRateLimited
+		}
+		l.counters.Throttled++
+		l.counters.Waited += wait
+	}
+	l.tokens--
+	l.counters.Calls++
+	l.mu.Unlock()
+
+	if wait > 0 {
+		log.Debug().Str("api_key", redactKey(l.key)).Dur("wait", wait).Msg("Alpaca request throttled")
+		time.Sleep(wait)
+	}
+	return nil
+}
+
+// Counters returns a copy of the limiter's running totals.
+func (l *RateLimiter) Counters() RateLimitCounters {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counters
+}
+
+func (l *RateLimiter) refillLocked(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	l.last = now
+	l.tokens = math.Min(float64(l.budget.Burst), l.tokens+elapsed*l.budget.Rate)
+}
+
+// redactKey keeps API keys out of logs and metrics.
+func redactKey(key string) string {
+	if len(key) <= 4 {
+		return "****"
+	}
+	return key[:4] + "****"
+}
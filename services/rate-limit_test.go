@@ -0,0 +1,63 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dickeyy/cis-320/utils"
+	"github.com/shopspring/decimal"
+)
+
+func TestRateLimiterQueuesAndSheds(t *testing.T) {
+	l := &RateLimiter{key: "test", budget: RateBudget{Rate: 100, Burst: 1, MaxWait: 15 * time.Millisecond}, tokens: 1, last: time.Now()}
+
+	// the burst goes straight through, the next call queues for ~10ms
+	for i := 0; i < 2; i++ {
+		if err := l.Wait(); err != nil {
+			t.Fatalf("Wait() #%d error = %v", i+1, err)
+		}
+	}
+	// with two calls queued ahead the wait is ~30ms, over the budget
+	l.tokens, l.last = -2, time.Now()
+	if err := l.Wait(); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("Wait() error = %v, want ErrRateLimited", err)
+	}
+	if !IsRetryable(ErrRateLimited) {
+		t.Error("ErrRateLimited is not retryable")
+	}
+
+	c := l.Counters()
+	if c.Calls != 2 || c.Throttled != 1 || c.Shed != 1 {
+		t.Errorf("counters = %+v, want 2 calls, 1 throttled, 1 shed", c)
+	}
+}
+
+func TestAlpacaMarketDataRateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/stocks/trades/latest":
+			w.Write([]byte(`{"trades": {"AAPL": {"p": 187.5, "t": "2025-01-03T15:00:00Z"}}}`))
+		case "/v2/stocks/quotes/latest":
+			w.Write([]byte(`{"quotes": {"AAPL": {"bp": 187.4, "ap": 187.6, "t": "2025-01-03T15:00:00Z"}}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	utils.SetAlpacaBaseURL(server.URL)
+	defer utils.SetAlpacaBaseURL("")
+
+	quote, err := NewAlpacaMarketData("data-key", "secret").Quote("AAPL")
+	if err != nil {
+		t.Fatalf("Quote() error = %v", err)
+	}
+	if !quote.Price.Equal(decimal.RequireFromString("187.5")) || quote.Ask == nil {
+		t.Errorf("Quote() = %+v, want the fake server's trade and quote", quote)
+	}
+	if c := RateLimiterFor("data-key").Counters(); c.Calls != 2 {
+		t.Errorf("limiter calls = %d, want the trade and the quote", c.Calls)
+	}
+}
@@ -32,6 +32,11 @@ func InitializeRedis() {
 }
 
 func SaveTrade(trade *types.Trade, ctx context.Context) error {
+	if Redis == nil {
+		log.Debug().Str("order_id", trade.ID).Msg("Redis not initialized, trade not persisted")
+		return nil
+	}
+
 	json, err := json.Marshal(trade)
 	if err != nil {
 		return err
@@ -52,13 +57,35 @@ func SaveTrade(trade *types.Trade, ctx context.Context) error {
 	return nil
 }
 
-func SaveAIReasoning(agentName string, reasoning string, tradeID string, ctx context.Context) error {
+// SaveAIReasoning saves the model's reasoning for a decision with the tool
+// calls it made before deciding, how its answer was parsed, the prompt versions
+// and what the calls used and cost, and counts the parse path in ai_parse_paths:<agent>.
+func SaveAIReasoning(agentName string, tradeID string, response *AIResponse, ctx context.Context) error {
+	if Redis == nil {
+		log.Debug().Str("trade_id", tradeID).Msg("Redis not initialized, reasoning not persisted")
+		return nil
+	}
+
 	now := time.Now().Format(time.RFC3339)
-	json, err := json.Marshal(map[string]string{
-		"trade_id":  tradeID,
-		"timestamp": now,
-		"reasoning": reasoning,
-	})
+	record := map[string]any{
+		"trade_id":   tradeID,
+		"timestamp":  now,
+		"reasoning":  response.Decisions.Reasoning,
+		"parse_path": response.ParsePath,
+		// which prompts the decision was made with, to attribute results to prompt edits
+		"prompt_version": map[string]string{
+			"system": response.SystemPromptVersion,
+			"user":   response.UserPromptVersion,
+		},
+	}
+	if len(response.ToolCalls) > 0 {
+		record["tool_calls"] = response.ToolCalls
+	}
+	if response.Usage.Calls > 0 {
+		record["usage"] = response.Usage
+		record["calls"] = response.Calls
+	}
+	json, err := json.Marshal(record)
 	if err != nil {
 		return err
 	}
@@ -69,5 +96,48 @@ func SaveAIReasoning(agentName string, reasoning string, tradeID string, ctx con
 		return err
 	}
 
-	return nil
+	// how often each parse path is needed, e.g. how often the heuristics still are
+	return Redis.HIncrBy(ctx, fmt.Sprintf("ai_parse_paths:%s", agentName), response.ParsePath, 1).Err()
+}
+
+// saveAIUsage adds a decision's usage to the agent's total and daily usage hashes.
+func saveAIUsage(agentName, date string, usage types.AIUsage, ctx context.Context) error {
+	if Redis == nil {
+		return nil
+	}
+
+	pipe := Redis.TxPipeline()
+	for _, key := range []string{fmt.Sprintf("ai_usage:%s", agentName), fmt.Sprintf("ai_usage:%s:%s", agentName, date)} {
+		pipe.HIncrBy(ctx, key, "calls", int64(usage.Calls))
+		pipe.HIncrBy(ctx, key, "prompt_tokens", int64(usage.PromptTokens))
+		pipe.HIncrBy(ctx, key, "completion_tokens", int64(usage.CompletionTokens))
+		pipe.HIncrBy(ctx, key, "latency_ms", usage.LatencyMS)
+		pipe.HIncrBy(ctx, key, "unpriced", int64(usage.Unpriced))
+		pipe.HIncrByFloat(ctx, key, "cost", usage.Cost.InexactFloat64())
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SaveRNGDecision appends an RNG agent's decision record to its list, oldest first,
+// so the run can be replayed from its seed.
+func SaveRNGDecision(agentName string, record any, ctx context.Context) error {
+	if Redis == nil {
+		log.Debug().Str("agent", agentName).Msg("Redis not initialized, RNG decision not persisted")
+		return nil
+	}
+
+	json, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return Redis.RPush(ctx, fmt.Sprintf("rng_decisions:%s", agentName), json).Err()
+}
+
+// LoadRNGDecisions returns an RNG agent's decision records, oldest first.
+func LoadRNGDecisions(agentName string, ctx context.Context) ([]string, error) {
+	if Redis == nil {
+		return nil, fmt.Errorf("redis not initialized")
+	}
+	return Redis.LRange(ctx, fmt.Sprintf("rng_decisions:%s", agentName), 0, -1).Result()
 }
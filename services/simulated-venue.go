@@ -0,0 +1,636 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+)
+
+// SimulatedVenue is a fully in-process ExecutionVenue. It keeps cash, positions
+// and fills in memory and fills orders against a PriceSource, so agents
+// can run without Alpaca credentials or network access. It is a cash-only
+// account: buying power never exceeds cash.
+type SimulatedVenue struct {
+	mu        sync.Mutex
+	accountID string
+	cash      decimal.Decimal
+	initial   decimal.Decimal
+	positions map[string]*simPosition
+	orders    map[string]*simOrder
+	sequence  []string // order ids in submission order, so matching is deterministic
+	fills     []SimulatedFill
+	prices    PriceSource
+	now       func() time.Time
+}
+
+// SimulatedFill is a single execution on the simulated venue.
+type SimulatedFill struct {
+	OrderID       string          `json:"order_id"`
+	ClientOrderID string          `json:"client_order_id"`
+	Symbol        string          `json:"symbol"`
+	Side          string          `json:"side"`
+	Quantity      decimal.Decimal `json:"quantity"`
+	Price         decimal.Decimal `json:"price"`
+	Notional      decimal.Decimal `json:"notional"`
+	Timestamp     time.Time       `json:"timestamp"`
+}
+
+type simPosition struct {
+	qty       decimal.Decimal
+	costBasis decimal.Decimal
+}
+
+// simOrder is an order resting on the simulated book.
+type simOrder struct {
+	order     *a.Order
+	triggered bool            // stop_limit orders: the stop has been reached
+	mark      decimal.Decimal // trailing stops: high (sell) or low (buy) water mark
+	ocoWith   string          // sibling order canceled when this one fills
+	legs      []*simOrder     // bracket/OTO exits activated when this order fills
+}
+
+// NewSimulatedVenue creates a simulated account funded with the given cash.
+func NewSimulatedVenue(cash decimal.Decimal, prices PriceSource) *SimulatedVenue {
+	return &SimulatedVenue{
+		accountID: uuid.New().String(),
+		cash:      cash,
+		initial:   cash,
+		positions: make(map[string]*simPosition),
+		orders:    make(map[string]*simOrder),
+		prices:    prices,
+		now:       time.Now,
+	}
+}
+
+// SetClock replaces the clock used to stamp orders and fills, e.g. with the
+// simulated time of a backtest.
+func (v *SimulatedVenue) SetClock(now func() time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.now = now
+}
+
+// PlaceOrder accepts an order of any supported type. Market orders fill
+// immediately at the current price; limit, stop, stop-limit and trailing-stop
+// orders rest until Match (or a GetOrder poll) finds them marketable. Bracket
+// and OTO legs are held until their parent fills.
+func (v *SimulatedVenue) PlaceOrder(trade *types.Trade) (*a.Order, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if err := ValidateOrder(trade); err != nil {
+		return nil, simError(http.StatusUnprocessableEntity, 40010001, err.Error())
+	}
+	qty, notional, _ := orderQuantity(trade)
+	if trade.ID != "" && v.findClientOrderLocked(trade.ID) != nil {
+		return nil, simError(http.StatusUnprocessableEntity, 40010001, "client_order_id must be unique")
+	}
+
+	price, err := v.prices.GetPrice(trade.Symbol)
+	if err != nil {
+		return nil, simError(http.StatusUnprocessableEntity, 42210000, fmt.Sprintf("asset %q not found", trade.Symbol))
+	}
+	if !price.IsPositive() {
+		return nil, simError(http.StatusUnprocessableEntity, 42210000, fmt.Sprintf("no valid price for %s", trade.Symbol))
+	}
+
+	// check funds up front the way Alpaca does, using the limit price when there is one
+	switch trade.Action {
+	case "BUY":
+		cost := decimal.Zero
+		if notional != nil {
+			cost = *notional
+		} else {
+			ref := price
+			if trade.LimitPrice != nil {
+				ref = *trade.LimitPrice
+			}
+			cost = qty.Mul(ref).Round(2)
+		}
+		if cost.GreaterThan(v.cash) {
+			return nil, simError(http.StatusForbidden, 40310000, "insufficient buying power")
+		}
+	case "SELL":
+		if err := v.checkAvailableLocked(trade.Symbol, *qty); err != nil {
+			return nil, err
+		}
+	}
+
+	req, _ := orderRequest(trade)
+	now := v.now()
+	parent := v.newOrderLocked(req, req.Side, req.Type, qty, notional, now)
+	parent.order.OrderClass = orderClass(trade)
+
+	switch parent.order.OrderClass {
+	case a.Bracket, a.OTO:
+		// exit legs sell what the entry buys, they activate once it fills
+		var legs []*simOrder
+		if trade.TakeProfit != nil {
+			legs = append(legs, v.newOrderLocked(a.PlaceOrderRequest{Symbol: trade.Symbol, LimitPrice: trade.TakeProfit, TimeInForce: req.TimeInForce}, a.Sell, a.Limit, nil, nil, now))
+		}
+		if trade.StopLoss != nil {
+			legs = append(legs, v.newOrderLocked(a.PlaceOrderRequest{Symbol: trade.Symbol, StopPrice: trade.StopLoss, TimeInForce: req.TimeInForce}, a.Sell, a.Stop, nil, nil, now))
+		}
+		for _, leg := range legs {
+			leg.order.Status = types.OrderStatusHeld
+			leg.order.OrderClass = parent.order.OrderClass
+		}
+		if len(legs) == 2 {
+			legs[0].ocoWith, legs[1].ocoWith = legs[1].order.ID, legs[0].order.ID
+		}
+		parent.legs = legs
+	case a.OCO:
+		stop := v.newOrderLocked(a.PlaceOrderRequest{Symbol: trade.Symbol, StopPrice: trade.StopLoss, TimeInForce: req.TimeInForce}, a.Sell, a.Stop, qty, nil, now)
+		stop.order.OrderClass = a.OCO
+		parent.ocoWith, stop.ocoWith = stop.order.ID, parent.order.ID
+		parent.legs = []*simOrder{stop}
+	}
+
+	v.evaluateLocked(parent, price)
+
+	// immediate-or-cancel style orders do not rest on the book
+	if tif := timeInForce(trade); (tif == types.TimeInForceIOC || tif == types.TimeInForceFOK) && isOpenStatus(parent.order.Status) {
+		v.cancelLocked(parent)
+	}
+
+	return v.snapshotLocked(parent), nil
+}
+
+// Match evaluates every resting order against the current prices. Backtests
+// call it after advancing the price source; live runs rely on GetOrder polls.
+func (v *SimulatedVenue) Match() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.matchLocked()
+}
+
+// CancelOrder cancels an open order together with any legs it still holds.
+func (v *SimulatedVenue) CancelOrder(orderID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	so, ok := v.orders[orderID]
+	if !ok {
+		return simError(http.StatusNotFound, 40410000, "order not found")
+	}
+	if !isOpenStatus(so.order.Status) && so.order.Status != types.OrderStatusHeld {
+		return simError(http.StatusUnprocessableEntity, 42210000, fmt.Sprintf("order is already in %q state", so.order.Status))
+	}
+
+	v.cancelLocked(so)
+	return nil
+}
+
+// ReplaceOrder replaces a resting simple order with a copy carrying the changes,
+// the way Alpaca does: the old order becomes "replaced" and points at the new one.
+func (v *SimulatedVenue) ReplaceOrder(orderID string, changes types.TradeChanges) (*a.Order, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	old, ok := v.orders[orderID]
+	if !ok {
+		return nil, simError(http.StatusNotFound, 40410000, "order not found")
+	}
+	v.matchLocked()
+	o := old.order
+	if o.Status != types.OrderStatusNew && o.Status != types.OrderStatusAccepted {
+		return nil, simError(http.StatusUnprocessableEntity, 42210000, fmt.Sprintf("order is not replaceable in %q state", o.Status))
+	}
+	if o.OrderClass != a.Simple {
+		return nil, simError(http.StatusUnprocessableEntity, 42210000, "only simple orders can be replaced on the simulated venue")
+	}
+
+	req := a.PlaceOrderRequest{
+		Symbol:       o.Symbol,
+		LimitPrice:   o.LimitPrice,
+		StopPrice:    o.StopPrice,
+		TrailPercent: o.TrailPercent,
+		TimeInForce:  o.TimeInForce,
+	}
+	qty, notional := o.Qty, o.Notional
+	if changes.Quantity != nil {
+		qty, notional = changes.Quantity, nil
+	}
+	if changes.LimitPrice != nil {
+		req.LimitPrice = changes.LimitPrice
+	}
+	if changes.StopPrice != nil {
+		req.StopPrice = changes.StopPrice
+	}
+	if changes.TrailPercent != nil {
+		req.TrailPercent = changes.TrailPercent
+	}
+	if changes.TimeInForce != "" {
+		req.TimeInForce = a.TimeInForce(strings.ToLower(changes.TimeInForce))
+	}
+	for name, d := range map[string]*decimal.Decimal{
+		"qty":           qty,
+		"limit_price":   changes.LimitPrice,
+		"stop_price":    changes.StopPrice,
+		"trail_percent": changes.TrailPercent,
+	} {
+		if d != nil && !d.IsPositive() {
+			return nil, simError(http.StatusUnprocessableEntity, 40010001, name+" must be positive")
+		}
+	}
+
+	if qty != nil {
+		switch o.Side {
+		case a.Buy:
+			ref := req.LimitPrice
+			if ref == nil {
+				ref = req.StopPrice
+			}
+			if ref != nil && qty.Mul(*ref).Round(2).GreaterThan(v.cash) {
+				return nil, simError(http.StatusForbidden, 40310000, "insufficient buying power")
+			}
+		case a.Sell:
+			if err := v.checkAvailableLocked(o.Symbol, *qty); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	now := v.now()
+	replacement := v.newOrderLocked(req, o.Side, o.Type, qty, notional, now)
+	replacement.order.Replaces = &o.ID
+	replacement.mark, replacement.triggered = old.mark, old.triggered
+	o.Status = types.OrderStatusReplaced
+	o.ReplacedAt = &now
+	o.ReplacedBy = &replacement.order.ID
+	o.UpdatedAt = now
+
+	if price, err := v.prices.GetPrice(o.Symbol); err == nil && price.IsPositive() {
+		v.evaluateLocked(replacement, price)
+	}
+	return v.snapshotLocked(replacement), nil
+}
+
+// GetOrder matches resting orders and returns a copy of the order with the given id.
+func (v *SimulatedVenue) GetOrder(orderID string) (*a.Order, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	so, ok := v.orders[orderID]
+	if !ok {
+		return nil, simError(http.StatusNotFound, 40410000, "order not found")
+	}
+	v.matchLocked()
+	return v.snapshotLocked(so), nil
+}
+
+// GetOrderByClientOrderID matches resting orders and returns a copy of the
+// order placed with the given client order id.
+func (v *SimulatedVenue) GetOrderByClientOrderID(clientOrderID string) (*a.Order, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	so := v.findClientOrderLocked(clientOrderID)
+	if so == nil {
+		return nil, simError(http.StatusNotFound, 40410000, "order not found")
+	}
+	v.matchLocked()
+	return v.snapshotLocked(so), nil
+}
+
+// findClientOrderLocked returns the order placed with the client order id, or nil.
+func (v *SimulatedVenue) findClientOrderLocked(clientOrderID string) *simOrder {
+	for _, id := range v.sequence {
+		if so := v.orders[id]; so.order.ClientOrderID == clientOrderID {
+			return so
+		}
+	}
+	return nil
+}
+
+// newOrderLocked registers a new open order on the venue.
+func (v *SimulatedVenue) newOrderLocked(req a.PlaceOrderRequest, side a.Side, typ a.OrderType, qty, notional *decimal.Decimal, now time.Time) *simOrder {
+	tif := req.TimeInForce
+	if tif == "" {
+		tif = a.Day
+	}
+	so := &simOrder{
+		order: &a.Order{
+			ID:            uuid.New().String(),
+			ClientOrderID: req.ClientOrderID,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+			SubmittedAt:   now,
+			Symbol:        req.Symbol,
+			AssetClass:    a.USEquity,
+			OrderClass:    a.Simple,
+			Type:          typ,
+			Side:          side,
+			TimeInForce:   tif,
+			Status:        types.OrderStatusNew,
+			Qty:           qty,
+			Notional:      notional,
+			LimitPrice:    req.LimitPrice,
+			StopPrice:     req.StopPrice,
+			TrailPercent:  req.TrailPercent,
+		},
+	}
+	if so.order.ClientOrderID == "" {
+		so.order.ClientOrderID = so.order.ID
+	}
+	v.orders[so.order.ID] = so
+	v.sequence = append(v.sequence, so.order.ID)
+	return so
+}
+
+// matchLocked evaluates open orders in submission order.
+func (v *SimulatedVenue) matchLocked() {
+	for _, id := range v.sequence {
+		so := v.orders[id]
+		if !isOpenStatus(so.order.Status) {
+			continue
+		}
+		price, err := v.prices.GetPrice(so.order.Symbol)
+		if err != nil || !price.IsPositive() {
+			continue
+		}
+		v.evaluateLocked(so, price)
+	}
+}
+
+// evaluateLocked fills the order if it is marketable at the given price.
+func (v *SimulatedVenue) evaluateLocked(so *simOrder, price decimal.Decimal) {
+	o := so.order
+	buy := o.Side == a.Buy
+
+	marketable := false
+	switch o.Type {
+	case a.Market:
+		marketable = true
+	case a.Limit:
+		marketable = limitReached(buy, price, *o.LimitPrice)
+	case a.Stop:
+		marketable = stopReached(buy, price, *o.StopPrice)
+	case a.StopLimit:
+		if !so.triggered {
+			so.triggered = stopReached(buy, price, *o.StopPrice)
+		}
+		marketable = so.triggered && limitReached(buy, price, *o.LimitPrice)
+	case a.TrailingStop:
+		// track the high (sells) or low (buys) water mark and trail the stop behind it
+		if so.mark.IsZero() || (buy && price.LessThan(so.mark)) || (!buy && price.GreaterThan(so.mark)) {
+			so.mark = price
+		}
+		offset := so.mark.Mul(*o.TrailPercent).Div(decimal.NewFromInt(100))
+		stop := so.mark.Sub(offset)
+		if buy {
+			stop = so.mark.Add(offset)
+		}
+		o.HWM = copyDecimal(so.mark)
+		o.StopPrice = copyDecimal(stop.Round(2))
+		marketable = stopReached(buy, price, stop)
+	}
+
+	if marketable {
+		v.fillLocked(so, price)
+	}
+}
+
+func limitReached(buy bool, price, limit decimal.Decimal) bool {
+	if buy {
+		return price.LessThanOrEqual(limit)
+	}
+	return price.GreaterThanOrEqual(limit)
+}
+
+func stopReached(buy bool, price, stop decimal.Decimal) bool {
+	if buy {
+		return price.GreaterThanOrEqual(stop)
+	}
+	return price.LessThanOrEqual(stop)
+}
+
+// fillLocked executes the whole order at price, moves cash and shares, cancels
+// its OCO sibling and activates any held legs.
+func (v *SimulatedVenue) fillLocked(so *simOrder, price decimal.Decimal) {
+	o := so.order
+	var qty, notional decimal.Decimal
+	if o.Notional != nil {
+		notional = o.Notional.Round(2)
+		qty = notional.DivRound(price, 9)
+	} else {
+		qty = *o.Qty
+		notional = qty.Mul(price).Round(2)
+	}
+
+	now := v.now()
+	switch o.Side {
+	case a.Buy:
+		if notional.GreaterThan(v.cash) {
+			o.Status = types.OrderStatusRejected
+			o.FailedAt = &now
+			o.UpdatedAt = now
+			return
+		}
+		v.cash = v.cash.Sub(notional)
+		pos, ok := v.positions[o.Symbol]
+		if !ok {
+			pos = &simPosition{}
+			v.positions[o.Symbol] = pos
+		}
+		pos.qty = pos.qty.Add(qty)
+		pos.costBasis = pos.costBasis.Add(notional)
+	case a.Sell:
+		if v.checkAvailableLocked(o.Symbol, qty) != nil {
+			o.Status = types.OrderStatusRejected
+			o.FailedAt = &now
+			o.UpdatedAt = now
+			return
+		}
+		pos := v.positions[o.Symbol]
+		v.cash = v.cash.Add(notional)
+		// reduce the cost basis proportionally to the shares sold
+		pos.costBasis = pos.costBasis.Sub(pos.costBasis.Mul(qty).DivRound(pos.qty, 9))
+		pos.qty = pos.qty.Sub(qty)
+		if pos.qty.IsZero() {
+			delete(v.positions, o.Symbol)
+		}
+	}
+
+	o.Status = types.OrderStatusFilled
+	o.FilledAt = &now
+	o.UpdatedAt = now
+	o.FilledQty = qty
+	o.FilledAvgPrice = copyDecimal(price)
+	v.fills = append(v.fills, SimulatedFill{
+		OrderID:       o.ID,
+		ClientOrderID: o.ClientOrderID,
+		Symbol:        o.Symbol,
+		Side:          string(o.Side),
+		Quantity:      qty,
+		Price:         price,
+		Notional:      notional,
+		Timestamp:     now,
+	})
+
+	if sibling, ok := v.orders[so.ocoWith]; ok && isOpenStatus(sibling.order.Status) {
+		v.cancelLocked(sibling)
+	}
+	for _, leg := range so.legs {
+		if leg.order.Status != types.OrderStatusHeld {
+			continue
+		}
+		legQty := qty
+		leg.order.Qty = &legQty
+		leg.order.Status = types.OrderStatusNew
+		leg.order.UpdatedAt = now
+	}
+}
+
+// cancelLocked cancels the order and any legs that have not been activated.
+func (v *SimulatedVenue) cancelLocked(so *simOrder) {
+	now := v.now()
+	so.order.Status = types.OrderStatusCanceled
+	so.order.CanceledAt = &now
+	so.order.UpdatedAt = now
+	for _, leg := range so.legs {
+		if leg.order.Status == types.OrderStatusHeld || (so.order.OrderClass == a.OCO && isOpenStatus(leg.order.Status)) {
+			leg.order.Status = types.OrderStatusCanceled
+			leg.order.CanceledAt = &now
+			leg.order.UpdatedAt = now
+		}
+	}
+}
+
+// checkAvailableLocked returns an Alpaca-style error if fewer than qty shares are held.
+func (v *SimulatedVenue) checkAvailableLocked(symbol string, qty decimal.Decimal) error {
+	available := decimal.Zero
+	if pos, ok := v.positions[symbol]; ok {
+		available = pos.qty
+	}
+	if qty.GreaterThan(available) {
+		return simError(http.StatusForbidden, 40310000,
+			fmt.Sprintf("insufficient qty available for order (requested: %s, available: %s)", qty, available))
+	}
+	return nil
+}
+
+// snapshotLocked returns a copy of the order including copies of its legs.
+func (v *SimulatedVenue) snapshotLocked(so *simOrder) *a.Order {
+	out := *so.order
+	out.Legs = nil
+	for _, leg := range so.legs {
+		out.Legs = append(out.Legs, *leg.order)
+	}
+	return &out
+}
+
+// GetAccount values the account at current prices.
+func (v *SimulatedVenue) GetAccount() (*a.Account, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	longValue := decimal.Zero
+	for symbol, pos := range v.positions {
+		price, err := v.prices.GetPrice(symbol)
+		if err != nil {
+			return nil, err
+		}
+		longValue = longValue.Add(pos.qty.Mul(price))
+	}
+	longValue = longValue.Round(2)
+	equity := v.cash.Add(longValue)
+	buyingPower := decimal.Max(v.cash, decimal.Zero)
+
+	return &a.Account{
+		ID:                    v.accountID,
+		AccountNumber:         "SIM-" + v.accountID[:8],
+		Status:                "ACTIVE",
+		Currency:              "USD",
+		Cash:                  v.cash,
+		BuyingPower:           buyingPower,
+		RegTBuyingPower:       buyingPower,
+		DaytradingBuyingPower: buyingPower,
+		EffectiveBuyingPower:  buyingPower,
+		NonMarginBuyingPower:  buyingPower,
+		PortfolioValue:        equity,
+		Equity:                equity,
+		LastEquity:            v.initial,
+		LongMarketValue:       longValue,
+		PositionMarketValue:   longValue,
+		Multiplier:            decimal.NewFromInt(1),
+	}, nil
+}
+
+// GetPositions values every open position at current prices.
+func (v *SimulatedVenue) GetPositions() ([]a.Position, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	symbols := make([]string, 0, len(v.positions))
+	for symbol := range v.positions {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(symbols)
+
+	positions := make([]a.Position, 0, len(symbols))
+	for _, symbol := range symbols {
+		pos := v.positions[symbol]
+		price, err := v.prices.GetPrice(symbol)
+		if err != nil {
+			return nil, err
+		}
+		marketValue := pos.qty.Mul(price).Round(2)
+		unrealized := marketValue.Sub(pos.costBasis)
+		unrealizedPC := decimal.Zero
+		if pos.costBasis.IsPositive() {
+			unrealizedPC = unrealized.DivRound(pos.costBasis, 6)
+		}
+		positions = append(positions, a.Position{
+			Symbol:         symbol,
+			AssetClass:     a.USEquity,
+			Qty:            pos.qty,
+			QtyAvailable:   pos.qty,
+			AvgEntryPrice:  pos.costBasis.DivRound(pos.qty, 6),
+			Side:           "long",
+			MarketValue:    copyDecimal(marketValue),
+			CostBasis:      pos.costBasis,
+			UnrealizedPL:   copyDecimal(unrealized),
+			UnrealizedPLPC: copyDecimal(unrealizedPC),
+			CurrentPrice:   copyDecimal(price),
+		})
+	}
+
+	return positions, nil
+}
+
+// Fills returns every execution recorded by the venue, oldest first.
+func (v *SimulatedVenue) Fills() []SimulatedFill {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	fills := make([]SimulatedFill, len(v.fills))
+	copy(fills, v.fills)
+	return fills
+}
+
+func isOpenStatus(status string) bool {
+	switch status {
+	case types.OrderStatusNew, types.OrderStatusAccepted, types.OrderStatusPendingNew, types.OrderStatusPartiallyFilled:
+		return true
+	}
+	return false
+}
+
+// simError mirrors the error shape returned by the Alpaca API so callers can
+// treat both venues the same way.
+func simError(status, code int, message string) error {
+	return &a.APIError{StatusCode: status, Code: code, Message: message}
+}
+
+func copyDecimal(d decimal.Decimal) *decimal.Decimal {
+	return &d
+}
@@ -0,0 +1,169 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	a "github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
+	"github.com/shopspring/decimal"
+)
+
+func newTestVenue() *SimulatedVenue {
+	return NewSimulatedVenue(decimal.NewFromInt(1000), StaticPrices{
+		"AAPL": decimal.NewFromInt(200),
+		"NVDA": decimal.NewFromInt(100),
+	})
+}
+
+func decimalPtr(v string) *decimal.Decimal {
+	d := decimal.RequireFromString(v)
+	return &d
+}
+
+func TestSimulatedVenueBuyAndSell(t *testing.T) {
+	venue := newTestVenue()
+
+	order, err := venue.PlaceOrder(&types.Trade{ID: "buy-1", Symbol: "AAPL", Action: "BUY", Amount: decimalPtr("500")})
+	if err != nil {
+		t.Fatalf("PlaceOrder(BUY) error = %v", err)
+	}
+	if order.Status != "filled" || !order.FilledQty.Equal(decimal.RequireFromString("2.5")) {
+		t.Errorf("BUY order = %s %s, want filled 2.5", order.Status, order.FilledQty)
+	}
+	if order.ClientOrderID != "buy-1" {
+		t.Errorf("ClientOrderID = %q, want %q", order.ClientOrderID, "buy-1")
+	}
+
+	_, err = venue.PlaceOrder(&types.Trade{ID: "sell-1", Symbol: "AAPL", Action: "SELL", Quantity: decimalPtr("1")})
+	if err != nil {
+		t.Fatalf("PlaceOrder(SELL) error = %v", err)
+	}
+
+	account, err := venue.GetAccount()
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if !account.Cash.Equal(decimal.NewFromInt(700)) {
+		t.Errorf("Cash = %s, want 700", account.Cash)
+	}
+	if !account.Equity.Equal(decimal.NewFromInt(1000)) {
+		t.Errorf("Equity = %s, want 1000", account.Equity)
+	}
+
+	positions, err := venue.GetPositions()
+	if err != nil {
+		t.Fatalf("GetPositions() error = %v", err)
+	}
+	if len(positions) != 1 || !positions[0].QtyAvailable.Equal(decimal.RequireFromString("1.5")) {
+		t.Fatalf("positions = %+v, want 1.5 AAPL", positions)
+	}
+	if !positions[0].CostBasis.Equal(decimal.NewFromInt(300)) {
+		t.Errorf("CostBasis = %s, want 300", positions[0].CostBasis)
+	}
+
+	if got := len(venue.Fills()); got != 2 {
+		t.Errorf("len(Fills()) = %d, want 2", got)
+	}
+}
+
+func TestSimulatedVenueRejects(t *testing.T) {
+	venue := newTestVenue()
+
+	tests := []struct {
+		name  string
+		trade *types.Trade
+		want  int
+	}{
+		{"insufficient buying power", &types.Trade{Symbol: "NVDA", Action: "BUY", Amount: decimalPtr("1000.01")}, 403},
+		{"no position", &types.Trade{Symbol: "NVDA", Action: "SELL", Quantity: decimalPtr("1")}, 403},
+		{"unknown symbol", &types.Trade{Symbol: "NOPE", Action: "BUY", Amount: decimalPtr("10")}, 422},
+	}
+	for _, tt := range tests {
+		_, err := venue.PlaceOrder(tt.trade)
+		var apiErr *a.APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != tt.want {
+			t.Errorf("%s: PlaceOrder() error = %v, want HTTP %d", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestSimulatedVenueBracketOrder(t *testing.T) {
+	prices := StaticPrices{"AAPL": decimal.NewFromInt(200)}
+	venue := NewSimulatedVenue(decimal.NewFromInt(10000), prices)
+
+	order, err := venue.PlaceOrder(&types.Trade{
+		ID:         "bracket-1",
+		Symbol:     "AAPL",
+		Action:     "BUY",
+		Amount:     decimalPtr("1000"),
+		OrderType:  types.OrderTypeLimit,
+		LimitPrice: decimalPtr("190"),
+		TakeProfit: decimalPtr("220"),
+		StopLoss:   decimalPtr("180"),
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder() error = %v", err)
+	}
+	if order.Status != types.OrderStatusNew || order.OrderClass != a.Bracket || len(order.Legs) != 2 {
+		t.Fatalf("order = %s %s with %d legs, want new bracket with 2 legs", order.Status, order.OrderClass, len(order.Legs))
+	}
+	if !order.Qty.Equal(decimal.NewFromInt(5)) {
+		t.Errorf("Qty = %s, want 5 whole shares", order.Qty)
+	}
+
+	// entry fills once the price drops through the limit
+	prices["AAPL"] = decimal.NewFromInt(189)
+	venue.Match()
+	order, _ = venue.GetOrder(order.ID)
+	if order.Status != types.OrderStatusFilled {
+		t.Fatalf("entry status = %s, want filled", order.Status)
+	}
+
+	// the take-profit leg fills and cancels the stop-loss leg
+	prices["AAPL"] = decimal.NewFromInt(225)
+	venue.Match()
+	order, _ = venue.GetOrder(order.ID)
+	tp, sl := order.Legs[0], order.Legs[1]
+	if tp.Status != types.OrderStatusFilled || sl.Status != types.OrderStatusCanceled {
+		t.Errorf("legs = %s/%s, want filled/canceled", tp.Status, sl.Status)
+	}
+
+	positions, _ := venue.GetPositions()
+	if len(positions) != 0 {
+		t.Errorf("positions = %+v, want none after take profit", positions)
+	}
+}
+
+func TestSimulatedVenueTrailingStop(t *testing.T) {
+	prices := StaticPrices{"NVDA": decimal.NewFromInt(100)}
+	venue := NewSimulatedVenue(decimal.NewFromInt(1000), prices)
+	if _, err := venue.PlaceOrder(&types.Trade{Symbol: "NVDA", Action: "BUY", Quantity: decimalPtr("2")}); err != nil {
+		t.Fatalf("PlaceOrder(BUY) error = %v", err)
+	}
+
+	order, err := venue.PlaceOrder(&types.Trade{
+		Symbol:       "NVDA",
+		Action:       "SELL",
+		Quantity:     decimalPtr("2"),
+		OrderType:    types.OrderTypeTrailingStop,
+		TrailPercent: decimalPtr("10"),
+		TimeInForce:  types.TimeInForceGTC,
+	})
+	if err != nil {
+		t.Fatalf("PlaceOrder(SELL) error = %v", err)
+	}
+
+	for _, p := range []int64{120, 110} {
+		prices["NVDA"] = decimal.NewFromInt(p)
+		venue.Match()
+	}
+	if order, _ = venue.GetOrder(order.ID); order.Status != types.OrderStatusNew {
+		t.Fatalf("status = %s at 110, want still open (stop at 108)", order.Status)
+	}
+
+	prices["NVDA"] = decimal.NewFromInt(107)
+	if order, _ = venue.GetOrder(order.ID); order.Status != types.OrderStatusFilled {
+		t.Errorf("status = %s at 107, want filled", order.Status)
+	}
+}
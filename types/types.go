@@ -14,23 +14,170 @@ type Trade struct {
 	Symbol    string           `json:"symbol"`     // stock ticker, e.g. "APPL"
 	Quantity  *decimal.Decimal `json:"quantity"`   // number of shares
 	Amount    *decimal.Decimal `json:"amount"`     // amount of the trade
-	Price     *decimal.Decimal `json:"price"`      // price per share
+	Price     *decimal.Decimal `json:"price"`      // price per share (expected price until the order fills)
 	Action    string           `json:"action"`     // "BUY" or "SELL"
 	Timestamp time.Time        `json:"timestamp"`  // time of the trade
 	ID        string           `json:"order_id"`   // internal id generated by utils.GenerateOrderID()
 	AlpacaID  string           `json:"alpaca_id"`  // order id provided by Alpaca
 	AgentName string           `json:"agent_name"` // name of the agent that made the trade
+	Status    string           `json:"status"`     // last known order status, e.g. "filled"
+	Priority  int              `json:"priority"`   // broker queue class, one of the Priority* constants
+
+	// Order instructions. Empty values mean a plain market order good for the day.
+	OrderType    string           `json:"order_type,omitempty"`    // one of the OrderType* constants
+	LimitPrice   *decimal.Decimal `json:"limit_price,omitempty"`   // required for limit and stop_limit orders
+	StopPrice    *decimal.Decimal `json:"stop_price,omitempty"`    // required for stop and stop_limit orders
+	TrailPercent *decimal.Decimal `json:"trail_percent,omitempty"` // required for trailing_stop orders, e.g. 5 = 5%
+	TimeInForce  string           `json:"time_in_force,omitempty"` // one of the TimeInForce* constants
+	TakeProfit   *decimal.Decimal `json:"take_profit,omitempty"`   // optional take-profit leg limit price
+	StopLoss     *decimal.Decimal `json:"stop_loss,omitempty"`     // optional stop-loss leg stop price
+
+	// ExtendedHours lets the order fill in the pre-market and after-hours sessions; it must be a day limit order.
+	ExtendedHours bool `json:"extended_hours,omitempty"`
+
+	// FilledAt is when the order last filled, nil until it has.
+	FilledAt *time.Time `json:"filled_at,omitempty"`
+}
+
+// Broker queue priority classes for Trade.Priority. Higher classes are served
+// first; a SELL left at PriorityNormal is treated as PriorityRiskReducing.
+const (
+	PriorityNormal       = 0 // regular orders
+	PriorityRiskReducing = 1 // sells that reduce exposure
+	PriorityEmergency    = 2 // liquidation of the whole portfolio
+)
+
+// Order types supported by Trade.OrderType.
+const (
+	OrderTypeMarket       = "market"
+	OrderTypeLimit        = "limit"
+	OrderTypeStop         = "stop"
+	OrderTypeStopLimit    = "stop_limit"
+	OrderTypeTrailingStop = "trailing_stop"
+)
+
+// Time in force values supported by Trade.TimeInForce.
+const (
+	TimeInForceDay = "day"
+	TimeInForceGTC = "gtc"
+	TimeInForceIOC = "ioc"
+	TimeInForceFOK = "fok"
+	TimeInForceOPG = "opg"
+	TimeInForceCLS = "cls"
+)
+
+// Order statuses reported by an ExecutionVenue. These mirror Alpaca's order statuses.
+const (
+	OrderStatusNew             = "new"
+	OrderStatusAccepted        = "accepted"
+	OrderStatusPendingNew      = "pending_new"
+	OrderStatusHeld            = "held"
+	OrderStatusPartiallyFilled = "partially_filled"
+	OrderStatusFilled          = "filled"
+	OrderStatusCanceled        = "canceled"
+	OrderStatusRejected        = "rejected"
+	OrderStatusExpired         = "expired"
+	OrderStatusPendingCancel   = "pending_cancel"
+	OrderStatusPendingReplace  = "pending_replace"
+	OrderStatusReplaced        = "replaced"
+)
+
+// TradeChanges are the parts of an open trade's order that ReplaceTrade can
+// change. Nil and empty fields keep their current values.
+type TradeChanges struct {
+	Quantity     *decimal.Decimal `json:"quantity,omitempty"`
+	LimitPrice   *decimal.Decimal `json:"limit_price,omitempty"`
+	StopPrice    *decimal.Decimal `json:"stop_price,omitempty"`
+	TrailPercent *decimal.Decimal `json:"trail_percent,omitempty"`
+	TimeInForce  string           `json:"time_in_force,omitempty"`
 }
 
+// TradeDecision is one action the model decided on. Everything but the action
+// and the reasoning may be null; the tags shape the schema the model's answer is held to.
 type TradeDecision struct {
-	Symbol    string           `json:"symbol"`    // stock ticker, e.g. "APPL"
-	Quantity  *decimal.Decimal `json:"quantity"`  // number of shares
-	Amount    *decimal.Decimal `json:"amount"`    // amount of the trade
-	Price     *decimal.Decimal `json:"price"`     // price per share
-	Action    string           `json:"action"`    // "BUY" or "SELL"
-	Reasoning string           `json:"reasoning"` // reasoning for the trade decision
+	Symbol       string           `json:"symbol" nullable:"true"`                // stock ticker, e.g. "APPL"
+	Quantity     *decimal.Decimal `json:"quantity" nullable:"true"`              // number of shares
+	Amount       *decimal.Decimal `json:"amount" nullable:"true"`                // amount of the trade
+	Price        *decimal.Decimal `json:"price" nullable:"true"`                 // price per share
+	Action       string           `json:"action" enum:"BUY,SELL,LIQUIDATE,NONE"` // "BUY", "SELL", "LIQUIDATE" or "NONE"
+	Reasoning    string           `json:"reasoning"`                             // reasoning for the trade decision
+	OrderType    string           `json:"order_type" nullable:"true"`            // "market", "limit", "stop", "stop_limit" or "trailing_stop"
+	LimitPrice   *decimal.Decimal `json:"limit_price" nullable:"true"`           // limit price, defaults to price for limit orders
+	StopPrice    *decimal.Decimal `json:"stop_price" nullable:"true"`            // stop trigger price
+	TrailPercent *decimal.Decimal `json:"trail_percent" nullable:"true"`         // trailing stop distance in percent
+	TimeInForce  string           `json:"time_in_force" nullable:"true"`         // "day", "gtc", "ioc", "fok", "opg" or "cls"
+	TakeProfit   *decimal.Decimal `json:"take_profit" nullable:"true"`           // optional take-profit limit price
+	StopLoss     *decimal.Decimal `json:"stop_loss" nullable:"true"`             // optional stop-loss stop price
+}
+
+// TradeDecisions is the model's full response for one tick: its overall
+// reasoning and the actions to take, in the order they are to be placed.
+type TradeDecisions struct {
+	Reasoning string          `json:"reasoning"`
+	Actions   []TradeDecision `json:"actions"`
+}
+
+// AIToolCall is one tool call the model made before deciding, with what the tool returned.
+type AIToolCall struct {
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`        // JSON as the model sent it
+	Result    string `json:"result,omitempty"` // JSON returned to the model
+	Error     string `json:"error,omitempty"`  // returned to the model instead of a result
+}
+
+// AIUsage is what model calls used and cost: one call, or the sum of several
+// with Calls counting them.
+type AIUsage struct {
+	Model            string          `json:"model,omitempty"` // of a single call
+	Calls            int             `json:"calls"`
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+	LatencyMS        int64           `json:"latency_ms"`
+	Cost             decimal.Decimal `json:"cost"`               // in dollars
+	Unpriced         int             `json:"unpriced,omitempty"` // calls whose cost is unknown, not in Cost
+	// Cached counts the calls replayed from the LLM cache. Nothing was spent on
+	// them, so they are in none of the other counts.
+	Cached int `json:"cached,omitempty"`
+}
+
+// Add returns the sum of u and other.
+func (u AIUsage) Add(other AIUsage) AIUsage {
+	sum := AIUsage{
+		Calls:            u.Calls + other.Calls,
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		LatencyMS:        u.LatencyMS + other.LatencyMS,
+		Cost:             u.Cost.Add(other.Cost),
+		Unpriced:         u.Unpriced + other.Unpriced,
+		Cached:           u.Cached + other.Cached,
+	}
+	// the model is kept while every call was to the same one
+	switch {
+	case u.Calls == 0:
+		sum.Model = other.Model
+	case other.Calls == 0 || u.Model == other.Model:
+		sum.Model = u.Model
+	}
+	return sum
 }
 
+// ActionResult is what became of one action of an agent's last decision, so
+// the strategy can be told which parts of a batch went through.
+type ActionResult struct {
+	Action  string `json:"action"`
+	Symbol  string `json:"symbol,omitempty"`
+	OrderID string `json:"order_id,omitempty"`
+	Status  string `json:"status"`          // one of the ActionStatus* constants, or the order status once complete
+	Error   string `json:"error,omitempty"` // why the action was rejected or failed
+}
+
+// Statuses of an ActionResult before its order completes.
+const (
+	ActionStatusRejected  = "rejected"  // failed validation or a cooldown and was never sent to the broker
+	ActionStatusSubmitted = "submitted" // queued with the broker, not complete yet
+	ActionStatusFailed    = "failed"    // the broker or the venue refused the order
+)
+
 // Position represents a current position in a stock
 type Position struct {
 	Symbol      string          `json:"symbol"`
@@ -86,7 +233,84 @@ type Agent interface {
 	SetTickChannel(tick <-chan time.Time)
 }
 
+// Clock is the source of time and market hours for the agents, the broker and
+// the tick scheduler, so live runs, tests and backtests can each supply their own.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel that receives the time once d has passed.
+	After(d time.Duration) <-chan time.Time
+
+	// IsOpen reports whether the market is open now.
+	IsOpen() bool
+
+	// NextOpen returns when the market next opens.
+	NextOpen() time.Time
+
+	// NextClose returns when the market next closes; while it is open that is the current session's close.
+	NextClose() time.Time
+
+	// Phase returns the session phase now, one of the Phase* constants.
+	Phase() string
+}
+
+// Market session phases returned by Clock.Phase, in New York time.
+const (
+	PhaseClosed     = "closed"
+	PhasePreMarket  = "pre_market"  // 4:00 until the open
+	PhaseRegular    = "regular"     // the open until the close, usually 9:30-16:00
+	PhaseAfterHours = "after_hours" // the close until 20:00, or 17:00 on early-close days
+)
+
+// Stepper is implemented by agents that can make a single decision on demand.
+// Backtests drive agents through it so each decision is made, and its trades
+// settled, before the simulated clock moves on.
+type Stepper interface {
+	// Step makes one decision as of now and submits any trades to the agent's broker.
+	Step(ctx context.Context, now time.Time)
+}
+
 // Broker defines the interface for interacting with the trading broker.
 type Broker interface {
-	SubmitTrade(ctx context.Context, trade *Trade, onComplete func(*Trade, *Trade, error), client *alpaca.Client)
+	SubmitTrade(ctx context.Context, trade *Trade, onComplete func(*Trade, *Trade, error), venue ExecutionVenue)
+
+	// CancelTrade cancels an open trade by its trade id. onComplete receives the
+	// trade once its order is canceled, or an error if it could not be (e.g. it filled first).
+	CancelTrade(ctx context.Context, tradeID string, onComplete func(*Trade, error))
+
+	// ReplaceTrade changes the quantity, prices or time in force of an open trade's
+	// order. onComplete receives the updated trade once the venue accepts the replacement.
+	ReplaceTrade(ctx context.Context, tradeID string, changes TradeChanges, onComplete func(*Trade, error))
+
+	// ListOpenTrades returns the agent's trades whose orders are still open, or
+	// every open trade when agentName is empty.
+	ListOpenTrades(agentName string) []Trade
+}
+
+// ExecutionVenue is the place orders are actually executed, e.g. an Alpaca paper account
+// or an in-process simulated exchange. Each agent owns exactly one venue.
+type ExecutionVenue interface {
+	// PlaceOrder submits the trade as an order and returns the venue's view of it.
+	PlaceOrder(trade *Trade) (*alpaca.Order, error)
+
+	// CancelOrder cancels an open order by its venue order id.
+	CancelOrder(orderID string) error
+
+	// ReplaceOrder replaces an open order with a copy carrying the changes. The
+	// old order moves to "replaced" and points at the new one through ReplacedBy.
+	ReplaceOrder(orderID string, changes TradeChanges) (*alpaca.Order, error)
+
+	// GetOrder returns the current state of an order by its venue order id.
+	GetOrder(orderID string) (*alpaca.Order, error)
+
+	// GetOrderByClientOrderID returns an order by the client order id it was placed
+	// with (the Trade.ID), so a retried submission can find an earlier attempt.
+	GetOrderByClientOrderID(clientOrderID string) (*alpaca.Order, error)
+
+	// GetAccount returns the account backing this venue.
+	GetAccount() (*alpaca.Account, error)
+
+	// GetPositions returns all open positions held on this venue.
+	GetPositions() ([]alpaca.Position, error)
 }
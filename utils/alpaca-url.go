@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// DefaultAlpacaBaseURL is the Alpaca paper trading API.
+const DefaultAlpacaBaseURL = "https://paper-api.alpaca.markets"
+
+// DefaultAlpacaDataURL is the Alpaca market data API, the same for paper and live accounts.
+const DefaultAlpacaDataURL = "https://data.alpaca.markets"
+
+var alpacaBaseURL struct {
+	mu  sync.RWMutex
+	url string
+}
+
+// AlpacaBaseURL returns the base URL every Alpaca client is created with: the
+// one set by SetAlpacaBaseURL, else the ALPACA_API env var, else the paper API.
+func AlpacaBaseURL() string {
+	alpacaBaseURL.mu.RLock()
+	url := alpacaBaseURL.url
+	alpacaBaseURL.mu.RUnlock()
+
+	if url == "" {
+		url = os.Getenv("ALPACA_API")
+	}
+	if url == "" {
+		url = DefaultAlpacaBaseURL
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// AlpacaDataURL returns the base URL market data clients are created with: the
+// one set by SetAlpacaBaseURL, which then serves both APIs, else the
+// ALPACA_DATA_API env var, else the market data API.
+func AlpacaDataURL() string {
+	alpacaBaseURL.mu.RLock()
+	url := alpacaBaseURL.url
+	alpacaBaseURL.mu.RUnlock()
+
+	if url == "" {
+		url = os.Getenv("ALPACA_DATA_API")
+	}
+	if url == "" {
+		url = DefaultAlpacaDataURL
+	}
+	return strings.TrimSuffix(url, "/")
+}
+
+// SetAlpacaBaseURL points every Alpaca client created afterwards at url, e.g. a
+// fake server in tests. An empty url goes back to ALPACA_API or the paper API.
+func SetAlpacaBaseURL(url string) {
+	alpacaBaseURL.mu.Lock()
+	defer alpacaBaseURL.mu.Unlock()
+	alpacaBaseURL.url = url
+}
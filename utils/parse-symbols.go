@@ -2,30 +2,32 @@ package utils
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
 )
 
 var (
 	Symbols []string
-)
 
-func ParseSymbols() error {
-	d, err := alpaca.NewClient(alpaca.ClientOpts{
-		APIKey:    os.Getenv("ALPACA_KEY_RNG"),
-		APISecret: os.Getenv("ALPACA_SECRET_RNG"),
-		BaseURL:   "https://paper-api.alpaca.markets",
-	}).GetAssets(alpaca.GetAssetsRequest{
-		Status:     "active",
-		AssetClass: "us_equity",
-	})
-	if err != nil {
-		return err
+	// DefaultSymbols is a small universe of liquid, fractionable tickers used
+	// when running offline against the simulated exchange.
+	DefaultSymbols = []string{
+		"AAPL", "AMD", "AMZN", "DIA", "GOOGL", "IWM", "JPM", "META",
+		"MSFT", "NVDA", "QQQ", "SPY", "TSLA", "V", "XOM",
 	}
+)
+
+// UseDefaultSymbols sets Symbols to DefaultSymbols without calling Alpaca.
+func UseDefaultSymbols() {
+	Symbols = make([]string, len(DefaultSymbols))
+	copy(Symbols, DefaultSymbols)
+}
 
-	symbols := make([]string, 0, len(d))
-	for _, asset := range d {
+// ParseSymbols sets Symbols to the active, tradable and fractionable assets
+// among those listed, e.g. the US equities from services.LoadSymbols.
+func ParseSymbols(assets []alpaca.Asset) error {
+	symbols := make([]string, 0, len(assets))
+	for _, asset := range assets {
 		if asset.Fractionable && asset.Tradable && asset.Status == alpaca.AssetActive {
 			symbols = append(symbols, asset.Symbol)
 		}
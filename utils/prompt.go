@@ -3,85 +3,146 @@ package utils
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
 	"github.com/dickeyy/cis-320/types"
 )
 
-var (
-	systemPromptPath = "prompts/system-prompt.txt"
+// PromptsDir is where the prompt templates are read from.
+var PromptsDir = "prompts"
+
+const (
+	systemPromptFile = "system-prompt.tmpl"
+	userPromptFile   = "user-prompt.tmpl"
 )
 
+// UserPromptTemplateData is what prompts/user-prompt.tmpl is rendered with.
+// Everything is preformatted text, so the template only lays it out.
 type UserPromptTemplateData struct {
-	AccountJSON     string `json:"account_json"`
-	HoldingsJSON    string `json:"holdings_json"`
-	BuyingPower     string `json:"buying_power"`
-	PortfolioValue  string `json:"portfolio_value"`
-	TradableSymbols string `json:"tradable_symbols"`
+	AccountSummary    string // portfolio value, buying power, cash, position values and status, one per line
+	Holdings          string // one numbered line per position, empty without any
+	BuyingPower       string // in USD
+	PortfolioValue    string // in USD
+	TradableSymbols   string // the symbols the agent may trade, comma separated
+	PreviousResponses string // the model's last responses, oldest first, one per line
+	LastError         string // why the last decision failed, "None" if it did not
+	LastActions       string // one numbered line per action of the last decision with what became of it, "None" without any
+}
+
+// Prompt is a rendered prompt and the version its template declares, so
+// decisions can be attributed to the prompt they were made with.
+type Prompt struct {
+	Text    string
+	Version string
+}
+
+// promptTemplate is a prompt file. Every file defines a "version" template
+// holding its version, which is raised with every edit.
+type promptTemplate struct {
+	tmpl    *template.Template
+	version string
+}
+
+var (
+	promptsMu sync.Mutex
+	prompts   = make(map[string]*promptTemplate)
+)
+
+// loadPromptTemplate parses the named file in PromptsDir once.
+func loadPromptTemplate(name string) (*promptTemplate, error) {
+	path := filepath.Join(PromptsDir, name)
+
+	promptsMu.Lock()
+	defer promptsMu.Unlock()
+	if p, ok := prompts[path]; ok {
+		return p, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").ParseFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if tmpl.Lookup("version") == nil {
+		return nil, fmt.Errorf("prompt template %s does not define its version", path)
+	}
+	var version strings.Builder
+	if err := tmpl.ExecuteTemplate(&version, "version", nil); err != nil {
+		return nil, fmt.Errorf("prompt template %s: %w", path, err)
+	}
+	p := &promptTemplate{tmpl: tmpl, version: strings.TrimSpace(version.String())}
+	if p.version == "" {
+		return nil, fmt.Errorf("prompt template %s has an empty version", path)
+	}
+	prompts[path] = p
+	return p, nil
 }
 
-func GetSystemPrompt() (string, error) {
-	prompt, err := os.ReadFile(systemPromptPath)
+func renderPrompt(name string, data any) (Prompt, error) {
+	p, err := loadPromptTemplate(name)
 	if err != nil {
-		return "", err
+		return Prompt{}, err
+	}
+	var b strings.Builder
+	if err := p.tmpl.Execute(&b, data); err != nil {
+		return Prompt{}, fmt.Errorf("failed to render %s: %w", name, err)
 	}
-	return string(prompt), nil
+	return Prompt{Text: b.String(), Version: p.version}, nil
+}
+
+// GetSystemPrompt renders prompts/system-prompt.tmpl.
+func GetSystemPrompt() (Prompt, error) {
+	return renderPrompt(systemPromptFile, nil)
 }
 
-// TODO: Test this out make sure it actually works and gives an output that the LLM can understand
-func GetUserPrompt(agentState *types.AgentState, previousResponses []string, lastError error) (string, error) {
+// GetUserPrompt renders prompts/user-prompt.tmpl with the agent's state, the
+// symbols it may trade and what came of its last decisions.
+func GetUserPrompt(agentState *types.AgentState, symbols []string, previousResponses []string, lastError error, lastActions []types.ActionResult) (Prompt, error) {
 	agentState.Mu.Lock()
-	defer agentState.Mu.Unlock()
-
-	accountSummary := prepAccountSummary(agentState.Account)
-	holdingsString := prepHoldingsString(agentState.Holdings)
-
-	// get the data we need
-	buyingPower := agentState.Account.BuyingPower.String()
-	portfolioValue := agentState.Account.PortfolioValue.String()
-
-	userPrompt := fmt.Sprintf(`Analyze the current market context and your portfolio to make a trading decision.
-
----
-**Current Portfolio State:**
-
-**Account Summary:**
-%s
-
-**Current Holdings:**
-%s
-
----
-**Decision Parameters:**
-- Available buying power: %s USD
-- Current total portfolio value: %s USD
-- List of your previous trades: %s
-- Last trade error: %s
----
-**Based on the above information and your directives, generate a single JSON object representing your optimal trading decision or no action.**`,
-		accountSummary,
-		holdingsString,
-		buyingPower,
-		portfolioValue,
-		normalizePreviousResponses(previousResponses),
-		formatLastError(lastError),
-	)
+	data := UserPromptTemplateData{
+		AccountSummary:    prepAccountSummary(agentState.Account),
+		Holdings:          prepHoldingsString(agentState.Holdings),
+		BuyingPower:       agentState.Account.BuyingPower.String(),
+		PortfolioValue:    agentState.Account.PortfolioValue.String(),
+		TradableSymbols:   formatSymbols(symbols),
+		PreviousResponses: normalizePreviousResponses(previousResponses),
+		LastError:         formatLastError(lastError),
+		LastActions:       formatLastActions(lastActions),
+	}
+	agentState.Mu.Unlock()
+
+	userPrompt, err := renderPrompt(userPromptFile, data)
+	if err != nil {
+		return Prompt{}, err
+	}
 
 	// write the user prompt to a file
 	if DevMode {
-		err := os.WriteFile("prompts/example-user-prompt.txt", []byte(userPrompt), 0644)
+		err := os.WriteFile(filepath.Join(PromptsDir, "example-user-prompt.txt"), []byte(userPrompt.Text), 0644)
 		if err != nil {
-			return "", fmt.Errorf("failed to write user prompt to file: %w", err)
+			return Prompt{}, fmt.Errorf("failed to write user prompt to file: %w", err)
 		}
 	}
 
 	return userPrompt, nil
 }
 
+func formatSymbols(symbols []string) string {
+	if len(symbols) == 0 {
+		return "None"
+	}
+	return strings.Join(symbols, ", ")
+}
+
+// MaxPreviousResponses is how many of the model's last responses the user prompt shows.
+const MaxPreviousResponses = 50
+
 func normalizePreviousResponses(previousResponses []string) string {
-	if len(previousResponses) > 50 {
-		previousResponses = previousResponses[len(previousResponses)-50:]
+	if len(previousResponses) > MaxPreviousResponses {
+		previousResponses = previousResponses[len(previousResponses)-MaxPreviousResponses:]
 	}
 	return strings.Join(previousResponses, "\n")
 }
@@ -121,3 +182,23 @@ func formatLastError(lastError error) string {
 	}
 	return lastError.Error()
 }
+
+// formatLastActions lists each action of the last decision with what became of it.
+func formatLastActions(lastActions []types.ActionResult) string {
+	if len(lastActions) == 0 {
+		return "None"
+	}
+	var b strings.Builder
+	for i, r := range lastActions {
+		b.WriteString(fmt.Sprintf("%d. %s", i+1, r.Action))
+		if r.Symbol != "" {
+			b.WriteString(" " + r.Symbol)
+		}
+		b.WriteString(": " + r.Status)
+		if r.Error != "" {
+			b.WriteString(" (" + r.Error + ")")
+		}
+		b.WriteString("\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -1,9 +1,14 @@
 package utils
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
+	"github.com/dickeyy/cis-320/types"
 	"github.com/shopspring/decimal"
 )
 
@@ -36,3 +41,62 @@ func TestPrepHoldingsString(t *testing.T) {
 		t.Errorf("prepHoldingsString() = %q, want %q", got, want)
 	}
 }
+
+func usePromptsDir(t *testing.T, dir string) {
+	t.Helper()
+	previous := PromptsDir
+	PromptsDir = dir
+	t.Cleanup(func() { PromptsDir = previous })
+}
+
+func TestGetUserPrompt(t *testing.T) {
+	usePromptsDir(t, filepath.Join("..", "prompts"))
+	state := &types.AgentState{Account: alpaca.Account{BuyingPower: decimal.NewFromInt(5000), PortfolioValue: decimal.NewFromInt(100000)}}
+	actions := []types.ActionResult{{Action: "BUY", Symbol: "NVDA", Status: types.ActionStatusRejected, Error: "cooldown"}}
+
+	got, err := GetUserPrompt(state, []string{"AAPL", "NVDA"}, []string{"bought"}, errors.New("insufficient funds"), actions)
+	if err != nil {
+		t.Fatalf("GetUserPrompt() error = %v", err)
+	}
+	if got.Version == "" {
+		t.Error("GetUserPrompt() has no version")
+	}
+	for _, want := range []string{
+		"Available buying power: 5000 USD",
+		"Current total portfolio value: 100000 USD",
+		"Tradable symbols: AAPL, NVDA",
+		"List of your previous trades: bought",
+		"Last trade error: insufficient funds",
+		"1. BUY NVDA: rejected (cooldown)",
+		"**Current Holdings:**\nNone\n",
+	} {
+		if !strings.Contains(got.Text, want) {
+			t.Errorf("user prompt does not contain %q:\n%s", want, got.Text)
+		}
+	}
+
+	system, err := GetSystemPrompt()
+	if err != nil || system.Version == "" || !strings.HasPrefix(system.Text, "You are an autonomous stock trading strategist") {
+		t.Errorf("GetSystemPrompt() = %q (version %q), %v", system.Text[:min(len(system.Text), 60)], system.Version, err)
+	}
+}
+
+func TestPromptTemplateVersion(t *testing.T) {
+	dir := t.TempDir()
+	usePromptsDir(t, dir)
+	write := func(name, text string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(text), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("versioned.tmpl", "{{define \"version\"}} 7 {{end -}}\nHello {{.}}")
+	write("unversioned.tmpl", "Hello {{.}}")
+
+	got, err := renderPrompt("versioned.tmpl", "world")
+	if err != nil || got.Text != "Hello world" || got.Version != "7" {
+		t.Errorf("renderPrompt(versioned) = %+v, %v, want Hello world at version 7", got, err)
+	}
+	if _, err := renderPrompt("unversioned.tmpl", "world"); err == nil {
+		t.Error("renderPrompt() of a template without a version succeeded")
+	}
+}
@@ -1,28 +1,22 @@
 package utils
 
 import (
-	"os"
-
-	"github.com/alpacahq/alpaca-trade-api-go/v3/alpaca"
-	"github.com/rs/zerolog/log"
+	"time"
+	_ "time/tzdata" // embed the tz database so New York time works on any host
 )
 
-func IsTradingHours() bool {
-	if DevMode {
-		return true
-	}
-
-	open, err := alpaca.NewClient(alpaca.ClientOpts{
-		// use RNG api creds (doesnt really matter we just need some valid creds)
-		APIKey:    os.Getenv("ALPACA_KEY_RNG"),
-		APISecret: os.Getenv("ALPACA_SECRET_RNG"),
-		BaseURL:   "https://paper-api.alpaca.markets",
-	}).GetClock()
+// MarketLocation is the exchange time zone; US equity sessions and trading days follow New York time.
+var MarketLocation = mustLoadLocation("America/New_York")
 
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
 	if err != nil {
-		log.Error().Err(err).Msg("Error getting clock")
-		return false
+		panic(err)
 	}
+	return loc
+}
 
-	return open.IsOpen
+// MarketDate returns the trading date of t in the exchange time zone, e.g. "2025-10-06".
+func MarketDate(t time.Time) string {
+	return t.In(MarketLocation).Format("2006-01-02")
 }